@@ -1,5 +1,7 @@
 #!/bin/bash
 export GOPATH=`pwd`
+mkdir -p src/github.com/RobinUS2/cloudpelican-lsd
+ln -sfn "$(pwd)" src/github.com/RobinUS2/cloudpelican-lsd/cli # Console package of this repository
 go get -u "github.com/carmark/pseudo-terminal-go/terminal"
 go get -u "github.com/mgutz/ansi"
 go build $@ .
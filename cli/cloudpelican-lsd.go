@@ -5,7 +5,7 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -16,38 +16,47 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"time"
 
+	"github.com/RobinUS2/cloudpelican-lsd/cli/console"
 	terminal "github.com/carmark/pseudo-terminal-go/terminal"
 )
 
 var customConfPath string
+var caFile string
+var pinSha256 string
+var outputFormat string
 var verbose bool
 
 const CONSOLE_PREFIX string = "cloudpelican"
+const REDACTED string = "***"
 const CONSOLE_SEP string = "> "
-const TMP_FILTER_PREFIX string = "__tmp__"
+
+var identifiedByRegex = regexp.MustCompile(`(?i)(identified\s+by\s+)('[^']*'|"[^"]*"|\S+)`)
+var secretConfRegex = regexp.MustCompile(`(?i)(\S*(?:password|secret|token|key)\S*)=\S*`)
 
 var CONSOLE_KEYWORDS map[string]bool = make(map[string]bool)
 var CONSOLE_KEYWORDS_OPTS map[string]int = make(map[string]int)
 
-var session map[string]string = make(map[string]string)
-var supervisorCon *SupervisorCon
+var cons *console.Console
 var conf *Conf
 var consecutiveInterruptCount int
 var interruptMux sync.RWMutex
-var interrupted bool
+var interruptCancel context.CancelFunc
 var startupCommands string
 var silent bool
 var allowAutoCreateFilter bool
-var stats *Statistics
 var term *terminal.Terminal
 var oldState *terminal.State
-var cmdFinishChan chan bool
 var multiLineInput bool = false
 var terminalRaw bool
 var nonInteractive bool = false
 
+// Line of the terminal, read in the background so running commands can be interrupted
+type consoleLine struct {
+	line string
+	err  error
+}
+
 func init() {
 	flag.StringVar(&customConfPath, "c", "", "Path to configuration file (default in your home folder)")
 	flag.StringVar(&startupCommands, "e", "", "Commands to execute, seperated by semi-colon")
@@ -55,6 +64,9 @@ func init() {
 	flag.BoolVar(&terminalRaw, "raw-terminal", true, "Raw terminal mode")
 	flag.BoolVar(&silent, "silent", true, "Silent, no helping output mode")
 	flag.BoolVar(&allowAutoCreateFilter, "allow-temporary-filters", true, "Automatically create temporary filters from select statements")
+	flag.StringVar(&caFile, "ca-file", "", "CA bundle to verify the TLS certificate of the supervisor (optional)")
+	flag.StringVar(&pinSha256, "pin-sha256", "", "SHA-256 of the public key of the supervisor certificate, comma separated, hex or base64 (optional)")
+	flag.StringVar(&outputFormat, "format", "", "Output format of query results: table, raw, json, csv or tsv (default table, raw with -e)")
 	flag.Parse()
 }
 
@@ -63,29 +75,42 @@ func main() {
 		log.Println("Starting CloudPelican Log Stream Dump (LSD)")
 	}
 
+	// Output format
+	if len(outputFormat) > 0 {
+		if err := console.ValidateOutputFormat(outputFormat); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Startup commands
+	if len(startupCommands) > 0 {
+		nonInteractive = true // Non-interactive mode
+		terminalRaw = false   // Disable terminal raw mode
+	}
+
+	// Console, the session is restored from the config
+	console.Verbose = verbose
+	cons = console.NewConsole(os.Stdout)
+	cons.Silent = silent
+	cons.Interactive = !nonInteractive
+	cons.Terminal = terminalRaw
+	cons.OutputFormat = outputFormat
+	cons.AllowTemporaryFilters = allowAutoCreateFilter
+	cons.AllowFiles = true
+	cons.SaveSession = func() {
+		conf.PersistentSession = cons.Session
+		conf.Save()
+	}
+
 	// Load config
 	loadConf()
 	if verbose {
 		log.Println("Loaded conf")
 	}
 
-	// Stats
-	stats = newStatistics()
-	if verbose {
-		log.Println("Init stats")
-	}
-
-	// Wait channel
-	cmdFinishChan = make(chan bool, 1)
-
 	// Startup commands
 	if len(startupCommands) > 0 {
-		nonInteractive = true // Non-interactive mode
-		terminalRaw = false   // Disable terminal raw mode
-		wait := handleConsole(startupCommands)
-		if wait {
-			<-cmdFinishChan
-		}
+		handleConsole(context.Background(), startupCommands)
 		restoreTerminalAndExit(term, oldState)
 	} else {
 		// Listen for user input
@@ -105,6 +130,12 @@ func startConsole() {
 	CONSOLE_KEYWORDS["clearhistory"] = true
 	CONSOLE_KEYWORDS["history"] = true
 	CONSOLE_KEYWORDS["show filters"] = true
+	CONSOLE_KEYWORDS["show alerts"] = true
+	CONSOLE_KEYWORDS["show schedules"] = true
+	CONSOLE_KEYWORDS["show users"] = true
+	CONSOLE_KEYWORDS["show tokens"] = true
+	CONSOLE_KEYWORDS["show backends"] = true
+	CONSOLE_KEYWORDS["logout"] = true
 
 	CONSOLE_KEYWORDS_OPTS["connect"] = 2              // connect + uri
 	CONSOLE_KEYWORDS_OPTS["tail"] = 2                 // tail + filter name
@@ -112,6 +143,11 @@ func startConsole() {
 	CONSOLE_KEYWORDS_OPTS["stats"] = 2                // stats + filter name
 	CONSOLE_KEYWORDS_OPTS["describe filter"] = 3      // describe filter + filter name
 	CONSOLE_KEYWORDS_OPTS["configure supervisor"] = 3 // configure supervisor + k=v
+	CONSOLE_KEYWORDS_OPTS["set format"] = 3           // set format + format
+	CONSOLE_KEYWORDS_OPTS["use backend"] = 3          // use backend + backend name
+	CONSOLE_KEYWORDS_OPTS["show schedule"] = 3        // show schedule + schedule name
+	CONSOLE_KEYWORDS_OPTS["pause schedule"] = 3       // pause schedule + schedule name
+	CONSOLE_KEYWORDS_OPTS["resume schedule"] = 3      // resume schedule + schedule name
 
 	// Console reader
 	if terminalRaw {
@@ -138,10 +174,20 @@ func startConsole() {
 		}
 	}
 
+	// Read lines in the background, this allows to interrupt running commands
+	lines := make(chan consoleLine)
+	go func() {
+		for {
+			line, err := term.ReadLine()
+			lines <- consoleLine{line: line, err: err}
+		}
+	}()
+
 	// Main loop
 	var lineBuffer bytes.Buffer
 	for {
-		line, err := term.ReadLine()
+		l := <-lines
+		line, err := l.line, l.err
 		if err == io.EOF {
 			term.Write([]byte(line))
 			fmt.Println()
@@ -165,11 +211,10 @@ func startConsole() {
 					lineBuffer.Reset()
 
 					// Execute the command
-					handleConsole(input)
+					executeInteractive(input, lines)
 
-					// Reset the interrupted flag
+					// Reset the interrupt count
 					interruptMux.Lock()
-					interrupted = false
 					consecutiveInterruptCount = 0
 					interruptMux.Unlock()
 
@@ -183,11 +228,45 @@ func startConsole() {
 	}
 }
 
+// Execute the input while reading the terminal, ^C cancels the command (e.g. tail)
+func executeInteractive(input string, lines chan consoleLine) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interruptMux.Lock()
+	interruptCancel = cancel
+	interruptMux.Unlock()
+
+	done := make(chan bool, 1)
+	go func() {
+		handleConsole(ctx, input)
+		done <- true
+	}()
+	for {
+		select {
+		case <-done:
+			interruptMux.Lock()
+			interruptCancel = nil
+			interruptMux.Unlock()
+			return
+		case l := <-lines:
+			if l.err == io.EOF {
+				restoreTerminalAndExit(term, oldState)
+			}
+			if l.err != nil && strings.Contains(l.err.Error(), "control-c break") {
+				handleInterrupt()
+			}
+		}
+	}
+}
+
 func handleInterrupt() {
 	// sig is a ^C, handle it
 	interruptMux.Lock()
-	interrupted = true
 	consecutiveInterruptCount++
+	if interruptCancel != nil {
+		// Abort long-polling request
+		interruptCancel()
+	}
 	if consecutiveInterruptCount >= 2 {
 		interruptMux.Unlock()
 		fmt.Printf("Exiting\n")
@@ -197,7 +276,8 @@ func handleInterrupt() {
 	}
 }
 
-func _handleConsole(input string) bool {
+// Commands of the cli itself, all other commands are executed by the console
+func _handleConsole(ctx context.Context, input string) {
 	input = strings.TrimSpace(input)
 	consoleAddHistory(input)
 	inputLower := strings.ToLower(input)
@@ -209,440 +289,38 @@ func _handleConsole(input string) bool {
 		clearConsole()
 	} else if inputLower == "save" {
 		save()
-	} else if inputLower == "ping" {
-		ping()
 	} else if inputLower == "clearsession" {
 		clearSession()
 	} else if inputLower == "clearhistory" {
 		clearhistory()
 	} else if inputLower == "history" {
 		printHistory()
-	} else if inputLower == "show filters" {
-		showFilters()
-	} else if strings.Index(inputLower, "select ") == 0 {
-		executeSelect(inputLower, nil)
-		return true
-	} else if strings.Index(inputLower, "cat ") == 0 {
-		executeGrepSQL(inputLower)
-	} else if strings.Index(inputLower, "create filter ") == 0 {
-		createFilter(inputLower)
-	} else if strings.Index(inputLower, "drop filter ") == 0 {
-		split := strings.SplitN(input, "drop filter ", 2)
-		if len(split) != 2 {
-			printConsoleError(input)
-			return false
-		}
-		dropFilter(split[1])
 	} else if strings.Index(inputLower, "history ") == 0 {
 		split := strings.SplitN(input, "history ", 2)
 		if len(split) != 2 {
 			printConsoleError(input)
-			return false
-		}
-		dispatchHistory(split[1])
-	} else if strings.Index(inputLower, "connect ") == 0 {
-		split := strings.SplitN(input, "connect ", 2)
-		if len(split) != 2 {
-			printConsoleError(input)
-			return false
-		}
-		connect(split[1])
-	} else if strings.Index(inputLower, "tail ") == 0 {
-		split := strings.SplitN(input, "tail ", 2)
-		if len(split) != 2 {
-			printConsoleError(input)
-			return false
-		}
-		opts := make(map[string]string)
-		opts["tail"] = "1"
-		executeSelect(strings.ToLower(fmt.Sprintf("SELECT * FROM %s", split[1])), opts)
-		return true
-	} else if strings.Index(inputLower, "search ") == 0 {
-		split := strings.SplitN(input, "search ", 2)
-		if len(split) != 2 {
-			printConsoleError(input)
-			return false
-		}
-		search(split[1])
-		return false
-	} else if strings.Index(inputLower, "stats ") == 0 {
-		split := strings.SplitN(input, "stats ", 2)
-		if len(split) < 2 {
-			printConsoleError(input)
-			return false
-		}
-		getStats(split[1])
-		return false
-	} else if strings.Index(inputLower, "describe filter ") == 0 {
-		split := strings.SplitN(input, "describe filter ", 2)
-		if len(split) != 2 {
-			printConsoleError(input)
-			return false
-		}
-		describeFilter(split[1])
-		return false
-	} else if strings.Index(inputLower, "configure supervisor ") == 0 {
-		split := strings.SplitN(input, "configure supervisor ", 2)
-		if len(split) != 2 {
-			printConsoleError(input)
-			return false
-		}
-		configureSupervisor(split[1])
-		return false
-	} else if strings.Index(inputLower, "auth ") == 0 {
-		split := strings.Split(input, " ")
-		if len(split) != 3 {
-			printConsoleError(input)
-			return false
+			return
 		}
-		auth(split[1], split[2])
+		dispatchHistory(ctx, split[1])
 	} else {
-		printConsoleError(input)
-	}
-	return false
-}
-
-func executeGrepSQL(in string) {
-	gsql := newGrepSQL(in)
-	q, e := gsql.Parse()
-	if e != nil {
-		printConsoleError(fmt.Sprintf("%s", e))
-		return
-	}
-
-	// Execute
-	data, err := supervisorCon.Search(q)
-	if err != nil {
-		printConsoleError(fmt.Sprintf("Search failed '%s'", err))
-		return
-	}
-	fmt.Print(data)
-}
-
-func search(q string) {
-	// Smart query builder
-	q = strings.ToLower(q)
-	fromRegex := regexp.MustCompile("(?i)from([ ]+)[a-z0-9_]+")
-	fromMatch := fromRegex.FindString(q)
-	if len(fromMatch) > 0 {
-		fromSplit := strings.SplitN(fromMatch, " ", 2)
-		if len(fromSplit) >= 2 {
-			filter, _ := supervisorCon.FilterByName(fromSplit[1])
-			if filter != nil {
-				newTable := filter.GetSearchTableName()
-				q = strings.Replace(q, fromMatch, fmt.Sprintf("from %s", newTable), 1)
-			}
-		}
-	}
-
-	// Select * for now to fixed columns
-	q = strings.Replace(q, "select *", "select _raw", 1)
-
-	// Execute
-	data, err := supervisorCon.Search(q)
-	if err != nil {
-		printConsoleError(fmt.Sprintf("Search failed '%s'", err))
-		return
+		cons.ExecuteCommand(ctx, input)
 	}
-	fmt.Print(data)
 }
 
-func configureSupervisor(kv string) {
-	split := strings.SplitN(kv, "=", 2)
-	if len(split) != 2 {
-		printConsoleError(fmt.Sprintf("You must provide a key=value pair, provided '%s'", kv))
-		return
-	}
-	_, err := supervisorCon.SetSupervisorConfig(split[0], split[1])
-	if err != nil {
-		printConsoleError(fmt.Sprintf("Failed to configure: %s", err))
-	}
-}
-
-// Select execution, example input: "create filter <filter_name> as '<regex_here>' [with options {"key": "value"}]" [] indicates optional
-func createFilter(input string) {
-	// Basic parsing
-	var filterName string = ""
-	var regex string = ""
-	tokens := strings.Split(input, " ")
-	for i, token := range tokens {
-		var previousToken string = ""
-		if i != 0 {
-			previousToken = tokens[i-1]
-		}
-
-		// Very simple parsing
-		if previousToken == "filter" && tokens[i-2] == "create" {
-			// Filter name
-			filterName = strings.TrimSpace(token)
-		} else if previousToken == "as" {
-			regex = strings.TrimRight(strings.TrimLeft(token, "'"), "'")
-		}
-	}
-
-	// Filter name
-	if len(filterName) < 1 {
-		printConsoleError(fmt.Sprintf("You must provide a filter name", filterName))
-		return
-	}
-	if isUuid(filterName) {
-		printConsoleError(fmt.Sprintf("Filter name can not be in form of a UUID", filterName))
-		return
-	}
-
-	// Validate filter name
-	matched, _ := regexp.MatchString("^([a-z0-9_]+)$", filterName)
-	if !matched {
-		printConsoleError("Filter name can only contain a-z, 0-9 and _")
-		return
-	}
-
-	// Check duplicate name filter
-	filterdup, _ := supervisorCon.FilterByName(filterName)
-	if filterdup != nil {
-		printConsoleError(fmt.Sprintf("There already exist a filter with the name %s", filterName))
-		return
-	}
-
-	// Create
-	_, filterErr := supervisorCon.CreateFilter(filterName, regex)
-	if filterErr != nil {
-		printConsoleError("Failed to create filter")
-		return
-	}
-	fmt.Printf("Created filter '%s'\n", filterName)
-}
-
-// Drop filter (removing it)
-func dropFilter(name string) {
-	res := supervisorCon.RemoveFilter(name)
-	if res {
-		fmt.Printf("Removed filter '%s'\n", name)
-	}
-}
-
-func handleConsole(input string) bool {
+func handleConsole(ctx context.Context, input string) {
 	input = strings.TrimRight(input, " ;\n\t")
 	if len(input) < 1 {
-		return false
-	}
-	cmds := strings.Split(input, ";")
-	var wait bool
-	for _, cmd := range cmds {
-		subWait := _handleConsole(cmd)
-		if subWait {
-			wait = true
-		}
-	}
-	return wait
-}
-
-func showFilters() {
-	filters, err := supervisorCon.Filters()
-	if err != nil {
-		printConsoleError(fmt.Sprintf("%s", err))
 		return
 	}
-	fmt.Printf("FILTER NAME\n")
-	for _, filter := range filters {
-		if strings.HasPrefix(filter.Name, TMP_FILTER_PREFIX) {
-			continue
-		}
-		fmt.Printf("%s\n", filter.Name)
-	}
-}
-
-// Select execution, example input: "select * from <filter_name> [limit 1234]" [] indicates optional
-// example input from stream: "select * from stream:<stream_name> [limit 1234]" [] indicates optional
-func executeSelect(input string, opts map[string]string) {
-	// Basic parsing
-	var filterName string = ""
-	var where string = ".*"
-	var limitStr string = ""
-	tokens := strings.Split(input, " ")
-	for i, token := range tokens {
-		var previousToken string = ""
-		if i != 0 {
-			previousToken = tokens[i-1]
-		}
-
-		// Very simple parsing
-		if previousToken == "from" {
-			// Filter name
-			filterName = token
-		} else if previousToken == "limit" {
-			// Limit
-			limitStr = token
-		} else if previousToken == "where" {
-			// WHERE statement
-			where = strings.TrimRight(strings.TrimLeft(token, "'"), "'")
-		}
-	}
-
-	// Limit
-	var limit int64 = -1
-	var limitErr error
-	if len(limitStr) > 0 {
-		limit, limitErr = strconv.ParseInt(limitStr, 10, 0)
-		if limitErr != nil {
-			printConsoleError(fmt.Sprintf("Invalid LIMIT %s", limitErr))
-			return
-		}
-	}
-
-	// Filter name
-	if len(filterName) < 1 {
-		printConsoleError(fmt.Sprintf("Filter '%s' not found", filterName))
-		return
-	}
-
-	// Load filter
-	var tmpFilterName string = ""
-	filter, filterE := supervisorCon.FilterByName(filterName)
-	if filterE != nil {
-		if allowAutoCreateFilter == false {
-			printConsoleError(fmt.Sprintf("%s", filterE))
+	for _, cmd := range console.SplitCommands(input) {
+		if ctx.Err() != nil {
 			return
-		} else {
-			// Auto create filter from stream
-			split := strings.Split(filterName, "stream:")
-			if len(split) != 2 || split[1] != "default" {
-				printConsoleError("Can not create temporary filter from stream, try 'select * from stream:default'")
-				return
-			}
-			//streamName := split[1]
-
-			// Create filter
-			tmpFilterName = fmt.Sprintf("%s%d", TMP_FILTER_PREFIX, time.Now().Unix())
-			supervisorCon.CreateFilter(tmpFilterName, where)
-			filter, _ = supervisorCon.FilterByName(tmpFilterName)
-			if filter == nil {
-				log.Printf("Filter not found")
-				return
-			}
 		}
+		_handleConsole(ctx, cmd)
 	}
-
-	// Clear channel
-	if len(cmdFinishChan) > 0 {
-		<-cmdFinishChan
-	}
-
-	// Offset
-	offset := uint64(0)
-
-	// Stream data
-	var resultCount int64 = 0
-	go func() {
-		var resultBuffer []string = nil
-
-		// Use result buffer if this is a tail set
-		if opts != nil && opts["tail"] == "1" && limit > 0 {
-			resultBuffer = make([]string, 0)
-		}
-
-		// Whitespace
-		if !nonInteractive {
-			fmt.Println()
-		}
-
-		// Loop
-	outer:
-		for {
-			// Handle interrup
-			interruptMux.Lock()
-			if interrupted {
-				interrupted = false
-				consecutiveInterruptCount = 0
-				interruptMux.Unlock()
-				fmt.Printf("Interrupted..\n")
-				if len(tmpFilterName) > 0 {
-					supervisorCon.RemoveFilter(tmpFilterName)
-				}
-				break
-			} else {
-				interruptMux.Unlock()
-			}
-			// Sleep
-			time.Sleep(200 * time.Millisecond) // @todo dynamic
-
-			// Update URL
-			uri := fmt.Sprintf("filter/%s/result?result_offset=%d", filter.Id, offset)
-
-			// Fetch
-			data, respErr := supervisorCon._get(uri)
-			if respErr != nil {
-				if verbose {
-					fmt.Printf("Error while fetching results: %s", respErr)
-				}
-				continue
-			}
-			// Parse result JSON
-			var res map[string]interface{}
-			jE := json.Unmarshal([]byte(data), &res)
-			if jE != nil {
-				if verbose {
-					fmt.Printf("Error while fetching results: %s", jE)
-				}
-				continue
-			}
-
-			// Validate status
-			if fmt.Sprintf("%s", res["status"]) != "OK" {
-				if verbose {
-					fmt.Printf("Error while fetching results. Status not OK")
-				}
-				continue
-			}
-
-			// Array of objects
-			list := res["results"].([]interface{})
-
-			// Update offset only if we have results
-			if len(list) > 0 {
-				offsetStr := fmt.Sprintf("%f", res["result_offset"])
-				offsetS, offsetE := strconv.ParseFloat(offsetStr, 64)
-				if offsetE == nil {
-					offset = uint64(offsetS)
-				}
-			}
-
-			// Iterate results
-			for _, elm := range list {
-				elmStr := fmt.Sprintf("%s", elm)
-				if resultBuffer == nil {
-					// Write directly to output
-					fmt.Printf("%s\n", elmStr)
-				} else {
-					// Into buffer
-					resultBuffer = append(resultBuffer, elmStr)
-				}
-				// Increment count
-				resultCount++
-
-				// Done?
-				if resultBuffer == nil && limit != -1 && resultCount >= limit {
-					break outer
-				}
-			}
-
-			// In case we use the buffer, stop if we have zero more requests
-			if resultBuffer != nil && len(resultBuffer) >= int(limit) {
-				// Print last X items from buffer
-				for _, elm := range resultBuffer[len(resultBuffer)-int(limit):] {
-					fmt.Printf("%s\n", elm)
-				}
-				break outer
-			}
-		}
-		cmdFinishChan <- true
-		if nonInteractive == false {
-			fmt.Printf("%s", getConsoleWait())
-		}
-	}()
 }
 
-func dispatchHistory(id string) {
+func dispatchHistory(ctx context.Context, id string) {
 	i, err := strconv.ParseInt(id, 10, 0)
 	if err != nil {
 		printConsoleError(fmt.Sprintf("%s", err))
@@ -651,7 +329,7 @@ func dispatchHistory(id string) {
 	if len(conf.CmdHistory[i]) < 1 {
 		fmt.Printf("History #%d not found\n", i)
 	}
-	handleConsole(conf.CmdHistory[i])
+	handleConsole(ctx, conf.CmdHistory[i])
 }
 
 func clearhistory() {
@@ -667,7 +345,7 @@ func printHistory() {
 }
 
 func consoleAddHistory(cmd string) {
-	conf.CmdHistory = append(conf.CmdHistory, cmd)
+	conf.CmdHistory = append(conf.CmdHistory, redactCommand(cmd))
 
 	// Purge data
 	max := 100
@@ -681,160 +359,54 @@ func consoleAddHistory(cmd string) {
 	conf.Save()
 }
 
+// Credentials are not stored in the history: passwords of auth, login and create user, secrets of configure supervisor
+func redactCommand(input string) string {
+	cmds := console.SplitCommands(input)
+	for i, cmd := range cmds {
+		fields := strings.Fields(cmd)
+		if len(fields) < 1 {
+			continue
+		}
+		cmdLower := strings.ToLower(strings.Join(fields, " "))
+		if (strings.HasPrefix(cmdLower, "auth ") || strings.HasPrefix(cmdLower, "login ")) && len(fields) >= 3 {
+			fields[2] = REDACTED
+			cmds[i] = " " + strings.Join(fields, " ")
+		} else if strings.HasPrefix(cmdLower, "create user ") {
+			cmds[i] = identifiedByRegex.ReplaceAllString(cmd, "${1}'"+REDACTED+"'")
+		} else if strings.HasPrefix(cmdLower, "configure supervisor ") {
+			cmds[i] = secretConfRegex.ReplaceAllString(cmd, "${1}="+REDACTED)
+		}
+	}
+	return strings.TrimSpace(strings.Join(cmds, ";"))
+}
+
 func clearSession() {
-	session["supervisor_uri"] = ""
-	session["supervisor_username"] = ""
-	session["supervisor_password"] = ""
+	cons.Session["supervisor_uri"] = ""
+	cons.Session["supervisor_username"] = ""
+	cons.Session["supervisor_password"] = ""
+	cons.Session["supervisor_token"] = ""
 	fmt.Printf("Cleared session\n")
-	conf.PersistentSession = session
+	conf.PersistentSession = cons.Session
 	conf.Save()
 
 }
 
-func ping() {
-	if !ensureConnected() {
-		return
-	}
-	supervisorCon.Ping()
-}
-
-func ensureConnected() bool {
-	if supervisorCon == nil {
-		fmt.Printf("Not connected, use 'auth' and 'connect' (details see 'help')\n")
-		return false
-	}
-	return true
-}
-
 func save() {
-	conf.PersistentSession = session
+	conf.PersistentSession = cons.Session
 	conf.Save()
 	fmt.Printf("Saved session\n")
 }
 
-func auth(usr string, pwd string) {
-	session["supervisor_username"] = usr
-	session["supervisor_password"] = pwd
-	if !silent {
-		fmt.Printf("Received authentication tokens\n")
-	}
-}
-
-func intFromTimeStr(input string, def int64) (int64, error) {
-	var val int64 = def
-	var err error
-	if len(input) > 0 {
-		// m=minute, h=hour, d=day suffixes
-		var multiplier int64 = 1
-		if strings.HasSuffix(input, "m") {
-			multiplier = 60
-			input = strings.TrimRight(input, "m")
-		} else if strings.HasSuffix(input, "h") {
-			multiplier = 3600
-			input = strings.TrimRight(input, "h")
-		} else if strings.HasSuffix(input, "d") {
-			multiplier = 86400
-			input = strings.TrimRight(input, "d")
-		}
-		val, err = strconv.ParseInt(input, 10, 0)
-		if err != nil {
-			printConsoleError(fmt.Sprintf("Invalid %s", err))
-			return def, err
-		}
-		val *= multiplier
-	}
-	return val, nil
-}
-
-func describeFilter(filterName string) {
-	// Get filter
-	filter, filterE := supervisorCon.FilterByName(filterName)
-	if filterE != nil {
-		printConsoleError("Filter not found")
-		return
-	}
-	fmt.Printf("NAME:\n%s\n\n", filter.Name)
-	fmt.Printf("ID:\n%s\n\n", filter.Id)
-	fmt.Printf("REGEX:\n%s\n\n", filter.Regex)
-}
-
-func getStats(input string) {
-	// Basic parsing
-	var filterName string = ""
-	var windowStr string = ""
-	var rollupStr string = ""
-	var flags map[string]bool = make(map[string]bool)
-	tokens := strings.Split(input, " ")
-	for i, token := range tokens {
-		var previousToken string = ""
-		if i != 0 {
-			previousToken = tokens[i-1]
-		}
-
-		// Very simple parsing
-		if i == 0 {
-			// Filter name
-			filterName = token
-		} else if previousToken == "window" {
-			// Window (e.g. 10m)
-			windowStr = token
-		} else if previousToken == "rollup" {
-			// Rollup (e.g. minutely, hourly)
-			rollupStr = token
-		}
-
-		// Flags
-		if token == "-regular" {
-			flags["hide_regular"] = true
-		} else if token == "-error" || token == "-errors" {
-			flags["hide_error"] = true
-		}
-	}
-
-	// Window
-	window, _ := intFromTimeStr(windowStr, 86400)
-
-	// Rollup
-	rollup, _ := intFromTimeStr(rollupStr, 60)
-
-	// Get filter
-	filter, filterE := supervisorCon.FilterByName(filterName)
-	if filterE != nil {
-		printConsoleError("Filter not found")
-		return
-	}
-
-	// Load
-	data, statsE := filter.GetStats(window, rollup)
-	if statsE != nil {
-		printConsoleError(fmt.Sprintf("%s", statsE))
-		return
-	}
-	if verbose {
-		log.Printf("Stats %v", data)
-	}
-
-	// Get console width
-	stats.loadTerminalDimensions()
-
-	// Clear console
-	clearConsole()
-
-	// Render chart
-	chart, chartE := stats.RenderChart(filter, data, flags)
-	if chartE != nil {
-		printConsoleError(fmt.Sprintf("%s", chartE))
-		return
-	}
-
-	// Print chart
-	fmt.Printf("\n")
-	fmt.Printf("%s", chart)
-}
-
-func connect(uri string) {
-	session["supervisor_uri"] = uri
-	_connect(true)
+func printConsoleHelp() {
+	cons.PrintHelp(
+		"clear\t\t\t\tClears console",
+		"save\t\t\t\tSave session",
+		"history\t\t\t\tPrint recent command history",
+		"history <id>\t\t\tExecute command from history by id",
+		"clearsession\t\t\tClears session (connectino, settings, etc)",
+		"clearhistory\t\t\tClears history of commands",
+		"quit\t\t\t\tExit the CloudPelican cli",
+	)
 }
 
 func clearConsole() {
@@ -846,41 +418,10 @@ func clearConsole() {
 	c.Run()
 }
 
-func _connect(interactive bool) {
-	supervisorCon = NewSupervisorCon()
-	if supervisorCon.Connect() && interactive {
-		fmt.Printf("Use 'save' to store authentication and connection details for future usage\n")
-	}
-}
-
 func printConsoleError(input string) {
 	fmt.Printf("Unknown input '%s' type 'help' for explanation\n", input)
 }
 
-func printConsoleHelp() {
-	fmt.Printf("\n")
-	fmt.Printf("CMD\t\t\t\tDESCRIPTION\n")
-	fmt.Printf("auth <usr> <pwd>\t\tSet authentication details\n")
-	fmt.Printf("connect <host>\t\t\tConnect to supervisor on host\n")
-	fmt.Printf("show filters\t\t\tDisplay list of filters configured\n")
-	fmt.Printf("select\t\t\t\tExecute SQL-like queries, example: select * from <filter_name>;\n")
-	fmt.Printf("tail <filter>\t\t\tTail stream of messages for a specific filter name\n")
-	fmt.Printf("stats <filter>\t\t\tShow matching rate for a specific filter name\n")
-	fmt.Printf("create filter\t\t\tCreate a new filter, example: create filter <filter_name> as '<regex>';\n")
-	fmt.Printf("drop filter\t\t\tRemove a filter, example: drop filter <filter_name>;\n")
-	fmt.Printf("clear\t\t\t\tClears console\n")
-	fmt.Printf("save\t\t\t\tSave session\n")
-	fmt.Printf("history\t\t\t\tPrint recent command history\n")
-	fmt.Printf("history <id>\t\t\tExecute command from history by id\n")
-	fmt.Printf("clearsession\t\t\tClears session (connectino, settings, etc)\n")
-	fmt.Printf("clearhistory\t\t\tClears history of commands\n")
-	fmt.Printf("configure supervisor <k>=<v>\tSet a configuration value in the supervisor\n")
-	fmt.Printf("ping\t\t\t\tTest connection with supervisor\n")
-	fmt.Printf("help\t\t\t\tPrints this documentation\n")
-	fmt.Printf("quit\t\t\t\tExit the CloudPelican cli\n")
-	fmt.Printf("\n")
-}
-
 func getConsoleWait() string {
 	return fmt.Sprintf("%s%s", CONSOLE_PREFIX, CONSOLE_SEP)
 }
@@ -935,16 +476,14 @@ func processAutocomplete(line []byte, pos, key int) (newLine []byte, newPos int)
 	}
 
 	// Autocomplete filter names
-	if strings.Index(lineStr, "stats") == 0 || strings.Index(lineStr, "tail") == 0 || strings.Index(lineStr, "describe filter") == 0 || strings.Index(lineStr, "cat") == 0 {
-		split := strings.SplitN(lineStr, " ", 2)
-		if len(split) == 2 {
-			filters, _ := supervisorCon.Filters()
-			if filters != nil {
-				for _, filter := range filters {
-					if strings.Index(filter.Name, split[1]) == 0 {
-						opts = append(opts, fmt.Sprintf("%s %s", split[0], filter.Name))
-					}
-				}
+	for _, cmd := range []string{"stats", "tail", "describe filter", "cat", "show outliers"} {
+		if strings.Index(lineStr, fmt.Sprintf("%s ", cmd)) != 0 {
+			continue
+		}
+		partial := strings.TrimSpace(lineStr[len(cmd):])
+		for _, filter := range cons.Filters() {
+			if strings.Index(filter.Name, partial) == 0 {
+				opts = append(opts, fmt.Sprintf("%s %s", cmd, filter.Name))
 			}
 		}
 	}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
 	"os/user"
 )
 
@@ -33,6 +34,11 @@ func (c *Conf) Load(str string) {
 	if err := json.Unmarshal([]byte(str), c); err != nil {
 		log.Println(fmt.Sprintf("Failed to load config %s", err))
 	}
+
+	// History of older versions can contain credentials
+	for i, cmd := range c.CmdHistory {
+		c.CmdHistory[i] = redactCommand(cmd)
+	}
 }
 
 func NewConf() *Conf {
@@ -89,15 +95,32 @@ func loadConf() (bool, error) {
 
 	// Into new session?
 	if conf.PersistentSession != nil {
-		session = conf.PersistentSession
-		if len(session["supervisor_uri"]) > 0 {
-			if !silent {
-				fmt.Printf("Restoring session to %s\n", session["supervisor_uri"])
-			}
-			_connect(false)
-			if verbose {
-				fmt.Printf("Restored session to %s\n", session["supervisor_uri"])
-			}
+		cons.Session = conf.PersistentSession
+	}
+
+	// Credentials from the environment take precedence
+	if len(os.Getenv("CLOUDPELICAN_SUPERVISOR_USERNAME")) > 0 {
+		cons.Session["supervisor_username"] = os.Getenv("CLOUDPELICAN_SUPERVISOR_USERNAME")
+		cons.Session["supervisor_password"] = os.Getenv("CLOUDPELICAN_SUPERVISOR_PASSWORD")
+		cons.Session["supervisor_token"] = ""
+	}
+
+	// TLS options from the flags, these are stored in the session on save
+	if len(caFile) > 0 {
+		cons.Session["supervisor_ca_file"] = caFile
+	}
+	if len(pinSha256) > 0 {
+		cons.Session["supervisor_pin_sha256"] = pinSha256
+	}
+
+	// Restore connection
+	if len(cons.Session["supervisor_uri"]) > 0 {
+		if !silent {
+			fmt.Printf("Restoring session to %s\n", cons.Session["supervisor_uri"])
+		}
+		cons.Connect()
+		if verbose {
+			fmt.Printf("Restored session to %s\n", cons.Session["supervisor_uri"])
 		}
 	}
 
@@ -0,0 +1,1476 @@
+// Console of CloudPelican: the commands of the cli (select, tail, cat, search, stats, ..) on a supervisor
+// - Used by the cli (terminal) and the supervisor (Slack), every console has its own session and output
+// - Long running commands (e.g. tail) stop once the context of the command is done
+// @author Robin Verlangen
+
+package console
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const TMP_FILTER_PREFIX string = "__tmp__"
+const RESULT_WAIT_SECONDS int = 10
+const RESULT_RETRY_BACKOFF time.Duration = 200 * time.Millisecond // Doubles on every failed request, up to the max
+const RESULT_RETRY_MAX_BACKOFF time.Duration = 10 * time.Second
+
+// Extractors of temporary filters in case the statement uses fields
+var TMP_FILTER_EXTRACTORS []string = []string{"syslog", "kv", "json"}
+
+// create schedule <name> cron '<expression>' notify <destination> run <command>
+var createScheduleRegex = regexp.MustCompile(`(?is)^(\S+)\s+cron\s+(?:['"](.+?)['"]|(@\w+))\s+notify\s+(\S+)\s+run\s+(.+)$`)
+
+// Debug logging (standard logger)
+var Verbose bool
+
+type Console struct {
+	Session               map[string]string // Connection, credentials and settings (e.g. output_format), persisting is up to the caller
+	Out                   io.Writer
+	Silent                bool         // No helping output
+	Interactive           bool         // Results as table by default, otherwise raw
+	Terminal              bool         // Output is a terminal: colors, dimensions of charts and clearing the screen
+	OutputFormat          string       // Takes precedence over the format of the session
+	AllowTemporaryFilters bool         // Automatically create temporary filters from select statements
+	AllowFiles            bool         // Write results to files (into outfile, tee)
+	FixedConnection       bool         // Disables connect, auth, login and logout (e.g. a console of the supervisor)
+	HttpClient            *http.Client // Client of the requests to the supervisor (optional)
+	SaveSession           func()       // Called after the session changed that must be kept (login, logout) (optional)
+
+	con   *SupervisorCon
+	stats *Statistics
+}
+
+// Positions of the character outside quoted strings, quotes are escaped the same way as in the SQL lexer
+func unquotedIndexes(input string, sep byte) []int {
+	indexes := make([]int, 0)
+	var quote byte
+	for i := 0; i < len(input); i++ {
+		c := input[i]
+		switch {
+		case quote != 0 && c == '\\':
+			i++ // Escaped character
+		case quote != 0 && c == quote:
+			quote = 0 // A doubled quote closes and opens again
+		case quote != 0:
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == sep:
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// Commands separated by semi-colons, semi-colons in quoted strings (e.g. a regex or file name) do not separate commands
+func SplitCommands(input string) []string {
+	cmds := make([]string, 0)
+	start := 0
+	for _, i := range unquotedIndexes(input, ';') {
+		cmds = append(cmds, input[start:i])
+		start = i + 1
+	}
+	return append(cmds, input[start:])
+}
+
+// Execute commands separated by semi-colons
+func (c *Console) Execute(ctx context.Context, input string) {
+	input = strings.TrimRight(input, " ;\n\t")
+	if len(input) < 1 {
+		return
+	}
+	for _, cmd := range SplitCommands(input) {
+		if ctx.Err() != nil {
+			return
+		}
+		c.ExecuteCommand(ctx, cmd)
+	}
+}
+
+// Execute a single command
+func (c *Console) ExecuteCommand(ctx context.Context, input string) {
+	input = strings.TrimSpace(input)
+
+	// Results into a file as well (| tee <file>)
+	input, tee, teeE := splitTee(input)
+	if teeE != nil {
+		c.printError(fmt.Sprintf("%s", teeE))
+		return
+	}
+	inputLower := strings.ToLower(input)
+	if tee != nil && !(strings.Index(inputLower, "select ") == 0 || strings.Index(inputLower, "tail ") == 0 || strings.Index(inputLower, "search ") == 0 || strings.Index(inputLower, "cat ") == 0) {
+		c.printError("tee is supported on select, tail, search and cat")
+		return
+	}
+
+	// Session
+	if inputLower == "help" {
+		c.PrintHelp()
+		return
+	} else if strings.Index(inputLower, "set ") == 0 {
+		c.setOption(strings.TrimSpace(input[len("set "):]))
+		return
+	}
+	isConnectionCmd := strings.Index(inputLower, "connect ") == 0 || strings.Index(inputLower, "auth ") == 0 || inputLower == "login" || strings.Index(inputLower, "login ") == 0 || inputLower == "logout"
+	if isConnectionCmd && c.FixedConnection {
+		c.printError(fmt.Sprintf("%s is not supported by this console", strings.Fields(inputLower)[0]))
+		return
+	}
+	if strings.Index(inputLower, "connect ") == 0 {
+		split := strings.SplitN(input, "connect ", 2)
+		if len(split) != 2 {
+			c.printError(input)
+			return
+		}
+		c.connect(split[1])
+		return
+	} else if strings.Index(inputLower, "auth ") == 0 {
+		split := strings.Split(input, " ")
+		if len(split) != 3 {
+			c.printError(input)
+			return
+		}
+		c.auth(split[1], split[2])
+		return
+	}
+
+	// Supervisor
+	if !c.ensureConnected() {
+		return
+	}
+	if inputLower == "ping" {
+		c.ping()
+	} else if inputLower == "show filters" {
+		c.showFilters()
+	} else if inputLower == "show alerts" {
+		c.showAlerts()
+	} else if inputLower == "show schedules" {
+		c.showSchedules()
+	} else if inputLower == "show users" {
+		c.showUsers()
+	} else if inputLower == "show tokens" {
+		c.showTokens()
+	} else if inputLower == "show backends" {
+		c.showBackends()
+	} else if inputLower == "logout" {
+		c.logout()
+	} else if inputLower == "login" || strings.Index(inputLower, "login ") == 0 {
+		c.login(strings.TrimSpace(input[len("login"):]))
+	} else if strings.Index(inputLower, "select ") == 0 {
+		c.executeSelect(ctx, input, nil, tee)
+	} else if strings.Index(inputLower, "use backend ") == 0 {
+		c.useBackend(strings.TrimSpace(input[len("use backend "):]))
+	} else if strings.Index(inputLower, "cat ") == 0 {
+		c.executeGrepSQL(ctx, input, tee)
+	} else if strings.Index(inputLower, "create filter ") == 0 {
+		c.createFilter(inputLower)
+	} else if strings.Index(inputLower, "drop filter ") == 0 {
+		split := strings.SplitN(input, "drop filter ", 2)
+		if len(split) != 2 {
+			c.printError(input)
+			return
+		}
+		c.dropFilter(split[1])
+	} else if strings.Index(inputLower, "create alert ") == 0 {
+		split := strings.SplitN(input, " ", 3)
+		if len(split) != 3 {
+			c.printError(input)
+			return
+		}
+		c.createAlert(split[2])
+	} else if strings.Index(inputLower, "drop alert ") == 0 {
+		split := strings.SplitN(input, " ", 3)
+		if len(split) != 3 {
+			c.printError(input)
+			return
+		}
+		c.dropAlert(split[2])
+	} else if strings.Index(inputLower, "create schedule ") == 0 {
+		split := strings.SplitN(input, " ", 3)
+		if len(split) != 3 {
+			c.printError(input)
+			return
+		}
+		c.createSchedule(split[2])
+	} else if strings.Index(inputLower, "drop schedule ") == 0 {
+		split := strings.SplitN(input, " ", 3)
+		if len(split) != 3 {
+			c.printError(input)
+			return
+		}
+		c.dropSchedule(strings.TrimSpace(split[2]))
+	} else if strings.Index(inputLower, "pause schedule ") == 0 || strings.Index(inputLower, "resume schedule ") == 0 {
+		split := strings.SplitN(input, " ", 3)
+		if len(split) != 3 {
+			c.printError(input)
+			return
+		}
+		c.pauseSchedule(strings.TrimSpace(split[2]), strings.Index(inputLower, "pause ") == 0)
+	} else if strings.Index(inputLower, "show schedule ") == 0 {
+		split := strings.SplitN(input, " ", 3)
+		if len(split) != 3 {
+			c.printError(input)
+			return
+		}
+		c.describeSchedule(strings.TrimSpace(split[2]))
+	} else if strings.Index(inputLower, "create user ") == 0 {
+		split := strings.SplitN(input, " ", 3)
+		if len(split) != 3 {
+			c.printError(input)
+			return
+		}
+		c.createUser(split[2])
+	} else if strings.Index(inputLower, "drop user ") == 0 {
+		split := strings.SplitN(input, " ", 3)
+		if len(split) != 3 {
+			c.printError(input)
+			return
+		}
+		c.dropUser(split[2])
+	} else if strings.Index(inputLower, "tail ") == 0 {
+		split := strings.SplitN(input, "tail ", 2)
+		if len(split) != 2 {
+			c.printError(input)
+			return
+		}
+		opts := make(map[string]string)
+		opts["tail"] = "1"
+		c.executeSelect(ctx, fmt.Sprintf("SELECT * FROM %s", split[1]), opts, tee)
+	} else if strings.Index(inputLower, "search ") == 0 {
+		split := strings.SplitN(input, "search ", 2)
+		if len(split) != 2 {
+			c.printError(input)
+			return
+		}
+		c.search(ctx, split[1], tee)
+	} else if strings.Index(inputLower, "stats ") == 0 {
+		split := strings.SplitN(input, "stats ", 2)
+		if len(split) < 2 {
+			c.printError(input)
+			return
+		}
+		c.getStats(split[1])
+	} else if strings.Index(inputLower, "show outliers ") == 0 {
+		split := strings.SplitN(input, "show outliers ", 2)
+		if len(split) != 2 {
+			c.printError(input)
+			return
+		}
+		c.showOutliers(split[1])
+	} else if strings.Index(inputLower, "describe filter ") == 0 {
+		split := strings.SplitN(input, "describe filter ", 2)
+		if len(split) != 2 {
+			c.printError(input)
+			return
+		}
+		c.describeFilter(split[1])
+	} else if strings.Index(inputLower, "configure supervisor ") == 0 {
+		split := strings.SplitN(input, "configure supervisor ", 2)
+		if len(split) != 2 {
+			c.printError(input)
+			return
+		}
+		c.configureSupervisor(split[1])
+	} else {
+		c.printError(input)
+	}
+}
+
+// Connect to the supervisor of the session, e.g. after restoring a session
+func (c *Console) Connect() {
+	c._connect(false)
+}
+
+// Filters of the supervisor, nil if not connected
+func (c *Console) Filters() []*Filter {
+	if c.con == nil {
+		return nil
+	}
+	filters, _ := c.con.Filters()
+	return filters
+}
+
+// Help of the commands, clients add the lines of their own commands (e.g. history of the cli)
+func (c *Console) PrintHelp(extra ...string) {
+	fmt.Fprintf(c.Out, "\n")
+	fmt.Fprintf(c.Out, "CMD\t\t\t\tDESCRIPTION\n")
+	fmt.Fprintf(c.Out, "auth <usr> <pwd>\t\tSet authentication details\n")
+	fmt.Fprintf(c.Out, "login <usr> <pwd>\t\tLogin with a token instead of a password, example: login <usr> <pwd> role reader filters <filter_name> expires 30d;\n")
+	fmt.Fprintf(c.Out, "logout\t\t\t\tRevoke the token of the session\n")
+	fmt.Fprintf(c.Out, "show tokens\t\t\tDisplay list of tokens\n")
+	fmt.Fprintf(c.Out, "connect <host>\t\t\tConnect to supervisor on host\n")
+	fmt.Fprintf(c.Out, "show filters\t\t\tDisplay list of filters configured\n")
+	fmt.Fprintf(c.Out, "select\t\t\t\tExecute SQL-like queries, example: select * from <filter_name> where '<regex>' and not _raw like '%%404%%' limit 10;\n")
+	fmt.Fprintf(c.Out, "into outfile\t\t\tWrite results to a file, example: select * from <filter_name> limit 1000 into outfile '/tmp/out.csv.gz' rotate 100MB;\n")
+	fmt.Fprintf(c.Out, "| tee <file>\t\t\tWrite results to a file and the console, example: tail <filter_name> | tee /tmp/out.log format json rotate 100MB;\n")
+	fmt.Fprintf(c.Out, "tail <filter>\t\t\tTail stream of messages for a specific filter name\n")
+	fmt.Fprintf(c.Out, "cat [-local] <filter> | ..\tGrep-like commands on the history of a filter, example: cat <filter_name> | grep -v 404 | sort | uniq -c | head\n")
+	fmt.Fprintf(c.Out, "set format <format>\t\tOutput format of query results: table, raw, json, csv or tsv (use save to keep it)\n")
+	fmt.Fprintf(c.Out, "show backends\t\t\tDisplay list of search backends and their health\n")
+	fmt.Fprintf(c.Out, "use backend <name>\t\tSearch backend of searches and cat in this session, default uses the backend of the filter (use save to keep it)\n")
+	fmt.Fprintf(c.Out, "stats <filter>\t\t\tShow matching rate for a specific filter name\n")
+	fmt.Fprintf(c.Out, "show outliers <filter>\t\tList detected outliers, example: show outliers <filter> window 1d min_score 0.9;\n")
+	fmt.Fprintf(c.Out, "create filter\t\t\tCreate a new filter, example: create filter <filter_name> as '<regex>' [extract syslog,regex,json,kv] [backend <name>];\n")
+	fmt.Fprintf(c.Out, "drop filter\t\t\tRemove a filter, example: drop filter <filter_name>;\n")
+	fmt.Fprintf(c.Out, "show alerts\t\t\tDisplay list of alerts and their state\n")
+	fmt.Fprintf(c.Out, "create alert\t\t\tCreate a new alert, example: create alert <name> on <filter_name> when errors > 100 window 5m notify slack #ops;\n")
+	fmt.Fprintf(c.Out, "drop alert\t\t\tRemove an alert, example: drop alert <name>;\n")
+	fmt.Fprintf(c.Out, "show schedules\t\t\tDisplay list of scheduled commands, show schedule <name> displays the recent runs\n")
+	fmt.Fprintf(c.Out, "create schedule\t\t\tRun a command in the background, example: create schedule <name> cron '0 9 * * 1-5' notify #ops run stats <filter_name>;\n")
+	fmt.Fprintf(c.Out, "pause schedule <name>\t\tStop running a scheduled command until resume schedule <name>\n")
+	fmt.Fprintf(c.Out, "drop schedule\t\t\tRemove a scheduled command and its history, example: drop schedule <name>;\n")
+	fmt.Fprintf(c.Out, "show users\t\t\tDisplay list of supervisor users (admin only)\n")
+	fmt.Fprintf(c.Out, "create user\t\t\tCreate a new user, example: create user <username> identified by '<password>' role reader;\n")
+	fmt.Fprintf(c.Out, "drop user\t\t\tRemove an user, example: drop user <username>;\n")
+	for _, line := range extra {
+		fmt.Fprintf(c.Out, "%s\n", line)
+	}
+	fmt.Fprintf(c.Out, "configure supervisor <k>=<v>\tSet a configuration value in the supervisor\n")
+	fmt.Fprintf(c.Out, "ping\t\t\t\tTest connection with supervisor\n")
+	fmt.Fprintf(c.Out, "help\t\t\t\tPrints this documentation\n")
+	fmt.Fprintf(c.Out, "\n")
+}
+
+func NewConsole(out io.Writer) *Console {
+	return &Console{
+		Session:               make(map[string]string),
+		Out:                   out,
+		Silent:                true,
+		AllowTemporaryFilters: true,
+	}
+}
+
+func (c *Console) executeGrepSQL(ctx context.Context, in string, tee *OutFile) {
+	gsql := newGrepSQL(c.con, in)
+	q, e := gsql.Parse()
+	if e != nil {
+		c.printError(fmt.Sprintf("%s", e))
+		return
+	}
+
+	// Execute, locally in case requested or there is no search backend
+	if !gsql.local {
+		data, err := c.con.Search(ctx, q, gsql.filter)
+		if err == nil {
+			c.writeSearchResult(data, nil, tee)
+			return
+		}
+		if err != ErrNoSearchBackend || gsql.timeRange != nil {
+			c.printError(fmt.Sprintf("Search failed '%s'", err))
+			return
+		}
+		if Verbose {
+			log.Println("No search backend configured, executing on the results of the supervisor")
+		}
+	}
+	c.executeGrepLocal(ctx, gsql, tee)
+}
+
+func (c *Console) search(ctx context.Context, input string, tee *OutFile) {
+	// Parse
+	stmt, stmtE := ParseSql(input)
+	if stmtE != nil {
+		c.printSqlError(stmtE)
+		return
+	}
+
+	// Filter to table
+	filter, filterE := c.con.FilterByName(stmt.From)
+	if filterE != nil {
+		c.printError(fmt.Sprintf("%s", filterE))
+		return
+	}
+	table, tableE := filter.GetSearchTableName(stmt.Range)
+	if tableE != nil {
+		c.printError(fmt.Sprintf("%s", tableE))
+		return
+	}
+	q := stmt.BigQuery(table)
+
+	// Execute
+	data, err := c.con.Search(ctx, q, filter)
+	if err != nil {
+		c.printError(fmt.Sprintf("Search failed '%s'", err))
+		return
+	}
+	c.writeSearchResult(data, stmt.Into, tee)
+}
+
+// Session option, example input: "format json"
+func (c *Console) setOption(input string) {
+	split := strings.Fields(input)
+	if len(split) != 2 {
+		c.printError(fmt.Sprintf("Usage: set format <%s|%s|%s|%s|%s>", OUTPUT_FORMAT_TABLE, OUTPUT_FORMAT_RAW, OUTPUT_FORMAT_JSON, OUTPUT_FORMAT_CSV, OUTPUT_FORMAT_TSV))
+		return
+	}
+	switch strings.ToLower(split[0]) {
+	case "format":
+		format := strings.ToLower(split[1])
+		if err := ValidateOutputFormat(format); err != nil {
+			c.printError(fmt.Sprintf("%s", err))
+			return
+		}
+		c.Session["output_format"] = format
+		if len(c.OutputFormat) > 0 {
+			// The format of the console (flag) takes precedence
+			c.OutputFormat = format
+		}
+		fmt.Fprintf(c.Out, "Output format set to %s\n", format)
+	default:
+		c.printError(fmt.Sprintf("Unknown option %s", split[0]))
+	}
+}
+
+func (c *Console) configureSupervisor(kv string) {
+	split := strings.SplitN(kv, "=", 2)
+	if len(split) != 2 {
+		c.printError(fmt.Sprintf("You must provide a key=value pair, provided '%s'", kv))
+		return
+	}
+	_, err := c.con.SetSupervisorConfig(split[0], split[1])
+	if err != nil {
+		c.printError(fmt.Sprintf("Failed to configure: %s", err))
+	}
+}
+
+// Select execution, example input: "create filter <filter_name> as '<regex_here>' [extract syslog,kv] [backend <name>]" [] indicates optional
+func (c *Console) createFilter(input string) {
+	// Basic parsing
+	var filterName string = ""
+	var regex string = ""
+	var extractors []string = nil
+	var backend string = ""
+	tokens := strings.Split(input, " ")
+	for i, token := range tokens {
+		var previousToken string = ""
+		if i != 0 {
+			previousToken = tokens[i-1]
+		}
+
+		// Very simple parsing
+		if previousToken == "filter" && tokens[i-2] == "create" {
+			// Filter name
+			filterName = strings.TrimSpace(token)
+		} else if previousToken == "as" {
+			regex = strings.TrimRight(strings.TrimLeft(token, "'"), "'")
+		} else if previousToken == "extract" {
+			extractors = strings.Split(strings.ToLower(token), ",")
+		} else if previousToken == "backend" {
+			backend = strings.TrimSpace(token)
+		}
+	}
+
+	// Filter name
+	if len(filterName) < 1 {
+		c.printError(fmt.Sprintf("You must provide a filter name", filterName))
+		return
+	}
+	if isUuid(filterName) {
+		c.printError(fmt.Sprintf("Filter name can not be in form of a UUID", filterName))
+		return
+	}
+
+	// Validate filter name
+	matched, _ := regexp.MatchString("^([a-z0-9_]+)$", filterName)
+	if !matched {
+		c.printError("Filter name can only contain a-z, 0-9 and _")
+		return
+	}
+
+	// Check duplicate name filter
+	filterdup, _ := c.con.FilterByName(filterName)
+	if filterdup != nil {
+		c.printError(fmt.Sprintf("There already exist a filter with the name %s", filterName))
+		return
+	}
+
+	// Create
+	_, filterErr := c.con.CreateFilter(filterName, regex, extractors, backend)
+	if filterErr != nil {
+		c.printError(fmt.Sprintf("%s", filterErr))
+		return
+	}
+	fmt.Fprintf(c.Out, "Created filter '%s'\n", filterName)
+}
+
+// Drop filter (removing it)
+func (c *Console) dropFilter(name string) {
+	res := c.con.RemoveFilter(name)
+	if res {
+		fmt.Fprintf(c.Out, "Removed filter '%s'\n", name)
+	}
+}
+
+func (c *Console) showFilters() {
+	filters, err := c.con.Filters()
+	if err != nil {
+		c.printError(fmt.Sprintf("%s", err))
+		return
+	}
+	fmt.Fprintf(c.Out, "FILTER NAME\n")
+	for _, filter := range filters {
+		if strings.HasPrefix(filter.Name, TMP_FILTER_PREFIX) {
+			continue
+		}
+		fmt.Fprintf(c.Out, "%s\n", filter.Name)
+	}
+}
+
+// Select execution, example input: "select * from <filter_name> [where <condition>] [order by <column>] [limit 1234]" [] indicates optional
+// example input from stream: "select * from stream:<stream_name> [where '<regex>'] [limit 1234]" [] indicates optional
+// See sql.go for the supported syntax
+func (c *Console) executeSelect(ctx context.Context, input string, opts map[string]string, tee *OutFile) {
+	// Parse
+	stmt, stmtE := ParseSql(input)
+	if stmtE != nil {
+		c.printSqlError(stmtE)
+		return
+	}
+	filterName := stmt.From
+	where := stmt.FilterRegex()
+	limit := stmt.Limit
+	if len(stmt.OrderBy) > 0 && limit == -1 {
+		c.printError("ORDER BY requires a LIMIT on streaming results")
+		return
+	}
+	if stmt.Range != nil {
+		c.printError("SINCE, UNTIL and WINDOW are only supported by search")
+		return
+	}
+
+	// Load filter
+	var tmpFilterName string = ""
+	filter, filterE := c.con.FilterByName(filterName)
+	if filterE != nil {
+		if !c.AllowTemporaryFilters {
+			c.printError(fmt.Sprintf("%s", filterE))
+			return
+		} else {
+			// Auto create filter from stream
+			split := strings.Split(filterName, "stream:")
+			if len(split) != 2 || split[1] != "default" {
+				c.printError("Can not create temporary filter from stream, try 'select * from stream:default'")
+				return
+			}
+			//streamName := split[1]
+
+			// Create filter
+			tmpFilterName = fmt.Sprintf("%s%d", TMP_FILTER_PREFIX, time.Now().Unix())
+			var extractors []string = nil
+			if len(stmt.Fields()) > 0 {
+				extractors = TMP_FILTER_EXTRACTORS
+			}
+			c.con.CreateFilter(tmpFilterName, where, extractors, "")
+			filter, _ = c.con.FilterByName(tmpFilterName)
+			if filter == nil {
+				log.Printf("Filter not found")
+				return
+			}
+		}
+	}
+
+	// Output
+	writer, fileWriter, writerE := c.newQueryResultWriter(stmt.Into, tee)
+	if writerE != nil {
+		c.printError(fmt.Sprintf("%s", writerE))
+		if len(tmpFilterName) > 0 {
+			c.con.RemoveFilter(tmpFilterName)
+		}
+		return
+	}
+
+	// Offset
+	offset := uint64(0)
+
+	// Fields extracted by the supervisor that are used by the statement
+	fields := stmt.Fields()
+
+	// Stream data
+	var resultCount int64 = 0
+	var resultBuffer []map[string]string = nil
+	writer.WriteHeader(stmt.Header())
+
+	// Use result buffer if this is a tail set or the results are ordered
+	if (opts != nil && opts["tail"] == "1" || len(stmt.OrderBy) > 0) && limit > 0 {
+		resultBuffer = make([]map[string]string, 0)
+	}
+
+	// Long-polling, disabled when the supervisor does not support it
+	var longPoll bool = true
+
+	// Failed requests in a row, the loop backs off to keep the load on the supervisor low
+	var failures uint = 0
+	backoff := func() {
+		failures++
+		wait := RESULT_RETRY_BACKOFF << (failures - 1)
+		if failures > 16 || wait > RESULT_RETRY_MAX_BACKOFF {
+			wait = RESULT_RETRY_MAX_BACKOFF
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+	}
+
+	// Whitespace
+	if c.Interactive {
+		fmt.Fprintln(c.Out)
+	}
+
+	// Loop
+outer:
+	for {
+		// Stop once the context is done (e.g. interrupted by the user)
+		if ctx.Err() != nil {
+			fmt.Fprintf(c.Out, "Interrupted..\n")
+			if len(tmpFilterName) > 0 {
+				c.con.RemoveFilter(tmpFilterName)
+			}
+			break
+		}
+
+		// Sleep, not needed in case the supervisor holds the request until there are results
+		if !longPoll {
+			time.Sleep(200 * time.Millisecond)
+		}
+
+		// Update URL, the where clause is evaluated by the supervisor
+		uri := fmt.Sprintf("filter/%s/result?result_offset=%d&wait=%d", filter.Id, offset, RESULT_WAIT_SECONDS)
+		if stmt.Where != nil {
+			uri += fmt.Sprintf("&where=%s", url.QueryEscape(stmt.Where.String()))
+		}
+		if len(fields) > 0 {
+			uri += fmt.Sprintf("&fields=%s", url.QueryEscape(strings.Join(fields, ",")))
+		}
+
+		// Fetch
+		data, respErr := c.con._getWithContext(ctx, uri)
+		if respErr != nil {
+			if Verbose {
+				log.Printf("Error while fetching results: %s", respErr)
+			}
+			backoff()
+			continue
+		}
+		// Parse result JSON
+		var res map[string]interface{}
+		jE := json.Unmarshal([]byte(data), &res)
+		if jE != nil {
+			if Verbose {
+				log.Printf("Error while fetching results: %s", jE)
+			}
+			backoff()
+			continue
+		}
+
+		// Validate status, the tail stops once the filter is dropped
+		if fmt.Sprintf("%s", res["status"]) != "OK" {
+			if msg, _ := res["error"].(string); strings.HasPrefix(msg, "Filter ") && strings.HasSuffix(msg, " not found") {
+				fmt.Fprintf(c.Out, "%s, stopped\n", msg)
+				break outer
+			}
+			if Verbose {
+				log.Printf("Error while fetching results. Status not OK")
+			}
+			backoff()
+			continue
+		}
+		failures = 0
+
+		// Older supervisors answer immediately, fall back to polling
+		if _, ok := res["wait"]; !ok && longPoll {
+			if Verbose {
+				log.Println("Supervisor does not support long-polling, falling back to polling")
+			}
+			longPoll = false
+		}
+
+		// Array of objects
+		list := res["results"].([]interface{})
+
+		// Update offset, this also moves past results that did not match the where clause
+		offsetStr := fmt.Sprintf("%f", res["result_offset"])
+		offsetS, offsetE := strconv.ParseFloat(offsetStr, 64)
+		if offsetE == nil && uint64(offsetS) > offset {
+			offset = uint64(offsetS)
+		}
+
+		// Older supervisors ignore the where clause, evaluate it here
+		_, serverWhere := res["where"]
+
+		// Extracted fields, in the same order as the results
+		rows, _ := res["rows"].([]interface{})
+
+		// Iterate results
+		for i, elm := range list {
+			row := make(map[string]string)
+			if i < len(rows) {
+				if m, ok := rows[i].(map[string]interface{}); ok {
+					for k, v := range m {
+						row[k] = fmt.Sprintf("%s", v)
+					}
+				}
+			}
+			row["_raw"] = fmt.Sprintf("%s", elm)
+			if stmt.Where != nil && !serverWhere && !stmt.Where.Eval(row) {
+				continue
+			}
+			if resultBuffer == nil {
+				// Write directly to output
+				if err := writer.WriteRow(stmt.Project(row)); err != nil {
+					c.printError(fmt.Sprintf("%s", err))
+					break outer
+				}
+			} else {
+				// Into buffer
+				resultBuffer = append(resultBuffer, row)
+			}
+			// Increment count
+			resultCount++
+
+			// Done?
+			if resultBuffer == nil && limit != -1 && resultCount >= limit {
+				writer.Flush()
+				break outer
+			}
+		}
+		writer.Flush()
+
+		// In case we use the buffer, stop if we have zero more requests
+		if resultBuffer != nil && len(resultBuffer) >= int(limit) {
+			// Print last X items from buffer
+			rows := resultBuffer[len(resultBuffer)-int(limit):]
+			stmt.Sort(rows)
+			for _, row := range rows {
+				if err := writer.WriteRow(stmt.Project(row)); err != nil {
+					c.printError(fmt.Sprintf("%s", err))
+					break
+				}
+			}
+			writer.Flush()
+			break outer
+		}
+	}
+	c.closeQueryResultWriter(fileWriter)
+}
+
+func (c *Console) ping() {
+	if !c.ensureConnected() {
+		return
+	}
+	c.con.Ping()
+}
+
+func (c *Console) ensureConnected() bool {
+	if c.con == nil {
+		fmt.Fprintf(c.Out, "Not connected, use 'auth' and 'connect' (details see 'help')\n")
+		return false
+	}
+	return true
+}
+
+func (c *Console) auth(usr string, pwd string) {
+	c.Session["supervisor_username"] = usr
+	c.Session["supervisor_password"] = pwd
+	c.Session["supervisor_token"] = ""
+	if !c.Silent {
+		fmt.Fprintf(c.Out, "Received authentication tokens\n")
+	}
+}
+
+func (c *Console) intFromTimeStr(input string, def int64) (int64, error) {
+	var val int64 = def
+	var err error
+	if len(input) > 0 {
+		// m=minute, h=hour, d=day suffixes
+		var multiplier int64 = 1
+		if strings.HasSuffix(input, "m") {
+			multiplier = 60
+			input = strings.TrimRight(input, "m")
+		} else if strings.HasSuffix(input, "h") {
+			multiplier = 3600
+			input = strings.TrimRight(input, "h")
+		} else if strings.HasSuffix(input, "d") {
+			multiplier = 86400
+			input = strings.TrimRight(input, "d")
+		}
+		val, err = strconv.ParseInt(input, 10, 0)
+		if err != nil {
+			c.printError(fmt.Sprintf("Invalid %s", err))
+			return def, err
+		}
+		val *= multiplier
+	}
+	return val, nil
+}
+
+func (c *Console) describeFilter(filterName string) {
+	// Get filter
+	filter, filterE := c.con.FilterByName(filterName)
+	if filterE != nil {
+		c.printError("Filter not found")
+		return
+	}
+	fmt.Fprintf(c.Out, "NAME:\n%s\n\n", filter.Name)
+	fmt.Fprintf(c.Out, "ID:\n%s\n\n", filter.Id)
+	fmt.Fprintf(c.Out, "REGEX:\n%s\n\n", filter.Regex)
+	if len(filter.Extractors) > 0 {
+		fmt.Fprintf(c.Out, "EXTRACTORS:\n%s\n\n", strings.Join(filter.Extractors, ", "))
+	}
+	if len(filter.SearchBackend) > 0 {
+		fmt.Fprintf(c.Out, "SEARCH BACKEND:\n%s\n\n", filter.SearchBackend)
+	}
+}
+
+// Statistics of a filter, see Console.Stats
+type FilterStats struct {
+	Filter   *Filter
+	Data     map[int]map[int64]int64 // Metric (1 matches, 2 errors) => bucket => count
+	Outliers []*Outlier
+	Rollup   int64           // Seconds per bucket
+	Flags    map[string]bool // hide_regular, hide_error, hide_outliers
+}
+
+// Statistics of the stats command (e.g. "web window 1h rollup 5m -errors"), used by clients that render their own chart
+func (c *Console) Stats(input string) (*FilterStats, error) {
+	if c.con == nil {
+		return nil, errors.New("Not connected")
+	}
+
+	// Basic parsing
+	var filterName string = ""
+	var windowStr string = ""
+	var rollupStr string = ""
+	var flags map[string]bool = make(map[string]bool)
+	tokens := strings.Split(input, " ")
+	for i, token := range tokens {
+		var previousToken string = ""
+		if i != 0 {
+			previousToken = tokens[i-1]
+		}
+
+		// Very simple parsing
+		if i == 0 {
+			// Filter name
+			filterName = token
+		} else if previousToken == "window" {
+			// Window (e.g. 10m)
+			windowStr = token
+		} else if previousToken == "rollup" {
+			// Rollup (e.g. minutely, hourly)
+			rollupStr = token
+		}
+
+		// Flags
+		if token == "-regular" {
+			flags["hide_regular"] = true
+		} else if token == "-error" || token == "-errors" {
+			flags["hide_error"] = true
+		} else if token == "-outlier" || token == "-outliers" {
+			flags["hide_outliers"] = true
+		}
+	}
+
+	// Window
+	window, _ := c.intFromTimeStr(windowStr, 86400)
+
+	// Rollup
+	rollup, _ := c.intFromTimeStr(rollupStr, 60)
+
+	// Get filter
+	filter, filterE := c.con.FilterByName(filterName)
+	if filterE != nil {
+		return nil, errors.New("Filter not found")
+	}
+
+	// Load
+	data, rollup, statsE := filter.GetStats(window, rollup)
+	if statsE != nil {
+		return nil, statsE
+	}
+	if Verbose {
+		log.Printf("Stats %v", data)
+	}
+
+	// Outliers within the window, the chart still renders without them
+	var outliers []*Outlier = nil
+	if !flags["hide_outliers"] {
+		var outliersE error
+		outliers, outliersE = filter.GetOutliers(window, 0, 100)
+		if outliersE != nil && Verbose {
+			log.Printf("Failed to load outliers: %s", outliersE)
+		}
+	}
+	return &FilterStats{
+		Filter:   filter,
+		Data:     data,
+		Outliers: outliers,
+		Rollup:   rollup,
+		Flags:    flags,
+	}, nil
+}
+
+func (c *Console) getStats(input string) {
+	stats, err := c.Stats(input)
+	if err != nil {
+		c.printError(fmt.Sprintf("%s", err))
+		return
+	}
+
+	// Get console width
+	if c.stats == nil {
+		c.stats = newStatistics(c.Terminal)
+	}
+	c.stats.loadTerminalDimensions()
+
+	// Clear console
+	c.clearConsole()
+
+	// Render chart
+	chart, chartE := c.stats.RenderChart(stats.Filter, stats.Data, stats.Outliers, stats.Rollup, stats.Flags)
+	if chartE != nil {
+		c.printError(fmt.Sprintf("%s", chartE))
+		return
+	}
+
+	// Print chart
+	fmt.Fprintf(c.Out, "\n")
+	fmt.Fprintf(c.Out, "%s", chart)
+}
+
+// Example input: "<filter_name> [window 1d] [min_score 0.9] [limit 100]" [] indicates optional
+func (c *Console) showOutliers(input string) {
+	// Basic parsing
+	var filterName string = ""
+	var windowStr string = ""
+	var minScoreStr string = ""
+	var limitStr string = ""
+	tokens := strings.Split(input, " ")
+	for i, token := range tokens {
+		var previousToken string = ""
+		if i != 0 {
+			previousToken = tokens[i-1]
+		}
+
+		// Very simple parsing
+		if i == 0 {
+			// Filter name
+			filterName = token
+		} else if previousToken == "window" {
+			// Window (e.g. 1d)
+			windowStr = token
+		} else if previousToken == "min_score" {
+			// Minimum score (e.g. 0.9)
+			minScoreStr = token
+		} else if previousToken == "limit" {
+			// Limit
+			limitStr = token
+		}
+	}
+
+	// Window
+	window, windowE := c.intFromTimeStr(windowStr, 86400)
+	if windowE != nil {
+		return
+	}
+
+	// Minimum score
+	var minScore float64 = 0
+	if len(minScoreStr) > 0 {
+		var minScoreE error
+		minScore, minScoreE = strconv.ParseFloat(minScoreStr, 64)
+		if minScoreE != nil {
+			c.printError(fmt.Sprintf("Invalid min_score %s", minScoreE))
+			return
+		}
+	}
+
+	// Limit
+	var limit int64 = 100
+	if len(limitStr) > 0 {
+		var limitE error
+		limit, limitE = strconv.ParseInt(limitStr, 10, 0)
+		if limitE != nil {
+			c.printError(fmt.Sprintf("Invalid limit %s", limitE))
+			return
+		}
+	}
+
+	// Get filter
+	filter, filterE := c.con.FilterByName(filterName)
+	if filterE != nil {
+		c.printError("Filter not found")
+		return
+	}
+
+	// Load
+	outliers, outliersE := filter.GetOutliers(window, minScore, int(limit))
+	if outliersE != nil {
+		c.printError(fmt.Sprintf("%s", outliersE))
+		return
+	}
+	if len(outliers) == 0 {
+		fmt.Fprintf(c.Out, "No outliers found\n")
+		return
+	}
+	fmt.Fprintf(c.Out, "%-20s\t%-8s\t%s\n", "TIME", "SCORE", "DETAILS")
+	for _, outlier := range outliers {
+		ts := time.Unix(outlier.Timestamp, 0).Format("2006-01-02 15:04:05")
+		fmt.Fprintf(c.Out, "%-20s\t%-8.4f\t%s\n", ts, outlier.Score, outlier.Details)
+	}
+}
+
+// Create alert, example input: "<name> on <filter> when errors > 100 [window 5m] [notify slack [#channel]]" [] indicates optional
+func (c *Console) createAlert(input string) {
+	// Basic parsing
+	rule := &AlertRule{}
+	var filterName string = ""
+	var thresholdStr string = ""
+	var windowStr string = ""
+	tokens := strings.Split(input, " ")
+	for i, token := range tokens {
+		var previousToken string = ""
+		if i != 0 {
+			previousToken = tokens[i-1]
+		}
+
+		// Very simple parsing
+		if i == 0 {
+			// Alert name
+			rule.Name = token
+		} else if previousToken == "on" {
+			// Filter name
+			filterName = token
+		} else if previousToken == "when" {
+			// Condition (errors, matches, outlier_score)
+			rule.Condition = strings.ToLower(token)
+			if i+2 < len(tokens) {
+				rule.Operator = tokens[i+1]
+				thresholdStr = tokens[i+2]
+			}
+		} else if previousToken == "window" {
+			// Window (e.g. 5m)
+			windowStr = token
+		} else if previousToken == "notify" {
+			// Notifier, optionally followed by the target (slack channel, webhook url)
+			rule.Notifier = strings.ToLower(token)
+			if i+1 < len(tokens) {
+				rule.Target = tokens[i+1]
+			}
+		}
+	}
+	if len(rule.Name) < 1 || len(filterName) < 1 || len(rule.Condition) < 1 || len(thresholdStr) < 1 {
+		c.printError("Usage: create alert <name> on <filter> when <errors|matches|outlier_score> <operator> <value> [window 5m] [notify <slack|webhook|stdout> [target]]")
+		return
+	}
+	if len(rule.Notifier) < 1 {
+		rule.Notifier = "stdout"
+	}
+
+	// Threshold
+	var thresholdE error
+	rule.Threshold, thresholdE = strconv.ParseFloat(thresholdStr, 64)
+	if thresholdE != nil {
+		c.printError(fmt.Sprintf("Invalid threshold %s", thresholdE))
+		return
+	}
+
+	// Window
+	var windowE error
+	rule.Window, windowE = c.intFromTimeStr(windowStr, 300)
+	if windowE != nil {
+		return
+	}
+
+	// Get filter
+	filter, filterE := c.con.FilterByName(filterName)
+	if filterE != nil {
+		c.printError("Filter not found")
+		return
+	}
+	rule.FilterId = filter.Id
+
+	// Create
+	if err := c.con.CreateAlert(rule); err != nil {
+		c.printError(fmt.Sprintf("%s", err))
+		return
+	}
+	fmt.Fprintf(c.Out, "Created alert '%s'\n", rule.Name)
+}
+
+func (c *Console) dropAlert(name string) {
+	res := c.con.RemoveAlert(name)
+	if res {
+		fmt.Fprintf(c.Out, "Removed alert '%s'\n", name)
+	} else {
+		c.printError(fmt.Sprintf("Alert '%s' not found", name))
+	}
+}
+
+func (c *Console) showAlerts() {
+	alerts, err := c.con.Alerts()
+	if err != nil {
+		c.printError(fmt.Sprintf("%s", err))
+		return
+	}
+	fmt.Fprintf(c.Out, "%-20s\t%-20s\t%-30s\t%-8s\t%s\n", "NAME", "FILTER", "CONDITION", "STATE", "SINCE")
+	for _, alert := range alerts {
+		filterName := alert.FilterId
+		if filter, filterE := c.con.FilterById(alert.FilterId); filterE == nil {
+			filterName = filter.Name
+		}
+		condition := fmt.Sprintf("%s %s %g per %ds", alert.Condition, alert.Operator, alert.Threshold, alert.Window)
+		since := time.Unix(alert.Since, 0).Format("2006-01-02 15:04:05")
+		fmt.Fprintf(c.Out, "%-20s\t%-20s\t%-30s\t%-8s\t%s\n", alert.Name, filterName, condition, alert.State, since)
+	}
+}
+
+// Create schedule, example input: "<name> cron '0 9 * * 1-5' notify <#channel|@user|webhook url> run <command>"
+func (c *Console) createSchedule(input string) {
+	m := createScheduleRegex.FindStringSubmatch(strings.TrimSpace(input))
+	if m == nil {
+		c.printError("Usage: create schedule <name> cron '<minute> <hour> <day> <month> <weekday>' notify <#channel|@user|webhook url> run <command>")
+		return
+	}
+	schedule := &Schedule{
+		Name:        m[1],
+		Cron:        m[2] + m[3],
+		Destination: m[4],
+		Command:     strings.TrimSpace(m[5]),
+	}
+	if err := c.con.CreateSchedule(schedule); err != nil {
+		c.printError(fmt.Sprintf("%s", err))
+		return
+	}
+	fmt.Fprintf(c.Out, "Created schedule '%s'\n", schedule.Name)
+}
+
+func (c *Console) dropSchedule(name string) {
+	res := c.con.RemoveSchedule(name)
+	if res {
+		fmt.Fprintf(c.Out, "Removed schedule '%s'\n", name)
+	} else {
+		c.printError(fmt.Sprintf("Schedule '%s' not found", name))
+	}
+}
+
+func (c *Console) pauseSchedule(name string, paused bool) {
+	if err := c.con.PauseSchedule(name, paused); err != nil {
+		c.printError(fmt.Sprintf("%s", err))
+		return
+	}
+	if paused {
+		fmt.Fprintf(c.Out, "Paused schedule '%s'\n", name)
+	} else {
+		fmt.Fprintf(c.Out, "Resumed schedule '%s'\n", name)
+	}
+}
+
+func (c *Console) showSchedules() {
+	schedules, err := c.con.Schedules()
+	if err != nil {
+		c.printError(fmt.Sprintf("%s", err))
+		return
+	}
+	fmt.Fprintf(c.Out, "%-20s\t%-15s\t%-20s\t%-8s\t%-20s\t%-20s\t%s\n", "NAME", "CRON", "NOTIFY", "STATE", "NEXT RUN", "LAST RUN", "COMMAND")
+	for _, schedule := range schedules {
+		state := "active"
+		if schedule.Paused {
+			state = "paused"
+		}
+		fmt.Fprintf(c.Out, "%-20s\t%-15s\t%-20s\t%-8s\t%-20s\t%-20s\t%s\n", schedule.Name, schedule.Cron, schedule.Destination, state,
+			formatScheduleTime(schedule.NextRun, ""), formatScheduleTime(schedule.LastRun, schedule.LastStatus), schedule.Command)
+	}
+}
+
+// Details and recent runs of a schedule
+func (c *Console) describeSchedule(name string) {
+	schedule, err := c.con.ScheduleByName(name)
+	if err != nil {
+		c.printError(fmt.Sprintf("%s", err))
+		return
+	}
+	runs, err := c.con.ScheduleRuns(schedule, 10)
+	if err != nil {
+		c.printError(fmt.Sprintf("%s", err))
+		return
+	}
+	fmt.Fprintf(c.Out, "Name: %s\nCron: %s\nNotify: %s\nCommand: %s\nPaused: %t\nCreated by: %s (%s)\nNext run: %s\n\n", schedule.Name, schedule.Cron,
+		schedule.Destination, schedule.Command, schedule.Paused, schedule.Creator, schedule.Role, formatScheduleTime(schedule.NextRun, ""))
+	fmt.Fprintf(c.Out, "%-20s\t%-8s\t%-10s\t%-8s\t%s\n", "STARTED", "STATUS", "DURATION", "MESSAGES", "ERROR")
+	for _, run := range runs {
+		duration := time.Duration(run.Duration) * time.Millisecond
+		fmt.Fprintf(c.Out, "%-20s\t%-8s\t%-10s\t%-8d\t%s\n", formatScheduleTime(run.Started, ""), run.Status, duration, run.Messages, run.Error)
+	}
+}
+
+func formatScheduleTime(ts int64, status string) string {
+	if ts < 1 {
+		return "-"
+	}
+	str := time.Unix(ts, 0).Format("2006-01-02 15:04:05")
+	if len(status) > 0 {
+		str = fmt.Sprintf("%s (%s)", str, status)
+	}
+	return str
+}
+
+// Create user, example input: "<username> identified by '<password>' [role reader]" [] indicates optional
+func (c *Console) createUser(input string) {
+	// Basic parsing
+	var username string = ""
+	var password string = ""
+	var role string = "reader"
+	tokens := strings.Split(input, " ")
+	for i, token := range tokens {
+		var previousToken string = ""
+		if i != 0 {
+			previousToken = tokens[i-1]
+		}
+
+		// Very simple parsing
+		if i == 0 {
+			// Username
+			username = token
+		} else if strings.ToLower(previousToken) == "by" {
+			// Password, optionally quoted
+			password = strings.Trim(token, "'\"")
+		} else if strings.ToLower(previousToken) == "role" {
+			// Role (reader, writer, admin)
+			role = strings.ToLower(token)
+		}
+	}
+	if len(username) < 1 || len(password) < 1 {
+		c.printError("Usage: create user <username> identified by '<password>' [role <reader|writer|admin>]")
+		return
+	}
+
+	// Create
+	if err := c.con.CreateUser(username, password, role); err != nil {
+		c.printError(fmt.Sprintf("%s", err))
+		return
+	}
+	fmt.Fprintf(c.Out, "Created user '%s' with role %s\n", username, role)
+}
+
+func (c *Console) dropUser(username string) {
+	res := c.con.RemoveUser(username)
+	if res {
+		fmt.Fprintf(c.Out, "Removed user '%s'\n", username)
+	} else {
+		c.printError(fmt.Sprintf("User '%s' not found", username))
+	}
+}
+
+func (c *Console) showUsers() {
+	users, err := c.con.Users()
+	if err != nil {
+		c.printError(fmt.Sprintf("%s", err))
+		return
+	}
+	fmt.Fprintf(c.Out, "%-20s\t%-8s\t%s\n", "USERNAME", "ROLE", "CREATED")
+	for _, user := range users {
+		created := time.Unix(user.Created, 0).Format("2006-01-02 15:04:05")
+		fmt.Fprintf(c.Out, "%-20s\t%-8s\t%s\n", user.Username, user.Role, created)
+	}
+}
+
+// Login, example input: "[<username> <password>] [role reader] [filters <filter_a>,<filter_b>] [expires 30d]" [] indicates optional
+// The token replaces the password in the session
+func (c *Console) login(input string) {
+	// Basic parsing
+	var role string = ""
+	var filters string = ""
+	var expiresStr string = ""
+	tokens := strings.Split(input, " ")
+	if len(tokens) >= 2 && tokens[0] != "role" && tokens[0] != "filters" && tokens[0] != "expires" {
+		c.Session["supervisor_username"] = tokens[0]
+		c.Session["supervisor_password"] = tokens[1]
+	}
+	for i, token := range tokens {
+		var previousToken string = ""
+		if i != 0 {
+			previousToken = tokens[i-1]
+		}
+
+		// Very simple parsing
+		if previousToken == "role" {
+			// Role (reader, writer, admin)
+			role = strings.ToLower(token)
+		} else if previousToken == "filters" {
+			// Filter names, comma separated
+			filters = token
+		} else if previousToken == "expires" {
+			// Expiry (e.g. 30d, 0 never expires)
+			expiresStr = token
+		}
+	}
+	if len(c.Session["supervisor_username"]) < 1 || len(c.Session["supervisor_password"]) < 1 {
+		c.printError("Usage: login <username> <password> [role <reader|writer|admin>] [filters <filter_a>,<filter_b>] [expires 30d]")
+		return
+	}
+
+	// Expiry, -1 uses the default of the supervisor
+	expires, expiresE := c.intFromTimeStr(expiresStr, -1)
+	if expiresE != nil {
+		return
+	}
+
+	// Request token with the credentials
+	c.Session["supervisor_token"] = ""
+	token, err := c.con.Login(role, filters, expires)
+	if err != nil {
+		c.printError(fmt.Sprintf("Failed to login: %s", err))
+		return
+	}
+
+	// Store token instead of the password
+	c.Session["supervisor_token"] = token
+	c.Session["supervisor_password"] = ""
+	c.saveSession()
+	fmt.Fprintf(c.Out, "Logged in as %s\n", c.Session["supervisor_username"])
+}
+
+// Revoke the token of the session
+func (c *Console) logout() {
+	if len(c.Session["supervisor_token"]) < 1 {
+		c.printError("Not logged in with a token")
+		return
+	}
+	tokens, err := c.con.Tokens()
+	if err == nil {
+		for _, token := range tokens {
+			if token.Current {
+				c.con.RevokeToken(token.Id)
+			}
+		}
+	}
+	c.Session["supervisor_token"] = ""
+	c.saveSession()
+	fmt.Fprintf(c.Out, "Logged out\n")
+}
+
+func (c *Console) showTokens() {
+	tokens, err := c.con.Tokens()
+	if err != nil {
+		c.printError(fmt.Sprintf("%s", err))
+		return
+	}
+	fmt.Fprintf(c.Out, "%-36s\t%-16s\t%-8s\t%-20s\t%-20s\t%s\n", "ID", "USERNAME", "ROLE", "EXPIRES", "FILTERS", "CURRENT")
+	for _, token := range tokens {
+		expires := "never"
+		if token.Expires > 0 {
+			expires = time.Unix(token.Expires, 0).Format("2006-01-02 15:04:05")
+		}
+		filterNames := make([]string, 0)
+		for _, id := range token.Filters {
+			if filter, filterE := c.con.FilterById(id); filterE == nil {
+				filterNames = append(filterNames, filter.Name)
+			} else {
+				filterNames = append(filterNames, id)
+			}
+		}
+		filters := strings.Join(filterNames, ",")
+		if len(filters) < 1 {
+			filters = "*"
+		}
+		current := ""
+		if token.Current {
+			current = "*"
+		}
+		fmt.Fprintf(c.Out, "%-36s\t%-16s\t%-8s\t%-20s\t%-20s\t%s\n", token.Id, token.Username, token.Role, expires, filters, current)
+	}
+}
+
+// Search backends of the supervisor and their health, the backend of the session is marked as current
+func (c *Console) showBackends() {
+	backends, err := c.con.SearchBackends(true)
+	if err != nil {
+		c.printError(fmt.Sprintf("%s", err))
+		return
+	}
+	if len(backends) == 0 {
+		fmt.Fprintln(c.Out, "No search backends configured, searches use the results of the supervisor")
+		return
+	}
+	fmt.Fprintf(c.Out, "%-16s\t%-10s\t%-24s\t%-24s\t%-8s\t%-8s\t%-8s\t%s\n", "NAME", "TYPE", "PROJECT", "DATASET", "VERSION", "DEFAULT", "CURRENT", "HEALTH")
+	for _, backend := range backends {
+		isDefault := ""
+		if backend.Default {
+			isDefault = "*"
+		}
+		current := ""
+		if backend.Id == c.Session["search_backend"] || (len(c.Session["search_backend"]) < 1 && backend.Default) {
+			current = "*"
+		}
+		fmt.Fprintf(c.Out, "%-16s\t%-10s\t%-24s\t%-24s\t%-8d\t%-8s\t%-8s\t%s\n", backend.Id, backend.Type, backend.ProjectId, backend.DatasetId, backend.TableVersion, isDefault, current, backend.Health)
+	}
+}
+
+// Search backend of the session, default restores the backend of the filters (or the default of the supervisor)
+func (c *Console) useBackend(name string) {
+	if len(name) < 1 {
+		c.printError("Usage: use backend <name|default>")
+		return
+	}
+	if strings.ToLower(name) == "default" {
+		delete(c.Session, "search_backend")
+		fmt.Fprintln(c.Out, "Using the search backend of the filters (use save to keep it)")
+		return
+	}
+	backends, err := c.con.SearchBackends(false)
+	if err != nil {
+		c.printError(fmt.Sprintf("%s", err))
+		return
+	}
+	for _, backend := range backends {
+		if backend.Id == name {
+			c.Session["search_backend"] = name
+			fmt.Fprintf(c.Out, "Using search backend %s (use save to keep it)\n", name)
+			return
+		}
+	}
+	c.printError(fmt.Sprintf("Unknown search backend %s, see show backends", name))
+}
+
+func (c *Console) connect(uri string) {
+	c.Session["supervisor_uri"] = uri
+	c._connect(true)
+}
+
+func (c *Console) clearConsole() {
+	if !c.Terminal {
+		return
+	}
+	cmd := exec.Command("clear")
+	cmd.Stdout = c.Out
+	cmd.Run()
+}
+
+func (c *Console) _connect(interactive bool) {
+	c.con = NewSupervisorCon(c)
+	if c.con.Connect() && interactive {
+		fmt.Fprintf(c.Out, "Use 'save' to store authentication and connection details for future usage\n")
+	}
+}
+
+func (c *Console) saveSession() {
+	if c.SaveSession != nil {
+		c.SaveSession()
+	}
+}
+
+func (c *Console) printError(input string) {
+	fmt.Fprintf(c.Out, "Unknown input '%s' type 'help' for explanation\n", input)
+}
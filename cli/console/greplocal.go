@@ -0,0 +1,172 @@
+// Local execution of grep-like commands on the results held by the supervisor (memory or bolt result store)
+// - cat -local <filter> | ... always runs locally, other cat commands only in case there is no search backend
+// - Same semantics as the query: uniq groups all equal lines (not only adjacent ones), unsorted output is in order of arrival
+// @author Robin Verlangen
+
+package console
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Filter and/or transform a line, false drops the line
+type grepStep func(line string) (string, bool)
+
+type grepLine struct {
+	line  string
+	count int64
+}
+
+// Fields of the line (1 based) joined by the delimiter, missing fields are empty
+func cutLine(line string, delimiter string, fields []int) string {
+	parts := strings.Split(line, delimiter)
+	vals := make([]string, len(fields))
+	for i, f := range fields {
+		if f <= len(parts) {
+			vals[i] = parts[f-1]
+		}
+	}
+	return strings.Join(vals, delimiter)
+}
+
+// Numbers sort before text, like FLOAT() of text is NULL in the query
+func compareNumeric(a string, b string) int {
+	fa, aE := strconv.ParseFloat(strings.TrimSpace(a), 64)
+	fb, bE := strconv.ParseFloat(strings.TrimSpace(b), 64)
+	switch {
+	case aE != nil && bE != nil:
+		return 0
+	case aE != nil:
+		return -1
+	case bE != nil:
+		return 1
+	case fa < fb:
+		return -1
+	case fa > fb:
+		return 1
+	}
+	return 0
+}
+
+func (g *GrepSQL) less(a *grepLine, b *grepLine) bool {
+	var cmp int
+	if g.sortByCount {
+		if a.count < b.count {
+			cmp = -1
+		} else if a.count > b.count {
+			cmp = 1
+		}
+	} else if g.sortNumeric {
+		cmp = compareNumeric(a.line, b.line)
+	} else {
+		cmp = strings.Compare(a.line, b.line)
+	}
+	if g.sortDesc {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+// Run the parsed commands on the lines, returns the header and the rows
+func (g *GrepSQL) Execute(lines []string) ([]string, [][]string) {
+	// Filter & transform
+	list := make([]*grepLine, 0)
+	for _, line := range lines {
+		ok := true
+		for _, step := range g.steps {
+			if line, ok = step(line); !ok {
+				break
+			}
+		}
+		if ok {
+			list = append(list, &grepLine{line: line, count: 1})
+		}
+	}
+
+	// Uniq
+	if g.uniq {
+		index := make(map[string]*grepLine)
+		grouped := make([]*grepLine, 0)
+		for _, l := range list {
+			if existing, ok := index[l.line]; ok {
+				existing.count++
+				continue
+			}
+			index[l.line] = l
+			grouped = append(grouped, l)
+		}
+		list = grouped
+	}
+
+	// Count
+	if g.count {
+		return []string{"count"}, [][]string{{strconv.Itoa(len(list))}}
+	}
+
+	// Sort, tail of unsorted unique lines is on the line itself
+	if g.sort {
+		sort.SliceStable(list, func(i, j int) bool {
+			return g.less(list[i], list[j])
+		})
+	} else if g.tail && g.uniq {
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].line < list[j].line
+		})
+	}
+
+	// Head & tail
+	if g.limit != -1 && int64(len(list)) > g.limit {
+		if g.tail {
+			list = list[int64(len(list))-g.limit:]
+		} else {
+			list = list[:g.limit]
+		}
+	}
+	if g.tail && g.tailHead != -1 && int64(len(list)) > g.tailHead {
+		list = list[:g.tailHead]
+	}
+
+	// Output
+	header := []string{g.outputKey()}
+	if g.uniqCount {
+		header = append(header, "count")
+	}
+	rows := make([][]string, 0)
+	for _, l := range list {
+		row := []string{l.line}
+		if g.uniqCount {
+			row = append(row, strconv.FormatInt(l.count, 10))
+		}
+		rows = append(rows, row)
+	}
+	return header, rows
+}
+
+func (c *Console) executeGrepLocal(ctx context.Context, g *GrepSQL, tee *OutFile) {
+	lines, err := c.con.Results(ctx, g.filter.Id)
+	if err != nil {
+		c.printError(fmt.Sprintf("%s", err))
+		return
+	}
+	header, rows := g.Execute(lines)
+
+	// Write
+	w, fw, err := c.newQueryResultWriter(nil, tee)
+	if err != nil {
+		c.printError(fmt.Sprintf("%s", err))
+		return
+	}
+	defer c.closeQueryResultWriter(fw)
+	w.WriteHeader(header)
+	for _, row := range rows {
+		if err := w.WriteRow(row); err != nil {
+			c.printError(fmt.Sprintf("%s", err))
+			break
+		}
+	}
+	w.Flush()
+}
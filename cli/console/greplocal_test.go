@@ -0,0 +1,55 @@
+package console
+
+import (
+	"strings"
+	"testing"
+)
+
+// Lines of the local tests, the pipelines are the same as those of the query tests (see grepsql_test.go)
+var grepTestLines []string = []string{
+	"GET /checkout,200,12ms a.b",
+	"GET /index,404,3ms error a.b",
+	"POST /Checkout,500,120ms err: 50% a.b",
+	"GET /index,200,5ms it's a",
+	"GET /error,500,7ms a\\b",
+	"GET /index,200,5ms it's a",
+}
+
+func TestGrepSQLExecute(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"grep -vi Checkout", "_raw\nGET /index,404,3ms error a.b\nGET /index,200,5ms it's a\nGET /error,500,7ms a\\b\nGET /index,200,5ms it's a"},
+		{"grep -w err | grep -F a.b", "_raw\nPOST /Checkout,500,120ms err: 50% a.b"},
+		{"grep -F 50%", "_raw\nPOST /Checkout,500,120ms err: 50% a.b"},
+		{"grep -oE '[0-9]+ms'", "line\n12ms\n3ms\n120ms\n5ms\n7ms\n5ms"},
+		{"grep -c 404", "count\n1"},
+		{"grep x | wc -l", "count\n3"},
+		{"tail -n 5 | head -2", "_raw\nGET /index,404,3ms error a.b\nPOST /Checkout,500,120ms err: 50% a.b"},
+		{"sort | tail -3 | limit 1", "_raw\nGET /index,200,5ms it's a"},
+		{"head -5 | head -n 10", "_raw\nGET /checkout,200,12ms a.b\nGET /index,404,3ms error a.b\nPOST /Checkout,500,120ms err: 50% a.b\nGET /index,200,5ms it's a\nGET /error,500,7ms a\\b"},
+		{"uniq -c | sort -r", "line\tcount\nGET /index,200,5ms it's a\t2\nGET /checkout,200,12ms a.b\t1\nGET /index,404,3ms error a.b\t1\nPOST /Checkout,500,120ms err: 50% a.b\t1\nGET /error,500,7ms a\\b\t1"},
+		{"sort -rn | uniq", "line\nGET /checkout,200,12ms a.b\nGET /index,404,3ms error a.b\nPOST /Checkout,500,120ms err: 50% a.b\nGET /index,200,5ms it's a\nGET /error,500,7ms a\\b"},
+		{"cut -d : -f 2", "line\n\n\n 50% a.b\n\n\n"},
+		{"cut -d, -f1,3 | sort -u", "line\nGET /checkout,12ms a.b\nGET /error,7ms a\\b\nGET /index,3ms error a.b\nGET /index,5ms it's a\nPOST /Checkout,120ms err: 50% a.b"},
+		{"grep \"it's\" | grep -E 'a\\b'", "_raw\nGET /index,200,5ms it's a\nGET /index,200,5ms it's a"},
+	}
+
+	// Output is the header and rows, cells separated by tabs
+	for _, test := range tests {
+		g, err := grepTestParse(test.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.input, err)
+			continue
+		}
+		header, rows := g.Execute(grepTestLines)
+		buf := []string{strings.Join(header, "\t")}
+		for _, row := range rows {
+			buf = append(buf, strings.Join(row, "\t"))
+		}
+		if output := strings.Join(buf, "\n"); output != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.input, test.expected, output)
+		}
+	}
+}
@@ -0,0 +1,613 @@
+// Convert grep-like commands to SQL for ClouePelican
+// cat -local <filter> | ... runs the commands on the results held by the supervisor (see greplocal.go)
+// cat <filter> [since <time>] [until <time>] [window <duration>] | ... searches the daily tables of the range (see timerange.go)
+// Example: cat errors | grep -v 404 | grep -i checkout | grep -E "(100|200)"
+// SELECT _raw FROM errors WHERE NOT _raw LIKE '%404%' AND LOWER(_raw) LIKE '%checkout%' AND REGEXP_MATCH(_raw, '(100|200)')
+// Supported commands (short flags can be combined, e.g. -vi):
+//   grep [-v] [-i] [-w] [-F | -E] [-o] [-c] [-e] <pattern>   without -E the pattern is a fixed string
+//   sort [-r] [-n] [-u]
+//   uniq [-c]
+//   cut [-d <delimiter>] -f <field>[,<field> ...]
+//   head [-n <n> | -<n>], tail [-n <n> | -<n>], limit <n>   head and limit after a tail take the first of the last lines
+//   wc -l
+// @author Robin Verlangen
+
+// @todo Support color
+
+package console
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const GREP_DEFAULT_LINES int64 = 10
+
+type GrepSQL struct {
+	con    *SupervisorCon
+	input  string
+	cmds   []GrepCmd
+	filter *Filter
+	local  bool // cat -local
+
+	// Nil is today
+	timeRange *TimeRange
+
+	// Local equivalent of the where clauses and column expressions, in order
+	steps []grepStep
+
+	// Query state, every command transforms the state of the commands before it
+	column      string // Expression of the output line
+	wheres      []string
+	count       bool // wc -l or grep -c
+	uniq        bool
+	uniqCount   bool
+	sort        bool
+	sortByCount bool // Sort after uniq -c
+	sortNumeric bool
+	sortDesc    bool
+	limit       int64 // -1 is unlimited
+	tail        bool
+	tailHead    int64 // Head within the lines of the tail, -1 is none
+}
+
+type GrepCmd struct {
+	flags   map[string]bool // v=exclude, i=case-insensitive, e/E=regex, F=fixed string, w=word, o=only matching, c=count
+	pattern string
+}
+
+// Regex of the pattern
+func (w *GrepCmd) Regex() string {
+	pattern := w.pattern
+	if !w.flags["E"] || w.flags["F"] {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if w.flags["w"] {
+		pattern = fmt.Sprintf(`\b(?:%s)\b`, pattern)
+	}
+	if w.flags["i"] && !strings.HasPrefix(pattern, "(?i)") {
+		pattern = fmt.Sprintf("(?i)%s", pattern)
+	}
+	return pattern
+}
+
+func (w *GrepCmd) Where(column string) string {
+	if Verbose {
+		log.Printf("%v", w)
+	}
+
+	// Exclude?
+	var notPrefix string = ""
+	if w.flags["v"] {
+		notPrefix = "NOT "
+	}
+
+	// Regex?
+	if w.flags["E"] || w.flags["w"] || w.flags["o"] || strings.ContainsAny(w.pattern, "%_") {
+		return fmt.Sprintf("%sREGEXP_MATCH(%s, %s)", notPrefix, column, quoteSqlString(w.Regex()))
+	}
+
+	// Case sensitive?
+	if w.flags["i"] {
+		return fmt.Sprintf("%sLOWER(%s) LIKE %s", notPrefix, column, quoteSqlString(fmt.Sprintf("%%%s%%", strings.ToLower(w.pattern))))
+	}
+
+	// Regular matching
+	return fmt.Sprintf("%s%s LIKE %s", notPrefix, column, quoteSqlString(fmt.Sprintf("%%%s%%", w.pattern)))
+}
+
+// Split the input into words like a shell, quotes group words and pipes are words of their own
+func splitGrepWords(input string) ([]string, error) {
+	words := make([]string, 0)
+	var buf []byte
+	inWord := false
+	for i := 0; i < len(input); i++ {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inWord {
+				words = append(words, string(buf))
+				buf = nil
+				inWord = false
+			}
+		case c == '|':
+			if inWord {
+				words = append(words, string(buf))
+				buf = nil
+				inWord = false
+			}
+			words = append(words, "|")
+		case c == '\'' || c == '"':
+			// Quoted, backslash escapes the quote and backslash within double quotes
+			inWord = true
+			i++
+			closed := false
+			for ; i < len(input); i++ {
+				if c == '"' && input[i] == '\\' && i+1 < len(input) && (input[i+1] == '"' || input[i+1] == '\\') {
+					buf = append(buf, input[i+1])
+					i++
+					continue
+				}
+				if input[i] == c {
+					closed = true
+					break
+				}
+				buf = append(buf, input[i])
+			}
+			if !closed {
+				return nil, errors.New(fmt.Sprintf("Unterminated quote %c", c))
+			}
+		case c == '\\' && i+1 < len(input):
+			inWord = true
+			buf = append(buf, input[i+1])
+			i++
+		default:
+			inWord = true
+			buf = append(buf, c)
+		}
+	}
+	if inWord {
+		words = append(words, string(buf))
+	}
+	return words, nil
+}
+
+// Number of lines of head and tail: -n 50, -n50, -50 or the default
+func parseGrepLines(cmd string, args []string) (int64, error) {
+	var val string
+	switch {
+	case len(args) == 0:
+		return GREP_DEFAULT_LINES, nil
+	case len(args) == 2 && args[0] == "-n":
+		val = args[1]
+	case len(args) == 1 && strings.HasPrefix(args[0], "-n"):
+		val = args[0][2:]
+	case len(args) == 1 && strings.HasPrefix(args[0], "-"):
+		val = args[0][1:]
+	default:
+		return 0, errors.New(fmt.Sprintf("Usage: %s [-n <lines>]", cmd))
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New(fmt.Sprintf("Invalid number of lines %s", val))
+	}
+	return n, nil
+}
+
+// Short flags, combined flags are split (-vi => v, i)
+func parseGrepFlags(cmd string, arg string, allowed string) (map[string]bool, error) {
+	flags := make(map[string]bool)
+	for _, r := range arg[1:] {
+		if !strings.ContainsRune(allowed, r) {
+			return nil, errors.New(fmt.Sprintf("Invalid flag -%c of %s", r, cmd))
+		}
+		flags[string(r)] = true
+	}
+	return flags, nil
+}
+
+// Expression of fields of the line (1 based), split on the delimiter
+func cutExpr(column string, delimiter string, fields []int) string {
+	d := regexp.QuoteMeta(delimiter)
+	exprs := make([]string, 0)
+	for _, f := range fields {
+		var regex string
+		if f == 1 {
+			regex = fmt.Sprintf("^([^%s]*)", d)
+		} else {
+			regex = fmt.Sprintf("^(?:[^%s]*%s){%d}([^%s]*)", d, d, f-1, d)
+		}
+		exprs = append(exprs, fmt.Sprintf("REGEXP_EXTRACT(%s, %s)", column, quoteSqlString(regex)))
+	}
+	if len(exprs) == 1 {
+		return exprs[0]
+	}
+	return fmt.Sprintf("CONCAT(%s)", strings.Join(exprs, fmt.Sprintf(", %s, ", quoteSqlString(delimiter))))
+}
+
+func (g *GrepSQL) parseGrep(args []string) error {
+	cmd := newGrepCmd()
+	hasPattern := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if strings.HasPrefix(arg, "-") && len(arg) > 1 && !hasPattern {
+			flags, err := parseGrepFlags("grep", arg, "viwFEeoc")
+			if err != nil {
+				return err
+			}
+			for flag := range flags {
+				cmd.flags[flag] = true
+			}
+			// -e takes the pattern as argument, the pattern is a regex
+			if flags["e"] {
+				cmd.flags["E"] = true
+				if i+1 >= len(args) {
+					return errors.New("Missing pattern after -e")
+				}
+				i++
+				cmd.pattern = args[i]
+				hasPattern = true
+			}
+			continue
+		}
+		if hasPattern {
+			return errors.New(fmt.Sprintf("Unexpected argument %s of grep", arg))
+		}
+		cmd.pattern = arg
+		hasPattern = true
+	}
+	if !hasPattern {
+		return errors.New("Missing pattern of grep")
+	}
+	if cmd.flags["E"] && cmd.flags["F"] {
+		return errors.New("Flags -E and -F can not be combined")
+	}
+	if cmd.flags["o"] && (cmd.flags["v"] || cmd.flags["c"]) {
+		return errors.New("Flag -o can not be combined with -v or -c")
+	}
+	if cmd.flags["E"] {
+		if _, err := regexp.Compile(cmd.pattern); err != nil {
+			return errors.New(fmt.Sprintf("Invalid regex %s: %s", cmd.pattern, err))
+		}
+	}
+
+	// Filter
+	g.wheres = append(g.wheres, cmd.Where(g.column))
+	if cmd.flags["o"] {
+		g.column = fmt.Sprintf("REGEXP_EXTRACT(%s, %s)", g.column, quoteSqlString(fmt.Sprintf("(%s)", cmd.Regex())))
+	}
+	if cmd.flags["c"] {
+		g.count = true
+	}
+
+	// Local
+	regex, err := regexp.Compile(cmd.Regex())
+	if err != nil {
+		return errors.New(fmt.Sprintf("Invalid regex %s: %s", cmd.pattern, err))
+	}
+	exclude := cmd.flags["v"]
+	onlyMatching := cmd.flags["o"]
+	g.steps = append(g.steps, func(line string) (string, bool) {
+		if regex.MatchString(line) == exclude {
+			return line, false
+		}
+		if onlyMatching {
+			return regex.FindString(line), true
+		}
+		return line, true
+	})
+	g.cmds = append(g.cmds, *cmd)
+	return nil
+}
+
+func (g *GrepSQL) parseCut(args []string) error {
+	delimiter := "\t"
+	var fieldsStr string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		var val string
+		if len(arg) > 2 {
+			val = arg[2:]
+		} else if i+1 < len(args) {
+			i++
+			val = args[i]
+		}
+		switch {
+		case strings.HasPrefix(arg, "-d"):
+			if len(val) != 1 {
+				return errors.New("The delimiter of cut must be a single character")
+			}
+			delimiter = val
+		case strings.HasPrefix(arg, "-f"):
+			fieldsStr = val
+		default:
+			return errors.New(fmt.Sprintf("Invalid argument %s of cut, use -d and -f", arg))
+		}
+	}
+	fields := make([]int, 0)
+	for _, f := range strings.Split(fieldsStr, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n < 1 {
+			return errors.New(fmt.Sprintf("Invalid field list '%s' of cut", fieldsStr))
+		}
+		fields = append(fields, n)
+	}
+	g.column = cutExpr(g.column, delimiter, fields)
+	g.steps = append(g.steps, func(line string) (string, bool) {
+		return cutLine(line, delimiter, fields), true
+	})
+	return nil
+}
+
+func (g *GrepSQL) parseCommand(words []string) error {
+	cmd := words[0]
+	args := words[1:]
+	if g.count {
+		return errors.New(fmt.Sprintf("Command %s can not follow a count", cmd))
+	}
+	if g.limit != -1 && cmd != "head" && cmd != "limit" {
+		return errors.New(fmt.Sprintf("Command %s can not follow head, tail or limit", cmd))
+	}
+	switch cmd {
+	case "grep":
+		if g.uniq {
+			return errors.New("Command grep can not follow uniq")
+		}
+		return g.parseGrep(args)
+	case "sort":
+		g.sort = true
+		g.sortByCount = g.uniqCount
+		g.sortNumeric = false
+		g.sortDesc = false
+		for _, arg := range args {
+			flags, err := parseGrepFlags("sort", arg, "rnu")
+			if err != nil {
+				return err
+			}
+			if flags["r"] {
+				g.sortDesc = true
+			}
+			if flags["n"] {
+				g.sortNumeric = true
+			}
+			if flags["u"] {
+				g.uniq = true
+			}
+		}
+	case "uniq":
+		if len(args) > 1 || (len(args) == 1 && args[0] != "-c") {
+			return errors.New("Usage: uniq [-c]")
+		}
+		g.uniq = true
+		g.uniqCount = len(args) == 1
+	case "cut":
+		if g.uniq {
+			return errors.New("Command cut can not follow uniq")
+		}
+		return g.parseCut(args)
+	case "wc":
+		if len(args) != 1 || args[0] != "-l" {
+			return errors.New("Usage: wc -l")
+		}
+		g.count = true
+	case "head", "tail":
+		n, err := parseGrepLines(cmd, args)
+		if err != nil {
+			return err
+		}
+		if cmd == "tail" {
+			g.tail = true
+			g.limit = n
+		} else {
+			g.head(n)
+		}
+	case "limit":
+		if len(args) != 1 {
+			return errors.New("Usage: limit <n>")
+		}
+		n, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || n < 0 {
+			return errors.New(fmt.Sprintf("Invalid limit %s", args[0]))
+		}
+		g.head(n)
+	default:
+		return errors.New(fmt.Sprintf("Invalid token %s", cmd))
+	}
+	return nil
+}
+
+// First lines, after a tail these are the first of the last lines
+func (g *GrepSQL) head(n int64) {
+	if g.tail {
+		if g.tailHead == -1 || n < g.tailHead {
+			g.tailHead = n
+		}
+	} else if g.limit == -1 || n < g.limit {
+		g.limit = n
+	}
+}
+
+// Name of the output line
+func (g *GrepSQL) outputKey() string {
+	if g.column == "_raw" && !g.uniq {
+		return "_raw"
+	}
+	return "line"
+}
+
+// Expression to sort on, empty if not sorted
+func (g *GrepSQL) sortExpr() string {
+	if !g.sort {
+		return ""
+	}
+	if g.sortByCount {
+		return "count"
+	}
+	if g.sortNumeric {
+		return fmt.Sprintf("FLOAT(%s)", g.outputKey())
+	}
+	return g.outputKey()
+}
+
+// Select list of the output
+func (g *GrepSQL) selectList() string {
+	if g.count {
+		if g.uniq {
+			return fmt.Sprintf("EXACT_COUNT_DISTINCT(%s) AS count", g.column)
+		}
+		return "COUNT(*) AS count"
+	}
+	var col string = g.column
+	if g.column != "_raw" || g.uniq {
+		col = fmt.Sprintf("%s AS line", g.column)
+	}
+	if g.uniqCount {
+		return fmt.Sprintf("%s, COUNT(*) AS count", col)
+	}
+	return col
+}
+
+func (g *GrepSQL) Parse() (string, error) {
+	// Tokenize
+	words, err := splitGrepWords(g.input)
+	if err != nil {
+		return "", err
+	}
+	if len(words) > 1 && strings.ToLower(words[1]) == "-local" {
+		g.local = true
+		words = append(words[:1], words[2:]...)
+	}
+	if len(words) < 2 || strings.ToLower(words[0]) != "cat" || words[1] == "|" {
+		return "", errors.New("Invalid input, use: cat [-local] <filter> | grep <pattern>")
+	}
+	if Verbose {
+		log.Printf("Words: %v", words)
+	}
+
+	// Validate & fetch filter
+	filter, filterE := g.con.FilterByName(words[1])
+	if filterE != nil {
+		return "", filterE
+	}
+	g.filter = filter
+
+	// Time range
+	i := 2
+	for i < len(words) && words[i] != "|" {
+		if i+1 >= len(words) || words[i+1] == "|" {
+			return "", errors.New(fmt.Sprintf("Missing value of %s", words[i]))
+		}
+		if g.timeRange == nil {
+			g.timeRange = &TimeRange{}
+		}
+		if err := g.timeRange.Set(words[i], words[i+1]); err != nil {
+			return "", err
+		}
+		i += 2
+	}
+	if g.timeRange != nil && g.local {
+		return "", errors.New("A time range is not supported with -local")
+	}
+
+	// Commands
+	if err := g.parseCommands(words[i:]); err != nil {
+		return "", err
+	}
+
+	// Print structure
+	if Verbose {
+		log.Printf("%v", g)
+	}
+	table, tableE := filter.GetSearchTableName(g.timeRange)
+	if tableE != nil {
+		return "", tableE
+	}
+	if g.timeRange != nil && len(g.timeRange.BigQuery()) > 0 {
+		g.wheres = append(g.wheres, g.timeRange.BigQuery())
+	}
+	return g.query(table), nil
+}
+
+// Commands separated by pipes, the words after the filter and time range
+func (g *GrepSQL) parseCommands(words []string) error {
+	g.column = "_raw"
+	g.limit = -1
+	g.tailHead = -1
+	var current []string = nil
+	for _, word := range append(words, "|") {
+		if word != "|" {
+			current = append(current, word)
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if err := g.parseCommand(current); err != nil {
+			return err
+		}
+		current = nil
+	}
+	if len(words) > 0 && words[len(words)-1] == "|" {
+		return errors.New("Missing command after |")
+	}
+	return nil
+}
+
+// SQL of the parsed commands on the table
+func (g *GrepSQL) query(table string) string {
+	var buf []string
+	buf = append(buf, fmt.Sprintf("SELECT %s FROM %s", g.selectList(), table))
+
+	// Where part?
+	if len(g.wheres) > 0 {
+		buf = append(buf, fmt.Sprintf("WHERE %s", strings.Join(g.wheres, " AND ")))
+	}
+	if g.uniq && !g.count {
+		buf = append(buf, "GROUP BY line")
+	}
+	if g.count {
+		return strings.Join(buf, " ")
+	}
+
+	// Tail, the last lines in the order of the sort (time if not sorted), in that same order
+	if g.tail && g.limit != -1 {
+		key := g.sortExpr()
+		if len(key) == 0 {
+			if g.uniq {
+				key = g.outputKey()
+			} else {
+				key = "timestamp"
+				buf[0] = fmt.Sprintf("SELECT %s, timestamp FROM %s", g.selectList(), table)
+			}
+		}
+		inner := fmt.Sprintf("%s ORDER BY %s %s LIMIT %d", strings.Join(buf, " "), key, sortDirection(!g.sortDesc), g.limit)
+		outerCols := g.outputKey()
+		if g.uniqCount {
+			outerCols = "line, count"
+		}
+		outer := fmt.Sprintf("SELECT %s FROM (%s) ORDER BY %s %s", outerCols, inner, key, sortDirection(g.sortDesc))
+		if g.tailHead != -1 {
+			outer = fmt.Sprintf("%s LIMIT %d", outer, g.tailHead)
+		}
+		return outer
+	}
+
+	// Sort
+	if g.sort {
+		buf = append(buf, fmt.Sprintf("ORDER BY %s %s", g.sortExpr(), sortDirection(g.sortDesc)))
+	}
+
+	// Header
+	if g.limit != -1 {
+		buf = append(buf, fmt.Sprintf("LIMIT %d", g.limit))
+	}
+
+	// Done
+	return strings.Join(buf, " ")
+}
+
+func sortDirection(desc bool) string {
+	if desc {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+func newGrepCmd() *GrepCmd {
+	return &GrepCmd{
+		flags: make(map[string]bool),
+	}
+}
+
+func newGrepSQL(con *SupervisorCon, input string) *GrepSQL {
+	return &GrepSQL{
+		con:   con,
+		input: input,
+		cmds:  make([]GrepCmd, 0),
+		steps: make([]grepStep, 0),
+	}
+}
@@ -0,0 +1,72 @@
+package console
+
+import (
+	"testing"
+)
+
+// Parse the commands after cat <filter>, no supervisor is needed
+func grepTestParse(input string) (*GrepSQL, error) {
+	g := newGrepSQL(nil, input)
+	words, err := splitGrepWords(input)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.parseCommands(words); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func TestGrepSQLQuery(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"grep -vi Checkout", "SELECT _raw FROM t WHERE NOT LOWER(_raw) LIKE '%checkout%'"},
+		{"grep -w err | grep -F a.b", "SELECT _raw FROM t WHERE REGEXP_MATCH(_raw, '\\\\b(?:err)\\\\b') AND _raw LIKE '%a.b%'"},
+		{"grep -F 50%", "SELECT _raw FROM t WHERE REGEXP_MATCH(_raw, '50%')"},
+		{"grep -oE '[0-9]+ms'", "SELECT REGEXP_EXTRACT(_raw, '([0-9]+ms)') AS line FROM t WHERE REGEXP_MATCH(_raw, '[0-9]+ms')"},
+		{"grep -c 404", "SELECT COUNT(*) AS count FROM t WHERE _raw LIKE '%404%'"},
+		{"grep x | wc -l", "SELECT COUNT(*) AS count FROM t WHERE _raw LIKE '%x%'"},
+		{"tail -n 5 | head -2", "SELECT _raw FROM (SELECT _raw, timestamp FROM t ORDER BY timestamp DESC LIMIT 5) ORDER BY timestamp ASC LIMIT 2"},
+		{"sort | tail -3 | limit 1", "SELECT _raw FROM (SELECT _raw FROM t ORDER BY _raw DESC LIMIT 3) ORDER BY _raw ASC LIMIT 1"},
+		{"head -5 | head -n 10", "SELECT _raw FROM t LIMIT 5"},
+		{"uniq -c | sort -r", "SELECT _raw AS line, COUNT(*) AS count FROM t GROUP BY line ORDER BY count DESC"},
+		{"sort -rn | uniq", "SELECT _raw AS line FROM t GROUP BY line ORDER BY FLOAT(line) DESC"},
+		{"cut -d : -f 2", "SELECT REGEXP_EXTRACT(_raw, '^(?:[^:]*:){1}([^:]*)') AS line FROM t"},
+		{"cut -d, -f1,3 | sort -u", "SELECT CONCAT(REGEXP_EXTRACT(_raw, '^([^,]*)'), ',', REGEXP_EXTRACT(_raw, '^(?:[^,]*,){2}([^,]*)')) AS line FROM t GROUP BY line ORDER BY line ASC"},
+		{"grep \"it's\" | grep -E 'a\\b'", "SELECT _raw FROM t WHERE _raw LIKE '%it\\'s%' AND REGEXP_MATCH(_raw, 'a\\\\b')"},
+	}
+	for _, test := range tests {
+		g, err := grepTestParse(test.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.input, err)
+			continue
+		}
+		if sql := g.query("t"); sql != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.input, test.expected, sql)
+		}
+	}
+}
+
+func TestGrepSQLErrors(t *testing.T) {
+	tests := []string{
+		"grep",
+		"grep -E -F x",
+		"grep -ov x",
+		"grep -E '('",
+		"grep -x y",
+		"head -5 | grep x",
+		"uniq | grep x",
+		"wc -l | sort",
+		"cut -d ab -f 1",
+		"cut -f 0",
+		"grep x |",
+		"grep 'x",
+	}
+	for _, input := range tests {
+		if _, err := grepTestParse(input); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+// Query results into files
+// - select ... into outfile '<path>' [format csv] [rotate 100MB] writes the results to the file only
+// - <query> | tee <path> [format csv] [rotate 100MB] writes the results to the file and the console
+// - Paths ending with .gz are compressed with gzip
+// - The format defaults to the extension (.csv, .tsv, .json), otherwise raw
+// - Rotation moves the current file to <path>.1, <path>.2, .. (before .gz) and continues in a new file
+// @author Robin Verlangen
+
+package console
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var sizeRegex = regexp.MustCompile(`^(\d+)\s*([kmgt]?)i?b?$`)
+
+var teeRegex = regexp.MustCompile(`(?i)^\|\s*tee\s+(.+?)\s*;?\s*$`)
+
+type OutFile struct {
+	Path   string
+	Format string // Empty derives the format from the extension
+	Rotate int64  // Bytes (uncompressed), 0 disables rotation
+}
+
+// Open file, rotation happens between rows
+type OutFileWriter struct {
+	outFile  *OutFile
+	file     *os.File
+	gz       *gzip.Writer
+	written  int64
+	rotation int
+}
+
+// Writes rows into the file, starts a new file with the header when the file exceeds the rotation size
+type FileResultWriter struct {
+	out    *OutFileWriter
+	format string
+	header []string
+	w      ResultWriter
+	rows   int64
+	tee    bool
+}
+
+// Writes rows to multiple writers
+type TeeResultWriter struct {
+	writers []ResultWriter
+}
+
+func (o *OutFile) String() string {
+	buf := []string{quoteSqlString(o.Path)}
+	if len(o.Format) > 0 {
+		buf = append(buf, fmt.Sprintf("FORMAT %s", o.Format))
+	}
+	if o.Rotate > 0 {
+		buf = append(buf, fmt.Sprintf("ROTATE %d", o.Rotate))
+	}
+	return strings.Join(buf, " ")
+}
+
+func (o *OutFile) Gzip() bool {
+	return strings.HasSuffix(strings.ToLower(o.Path), ".gz")
+}
+
+// Format of the file, the extension is used in case no format is set
+func (o *OutFile) OutputFormat() string {
+	if len(o.Format) > 0 {
+		return o.Format
+	}
+	path := strings.TrimSuffix(strings.ToLower(o.Path), ".gz")
+	switch filepath.Ext(path) {
+	case ".csv":
+		return OUTPUT_FORMAT_CSV
+	case ".tsv":
+		return OUTPUT_FORMAT_TSV
+	case ".json", ".jsonl":
+		return OUTPUT_FORMAT_JSON
+	}
+	return OUTPUT_FORMAT_RAW
+}
+
+func (o *OutFile) Validate() error {
+	if len(o.Path) < 1 {
+		return errors.New("Please provide a file name")
+	}
+	if len(o.Format) > 0 {
+		if err := ValidateOutputFormat(o.Format); err != nil {
+			return err
+		}
+	}
+	if o.Rotate < 0 {
+		return errors.New("Rotation size can not be negative")
+	}
+	return nil
+}
+
+// Path of a rotated file, the number goes before the .gz extension
+func (o *OutFile) rotatedPath(n int) string {
+	if o.Gzip() {
+		return fmt.Sprintf("%s.%d%s", o.Path[:len(o.Path)-3], n, o.Path[len(o.Path)-3:])
+	}
+	return fmt.Sprintf("%s.%d", o.Path, n)
+}
+
+// Open the file for writing, an existing file is truncated
+func (o *OutFile) Open() (*OutFileWriter, error) {
+	w := &OutFileWriter{outFile: o}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *OutFileWriter) open() error {
+	file, err := os.OpenFile(w.outFile.Path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.written = 0
+	if w.outFile.Gzip() {
+		w.gz = gzip.NewWriter(file)
+	}
+	return nil
+}
+
+func (w *OutFileWriter) Write(p []byte) (int, error) {
+	var n int
+	var err error
+	if w.gz != nil {
+		n, err = w.gz.Write(p)
+	} else {
+		n, err = w.file.Write(p)
+	}
+	w.written += int64(n)
+	return n, err
+}
+
+// Rotation is due
+func (w *OutFileWriter) Full() bool {
+	return w.outFile.Rotate > 0 && w.written >= w.outFile.Rotate
+}
+
+// Move the current file aside and continue in a new file
+func (w *OutFileWriter) Rotate() error {
+	if err := w.Close(); err != nil {
+		return err
+	}
+	w.rotation++
+	if err := os.Rename(w.outFile.Path, w.outFile.rotatedPath(w.rotation)); err != nil {
+		return err
+	}
+	return w.open()
+}
+
+func (w *OutFileWriter) Close() error {
+	if w.file == nil {
+		return nil // Closed already, e.g. by a failed rotation
+	}
+	defer func() { w.file = nil }()
+	if w.gz != nil {
+		if err := w.gz.Close(); err != nil {
+			w.file.Close()
+			return err
+		}
+		w.gz = nil
+	}
+	return w.file.Close()
+}
+
+func (w *FileResultWriter) WriteHeader(columns []string) {
+	w.header = columns
+	w.w.WriteHeader(columns)
+}
+
+func (w *FileResultWriter) WriteRow(values []string) error {
+	if w.out.Full() {
+		w.w.Flush()
+		if err := w.out.Rotate(); err != nil {
+			return errors.New(fmt.Sprintf("Failed to rotate %s: %s", w.out.outFile.Path, err))
+		}
+		w.w = newResultWriter(w.format, w.out)
+		w.w.WriteHeader(w.header)
+	}
+	if err := w.w.WriteRow(values); err != nil {
+		return err
+	}
+	w.rows++
+
+	// The CSV writer buffers, the size of the file is only known once flushed
+	if w.format == OUTPUT_FORMAT_CSV {
+		w.w.Flush()
+	}
+	return nil
+}
+
+func (w *FileResultWriter) Flush() {
+	w.w.Flush()
+}
+
+func (w *FileResultWriter) Close() error {
+	w.w.Flush()
+	return w.out.Close()
+}
+
+func (w *TeeResultWriter) WriteHeader(columns []string) {
+	for _, writer := range w.writers {
+		writer.WriteHeader(columns)
+	}
+}
+
+func (w *TeeResultWriter) WriteRow(values []string) error {
+	for _, writer := range w.writers {
+		if err := writer.WriteRow(values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *TeeResultWriter) Flush() {
+	for _, writer := range w.writers {
+		writer.Flush()
+	}
+}
+
+func newFileResultWriter(o *OutFile) (*FileResultWriter, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	out, err := o.Open()
+	if err != nil {
+		return nil, err
+	}
+	format := o.OutputFormat()
+	return &FileResultWriter{
+		out:    out,
+		format: format,
+		w:      newResultWriter(format, out),
+	}, nil
+}
+
+// Writer for the results of a query: the outfile, the console and the tee file, or the console
+// The file writer (nil if none) must be closed once the query is done
+func (c *Console) newQueryResultWriter(into *OutFile, tee *OutFile) (ResultWriter, *FileResultWriter, error) {
+	if (into != nil || tee != nil) && !c.AllowFiles {
+		return nil, nil, errors.New("Writing results to files is not allowed")
+	}
+	if into != nil {
+		fw, err := newFileResultWriter(into)
+		return fw, fw, err
+	}
+	if tee != nil {
+		fw, err := newFileResultWriter(tee)
+		if err != nil {
+			return nil, nil, err
+		}
+		fw.tee = true
+		return &TeeResultWriter{writers: []ResultWriter{c.newConsoleResultWriter(), fw}}, fw, nil
+	}
+	return c.newConsoleResultWriter(), nil, nil
+}
+
+// Size with an optional unit, e.g. 100MB, 10k or 1048576
+func parseSize(s string) (int64, error) {
+	m := sizeRegex.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, errors.New(fmt.Sprintf("Invalid size %s, use for example 100MB", s))
+	}
+	val, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	switch m[2] {
+	case "k":
+		val *= 1024
+	case "m":
+		val *= 1024 * 1024
+	case "g":
+		val *= 1024 * 1024 * 1024
+	case "t":
+		val *= 1024 * 1024 * 1024 * 1024
+	}
+	return val, nil
+}
+
+// Split a trailing "| tee <path> [format csv] [rotate 100MB]" from the input, returns the input without it
+func splitTee(input string) (string, *OutFile, error) {
+	var m []int
+	var pipe int
+	for _, pipe = range unquotedIndexes(input, '|') {
+		if m = teeRegex.FindStringSubmatchIndex(input[pipe:]); m != nil {
+			break
+		}
+	}
+	if m == nil {
+		return input, nil, nil
+	}
+	rest := strings.TrimSpace(input[:pipe])
+	spec := input[pipe+m[2] : pipe+m[3]]
+
+	// Path, optionally quoted
+	o := &OutFile{}
+	if spec[0] == '\'' || spec[0] == '"' {
+		end := strings.IndexByte(spec[1:], spec[0])
+		if end == -1 {
+			return input, nil, errors.New("Unterminated file name of tee")
+		}
+		o.Path = spec[1 : end+1]
+		spec = spec[end+2:]
+	} else {
+		fields := strings.Fields(spec)
+		o.Path = fields[0]
+		spec = spec[len(fields[0]):]
+	}
+
+	// Options
+	opts := strings.Fields(spec)
+	for i := 0; i < len(opts); i += 2 {
+		if i+1 >= len(opts) {
+			return input, nil, errors.New(fmt.Sprintf("Missing value of tee option %s", opts[i]))
+		}
+		switch strings.ToLower(opts[i]) {
+		case "format":
+			o.Format = strings.ToLower(opts[i+1])
+		case "rotate":
+			size, err := parseSize(opts[i+1])
+			if err != nil {
+				return input, nil, err
+			}
+			o.Rotate = size
+		default:
+			return input, nil, errors.New(fmt.Sprintf("Unknown tee option %s, use format or rotate", opts[i]))
+		}
+	}
+	if err := o.Validate(); err != nil {
+		return input, nil, err
+	}
+	return rest, o, nil
+}
+
+// Close the file of a query and report where the results went
+func (c *Console) closeQueryResultWriter(fw *FileResultWriter) {
+	if fw == nil {
+		return
+	}
+	if err := fw.Close(); err != nil {
+		c.printError(fmt.Sprintf("Failed to write %s: %s", fw.out.outFile.Path, err))
+		return
+	}
+	// The console output of tee in non-interactive mode is the data itself
+	if fw.tee && !c.Interactive {
+		return
+	}
+	if fw.out.rotation > 0 {
+		fmt.Fprintf(c.Out, "Wrote %d rows to %s and %d rotated files\n", fw.rows, fw.out.outFile.Path, fw.out.rotation)
+	} else {
+		fmt.Fprintf(c.Out, "Wrote %d rows to %s\n", fw.rows, fw.out.outFile.Path)
+	}
+}
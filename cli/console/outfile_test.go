@@ -0,0 +1,152 @@
+package console
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitTee(t *testing.T) {
+	tests := []struct {
+		input  string
+		rest   string
+		path   string
+		format string
+		rotate int64
+	}{
+		{"cat a | grep x", "cat a | grep x", "", "", 0},
+		{"cat a | grep x | tee out.log", "cat a | grep x", "out.log", "", 0},
+		{"cat a | TEE out.csv format CSV rotate 10k;", "cat a", "out.csv", "csv", 10240},
+		{"cat a | tee 'my results.gz' rotate 1MB", "cat a", "my results.gz", "", 1048576},
+		{"cat a | grep 'x | tee y' | tee \"a|b.tsv\"", "cat a | grep 'x | tee y'", "a|b.tsv", "", 0},
+		{"cat a | grep \"x | tee y\"", "cat a | grep \"x | tee y\"", "", "", 0},
+		{"select * from a where 'a|b' | tee out.json", "select * from a where 'a|b'", "out.json", "", 0},
+	}
+	for _, test := range tests {
+		rest, o, err := splitTee(test.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.input, err)
+			continue
+		}
+		if rest != test.rest {
+			t.Errorf("%s: expected rest %q, got %q", test.input, test.rest, rest)
+		}
+		if len(test.path) == 0 {
+			if o != nil {
+				t.Errorf("%s: expected no tee, got %s", test.input, o.Path)
+			}
+			continue
+		}
+		if o == nil || o.Path != test.path || o.Format != test.format || o.Rotate != test.rotate {
+			t.Errorf("%s: expected %s format %q rotate %d, got %v", test.input, test.path, test.format, test.rotate, o)
+		}
+	}
+
+	for _, input := range []string{
+		"cat a | tee 'unterminated",
+		"cat a | tee out.log rotate",
+		"cat a | tee out.log rotate 10x",
+		"cat a | tee out.log format xml",
+		"cat a | tee out.log color red",
+	} {
+		if _, _, err := splitTee(input); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"1048576", 1048576},
+		{"10k", 10240},
+		{"10KB", 10240},
+		{"100 MB", 100 * 1024 * 1024},
+		{"2GiB", 2 * 1024 * 1024 * 1024},
+		{"1t", 1024 * 1024 * 1024 * 1024},
+		{"0", 0},
+	}
+	for _, test := range tests {
+		size, err := parseSize(test.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.input, err)
+		} else if size != test.expected {
+			t.Errorf("%s: expected %d, got %d", test.input, test.expected, size)
+		}
+	}
+	for _, input := range []string{"", "MB", "-1", "1.5MB", "10x", "99999999999999999999"} {
+		if _, err := parseSize(input); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
+
+func TestRotatedPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"out.csv", "out.csv.3"},
+		{"out.csv.gz", "out.csv.3.gz"},
+		{"/tmp/OUT.GZ", "/tmp/OUT.3.GZ"},
+		{"out", "out.3"},
+	}
+	for _, test := range tests {
+		o := &OutFile{Path: test.path}
+		if path := o.rotatedPath(3); path != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.path, test.expected, path)
+		}
+	}
+}
+
+func TestFileResultWriterRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Every file holds the header and at least one row
+	o := &OutFile{Path: filepath.Join(dir, "out.csv.gz"), Rotate: 10}
+	fw, err := newFileResultWriter(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.WriteHeader([]string{"line"})
+	for _, row := range []string{"first row", "second row"} {
+		if err := fw.WriteRow([]string{row}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Errorf("Expected a second close to be a no-op, got %s", err)
+	}
+	for path, expected := range map[string]string{
+		o.rotatedPath(1): "line\nfirst row\n",
+		o.Path:           "line\nsecond row\n",
+	} {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Errorf("%s: %s", path, err)
+			continue
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Errorf("%s: %s", path, err)
+			f.Close()
+			continue
+		}
+		data, _ := ioutil.ReadAll(gz)
+		f.Close()
+		if string(data) != expected {
+			t.Errorf("%s: expected %q, got %q", path, expected, string(data))
+		}
+	}
+}
@@ -0,0 +1,249 @@
+// Output formats of query results
+// - table: aligned columns with a header (default in interactive mode)
+// - raw: values separated by tabs without header (default with -e)
+// - json: one object per line
+// - csv, tsv: header followed by the rows
+// @author Robin Verlangen
+
+package console
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+const OUTPUT_FORMAT_TABLE string = "table"
+const OUTPUT_FORMAT_RAW string = "raw"
+const OUTPUT_FORMAT_JSON string = "json"
+const OUTPUT_FORMAT_CSV string = "csv"
+const OUTPUT_FORMAT_TSV string = "tsv"
+
+type ResultWriter interface {
+	WriteHeader(columns []string)
+
+	// Errors of the output (e.g. a failed rotation of a file), the query stops in that case
+	WriteRow(values []string) error
+
+	// Write buffered rows, called after every batch of results
+	Flush()
+}
+
+type RawResultWriter struct {
+	out io.Writer
+}
+
+type JsonResultWriter struct {
+	out     io.Writer
+	columns []string
+}
+
+type CsvResultWriter struct {
+	w *csv.Writer
+}
+
+type TsvResultWriter struct {
+	out io.Writer
+}
+
+// Widths only grow, rows of later batches are aligned with the header as far as possible
+type TableResultWriter struct {
+	out           io.Writer
+	columns       []string
+	widths        []int
+	rows          [][]string
+	headerWritten bool
+}
+
+func (w *RawResultWriter) WriteHeader(columns []string) {}
+
+func (w *RawResultWriter) WriteRow(values []string) error {
+	_, err := fmt.Fprintf(w.out, "%s\n", strings.Join(values, "\t"))
+	return err
+}
+
+func (w *RawResultWriter) Flush() {}
+
+func (w *JsonResultWriter) WriteHeader(columns []string) {
+	w.columns = columns
+}
+
+func (w *JsonResultWriter) WriteRow(values []string) error {
+	obj := make(map[string]string)
+	for i, val := range values {
+		if i < len(w.columns) {
+			obj[w.columns[i]] = val
+		}
+	}
+	// Keep <, > and & readable, the encoder ends every object with a newline
+	enc := json.NewEncoder(w.out)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(obj)
+}
+
+func (w *JsonResultWriter) Flush() {}
+
+func (w *CsvResultWriter) WriteHeader(columns []string) {
+	w.w.Write(columns)
+}
+
+func (w *CsvResultWriter) WriteRow(values []string) error {
+	return w.w.Write(values)
+}
+
+func (w *CsvResultWriter) Flush() {
+	w.w.Flush()
+}
+
+func (w *TsvResultWriter) WriteHeader(columns []string) {
+	w.WriteRow(columns)
+}
+
+func (w *TsvResultWriter) WriteRow(values []string) error {
+	escaped := make([]string, len(values))
+	for i, val := range values {
+		escaped[i] = escapeTsv(val)
+	}
+	_, err := fmt.Fprintf(w.out, "%s\n", strings.Join(escaped, "\t"))
+	return err
+}
+
+func (w *TsvResultWriter) Flush() {}
+
+func (w *TableResultWriter) WriteHeader(columns []string) {
+	w.columns = columns
+	w.widths = make([]int, len(columns))
+	w.grow(columns)
+}
+
+func (w *TableResultWriter) WriteRow(values []string) error {
+	w.rows = append(w.rows, values)
+	w.grow(values)
+	return nil
+}
+
+func (w *TableResultWriter) Flush() {
+	if len(w.rows) == 0 {
+		return
+	}
+	if !w.headerWritten {
+		w.writeLine(w.columns)
+		seps := make([]string, len(w.widths))
+		for i, width := range w.widths {
+			seps[i] = strings.Repeat("-", width)
+		}
+		w.writeLine(seps)
+		w.headerWritten = true
+	}
+	for _, row := range w.rows {
+		w.writeLine(row)
+	}
+	w.rows = nil
+}
+
+func (w *TableResultWriter) grow(values []string) {
+	for i, val := range values {
+		if i >= len(w.widths) {
+			w.widths = append(w.widths, 0)
+		}
+		if n := utf8.RuneCountInString(val); n > w.widths[i] {
+			w.widths[i] = n
+		}
+	}
+}
+
+func (w *TableResultWriter) writeLine(values []string) {
+	cells := make([]string, len(values))
+	for i, val := range values {
+		cells[i] = val
+		if i < len(values)-1 {
+			cells[i] += strings.Repeat(" ", w.widths[i]-utf8.RuneCountInString(val))
+		}
+	}
+	fmt.Fprintf(w.out, "%s\n", strings.TrimRight(strings.Join(cells, " | "), " "))
+}
+
+// Tabs, newlines and backslashes are escaped, this keeps one row per line
+func escapeTsv(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "\t", "\\t", -1)
+	s = strings.Replace(s, "\n", "\\n", -1)
+	return strings.Replace(s, "\r", "\\r", -1)
+}
+
+func ValidateOutputFormat(format string) error {
+	switch format {
+	case OUTPUT_FORMAT_TABLE, OUTPUT_FORMAT_RAW, OUTPUT_FORMAT_JSON, OUTPUT_FORMAT_CSV, OUTPUT_FORMAT_TSV:
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Unsupported format %s, use %s, %s, %s, %s or %s", format, OUTPUT_FORMAT_TABLE, OUTPUT_FORMAT_RAW, OUTPUT_FORMAT_JSON, OUTPUT_FORMAT_CSV, OUTPUT_FORMAT_TSV))
+}
+
+// Format of the console (e.g. a flag), the session (set format) or the default of the mode
+func (c *Console) getOutputFormat() string {
+	if len(c.OutputFormat) > 0 {
+		return c.OutputFormat
+	}
+	if len(c.Session["output_format"]) > 0 {
+		return c.Session["output_format"]
+	}
+	if !c.Interactive {
+		return OUTPUT_FORMAT_RAW
+	}
+	return OUTPUT_FORMAT_TABLE
+}
+
+func newResultWriter(format string, out io.Writer) ResultWriter {
+	switch format {
+	case OUTPUT_FORMAT_TABLE:
+		return &TableResultWriter{out: out}
+	case OUTPUT_FORMAT_JSON:
+		return &JsonResultWriter{out: out}
+	case OUTPUT_FORMAT_CSV:
+		return &CsvResultWriter{w: csv.NewWriter(out)}
+	case OUTPUT_FORMAT_TSV:
+		return &TsvResultWriter{out: out}
+	}
+	return &RawResultWriter{out: out}
+}
+
+// Result writer on the output of the console in the current format
+func (c *Console) newConsoleResultWriter() ResultWriter {
+	return newResultWriter(c.getOutputFormat(), c.Out)
+}
+
+// Write the TSV of a search (header line followed by the rows, every value ends with a tab)
+func (c *Console) writeSearchResult(data string, into *OutFile, tee *OutFile) {
+	if into == nil && tee == nil && c.getOutputFormat() == OUTPUT_FORMAT_RAW {
+		fmt.Fprint(c.Out, data)
+		return
+	}
+	w, fw, err := c.newQueryResultWriter(into, tee)
+	if err != nil {
+		c.printError(fmt.Sprintf("%s", err))
+		return
+	}
+	defer c.closeQueryResultWriter(fw)
+	header := true
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		values := strings.Split(strings.TrimSuffix(line, "\t"), "\t")
+		if header {
+			w.WriteHeader(values)
+			header = false
+			continue
+		}
+		if err := w.WriteRow(values); err != nil {
+			c.printError(fmt.Sprintf("%s", err))
+			break
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,70 @@
+package console
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestResultWriters(t *testing.T) {
+	header := []string{"host", "message"}
+	rows := [][]string{
+		{"web-1", `say "hi", bye`},
+		{"web-2", "tab\there\nnew line \\ <b>&"},
+		{"web-3", ""},
+	}
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{OUTPUT_FORMAT_CSV, "host,message\nweb-1,\"say \"\"hi\"\", bye\"\nweb-2,\"tab\there\nnew line \\ <b>&\"\nweb-3,\n"},
+		{OUTPUT_FORMAT_TSV, "host\tmessage\nweb-1\tsay \"hi\", bye\nweb-2\ttab\\there\\nnew line \\\\ <b>&\nweb-3\t\n"},
+		{OUTPUT_FORMAT_JSON, "{\"host\":\"web-1\",\"message\":\"say \\\"hi\\\", bye\"}\n{\"host\":\"web-2\",\"message\":\"tab\\there\\nnew line \\\\ <b>&\"}\n{\"host\":\"web-3\",\"message\":\"\"}\n"},
+		{OUTPUT_FORMAT_RAW, "web-1\tsay \"hi\", bye\nweb-2\ttab\there\nnew line \\ <b>&\nweb-3\t\n"},
+		{OUTPUT_FORMAT_TABLE, "host  | message\n----- | ------------------------\nweb-1 | say \"hi\", bye\nweb-2 | tab\there\nnew line \\ <b>&\nweb-3 |\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		w := newResultWriter(test.format, &buf)
+		w.WriteHeader(header)
+		for _, row := range rows {
+			if err := w.WriteRow(row); err != nil {
+				t.Errorf("%s: unexpected error %s", test.format, err)
+			}
+		}
+		w.Flush()
+		if buf.String() != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.format, test.expected, buf.String())
+		}
+	}
+}
+
+func TestTableResultWriterBatches(t *testing.T) {
+	var buf bytes.Buffer
+	w := newResultWriter(OUTPUT_FORMAT_TABLE, &buf)
+	w.WriteHeader([]string{"a", "b"})
+	w.Flush()
+	if buf.Len() != 0 {
+		t.Errorf("Expected no header without rows, got %q", buf.String())
+	}
+	w.WriteRow([]string{"1", "x"})
+	w.Flush()
+	w.WriteRow([]string{"long", "y"})
+	w.Flush()
+	expected := "a | b\n- | -\n1 | x\nlong | y\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestValidateOutputFormat(t *testing.T) {
+	for _, format := range []string{"table", "raw", "json", "csv", "tsv"} {
+		if err := ValidateOutputFormat(format); err != nil {
+			t.Errorf("%s: unexpected error %s", format, err)
+		}
+	}
+	for _, format := range []string{"", "xml", "CSV"} {
+		if err := ValidateOutputFormat(format); err == nil {
+			t.Errorf("%s: expected an error", format)
+		}
+	}
+}
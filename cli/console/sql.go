@@ -0,0 +1,835 @@
+// Lexer and parser of the SQL-like statements of the CLI
+// Supported subset:
+//   SELECT <* | column [, column ...]> FROM <filter>
+//   [WHERE <condition>] [SINCE <time>] [UNTIL <time>] [WINDOW <duration>]
+//   [ORDER BY <column> [ASC | DESC] [, ...]] [LIMIT <n>]
+//   [INTO OUTFILE '<path>' [FORMAT <format>] [ROTATE <size>]]
+// Conditions:
+//   '<regex>'                                 raw line matches the regex (short for _raw REGEXP '<regex>')
+//   <column> <operator> <value>               operators =, !=, <>, <, <=, >, >= (numeric if both sides are numbers)
+//   <column> [NOT] LIKE '<pattern>'           % matches any sequence, _ a single character
+//   <column> [NOT] REGEXP '<regex>'
+//   NOT <condition>, <condition> AND <condition>, <condition> OR <condition>, (<condition>)
+// The supervisor evaluates the same conditions on filter results, see ParseSqlCondition
+// The time range (search only) selects the daily tables, see timerange.go
+// Keywords are case-insensitive, identifiers and quoted strings keep their case
+// @author Robin Verlangen
+
+package console
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	SQL_EOF = iota
+	SQL_IDENT
+	SQL_STRING
+	SQL_NUMBER
+	SQL_OPERATOR
+	SQL_COMMA
+	SQL_LPAREN
+	SQL_RPAREN
+	SQL_STAR
+	SQL_KEYWORD
+)
+
+var sqlKeywords map[string]bool = map[string]bool{
+	"SELECT":  true,
+	"FROM":    true,
+	"WHERE":   true,
+	"AND":     true,
+	"OR":      true,
+	"NOT":     true,
+	"LIKE":    true,
+	"REGEXP":  true,
+	"ORDER":   true,
+	"BY":      true,
+	"ASC":     true,
+	"DESC":    true,
+	"LIMIT":   true,
+	"INTO":    true,
+	"OUTFILE": true,
+	"SINCE":   true,
+	"UNTIL":   true,
+	"WINDOW":  true,
+}
+
+type SqlToken struct {
+	Type  int
+	Value string
+	Pos   int // Byte offset in the input
+}
+
+func (t *SqlToken) String() string {
+	switch t.Type {
+	case SQL_EOF:
+		return "end of input"
+	case SQL_STRING:
+		return fmt.Sprintf("string '%s'", t.Value)
+	}
+	return fmt.Sprintf("'%s'", t.Value)
+}
+
+type SqlSyntaxError struct {
+	Input string
+	Pos   int
+	Msg   string
+}
+
+func (e *SqlSyntaxError) Error() string {
+	return fmt.Sprintf("Syntax error at position %d: %s", e.Pos+1, e.Msg)
+}
+
+// Error with the input and a marker below the position
+func (e *SqlSyntaxError) Pretty() string {
+	return fmt.Sprintf("%s\n%s\n%s^", e.Error(), e.Input, strings.Repeat(" ", e.Pos))
+}
+
+// Statement
+type SelectStatement struct {
+	Columns []string // Empty selects all columns
+	From    string
+	Where   SqlExpr    // Nil matches everything
+	Range   *TimeRange // Nil is today
+	OrderBy []*SqlOrderBy
+	Limit   int64    // -1 is unlimited, LIMIT 0 is rejected
+	Into    *OutFile // Nil writes to the console
+}
+
+type SqlOrderBy struct {
+	Column string
+	Desc   bool
+}
+
+// Conditions
+type SqlExpr interface {
+	Eval(row map[string]string) bool
+	String() string
+}
+
+type SqlBinaryExpr struct {
+	Op    string // AND, OR
+	Left  SqlExpr
+	Right SqlExpr
+}
+
+type SqlNotExpr struct {
+	Expr SqlExpr
+}
+
+type SqlMatchExpr struct {
+	Pattern string
+	regex   *regexp.Regexp
+}
+
+type SqlComparisonExpr struct {
+	Column string
+	Op     string // =, !=, <, <=, >, >=, LIKE, NOT LIKE, REGEXP, NOT REGEXP
+	Value  string
+	regex  *regexp.Regexp
+}
+
+func (e *SqlBinaryExpr) Eval(row map[string]string) bool {
+	if e.Op == "AND" {
+		return e.Left.Eval(row) && e.Right.Eval(row)
+	}
+	return e.Left.Eval(row) || e.Right.Eval(row)
+}
+
+func (e *SqlBinaryExpr) String() string {
+	return fmt.Sprintf("(%s %s %s)", e.Left.String(), e.Op, e.Right.String())
+}
+
+func (e *SqlNotExpr) Eval(row map[string]string) bool {
+	return !e.Expr.Eval(row)
+}
+
+func (e *SqlNotExpr) String() string {
+	return fmt.Sprintf("NOT %s", e.Expr.String())
+}
+
+func (e *SqlMatchExpr) Eval(row map[string]string) bool {
+	return e.regex.MatchString(row["_raw"])
+}
+
+func (e *SqlMatchExpr) String() string {
+	return quoteSqlString(e.Pattern)
+}
+
+func (e *SqlComparisonExpr) Eval(row map[string]string) bool {
+	val := row[e.Column]
+	switch e.Op {
+	case "LIKE", "REGEXP":
+		return e.regex.MatchString(val)
+	case "NOT LIKE", "NOT REGEXP":
+		return !e.regex.MatchString(val)
+	}
+
+	// Numeric in case both sides are numbers
+	var cmp int
+	a, aE := strconv.ParseFloat(val, 64)
+	b, bE := strconv.ParseFloat(e.Value, 64)
+	if aE == nil && bE == nil {
+		if a < b {
+			cmp = -1
+		} else if a > b {
+			cmp = 1
+		}
+	} else {
+		cmp = strings.Compare(val, e.Value)
+	}
+	switch e.Op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func (e *SqlComparisonExpr) String() string {
+	return fmt.Sprintf("%s %s %s", e.Column, e.Op, quoteSqlString(e.Value))
+}
+
+// Canonical SQL of the statement
+func (s *SelectStatement) String() string {
+	cols := "*"
+	if len(s.Columns) > 0 {
+		cols = strings.Join(s.Columns, ", ")
+	}
+	var where string
+	if s.Where != nil {
+		where = s.Where.String()
+	}
+	var rng string
+	if s.Range != nil {
+		rng = s.Range.String()
+	}
+	if s.Into != nil {
+		return fmt.Sprintf("%s INTO OUTFILE %s", s.render(cols, s.From, where, rng), s.Into.String())
+	}
+	return s.render(cols, s.From, where, rng)
+}
+
+func (s *SelectStatement) render(cols string, from string, where string, rng string) string {
+	var buf []string
+	buf = append(buf, fmt.Sprintf("SELECT %s FROM %s", cols, from))
+	if len(where) > 0 {
+		buf = append(buf, fmt.Sprintf("WHERE %s", where))
+	}
+	if len(rng) > 0 {
+		buf = append(buf, rng)
+	}
+	if len(s.OrderBy) > 0 {
+		fields := make([]string, 0)
+		for _, o := range s.OrderBy {
+			if o.Desc {
+				fields = append(fields, fmt.Sprintf("%s DESC", o.Column))
+			} else {
+				fields = append(fields, fmt.Sprintf("%s ASC", o.Column))
+			}
+		}
+		buf = append(buf, fmt.Sprintf("ORDER BY %s", strings.Join(fields, ", ")))
+	}
+	if s.Limit != -1 {
+		buf = append(buf, fmt.Sprintf("LIMIT %d", s.Limit))
+	}
+	return strings.Join(buf, " ")
+}
+
+// Regex for a (temporary) storm filter, only the top level regex of the raw line can be used
+func (s *SelectStatement) FilterRegex() string {
+	switch e := s.Where.(type) {
+	case *SqlMatchExpr:
+		return e.Pattern
+	case *SqlComparisonExpr:
+		if e.Column == "_raw" && e.Op == "REGEXP" {
+			return e.Value
+		}
+	}
+	return ".*"
+}
+
+// Fields other than _raw used by the statement (columns, conditions and ordering)
+func (s *SelectStatement) Fields() []string {
+	list := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(field string) {
+		if field != "_raw" && !seen[field] {
+			seen[field] = true
+			list = append(list, field)
+		}
+	}
+	for _, col := range s.Columns {
+		add(col)
+	}
+	var walk func(expr SqlExpr)
+	walk = func(expr SqlExpr) {
+		switch e := expr.(type) {
+		case *SqlBinaryExpr:
+			walk(e.Left)
+			walk(e.Right)
+		case *SqlNotExpr:
+			walk(e.Expr)
+		case *SqlComparisonExpr:
+			add(e.Column)
+		}
+	}
+	walk(s.Where)
+	for _, o := range s.OrderBy {
+		add(o.Column)
+	}
+	return list
+}
+
+// Names of the selected columns
+func (s *SelectStatement) Header() []string {
+	if len(s.Columns) == 0 {
+		return []string{"_raw"}
+	}
+	return s.Columns
+}
+
+// Selected columns of the row
+func (s *SelectStatement) Project(row map[string]string) []string {
+	if len(s.Columns) == 0 {
+		return []string{row["_raw"]}
+	}
+	vals := make([]string, 0)
+	for _, col := range s.Columns {
+		vals = append(vals, row[col])
+	}
+	return vals
+}
+
+// Order rows according to the ORDER BY clause
+func (s *SelectStatement) Sort(rows []map[string]string) {
+	if len(s.OrderBy) == 0 {
+		return
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, o := range s.OrderBy {
+			a := rows[i][o.Column]
+			b := rows[j][o.Column]
+			if a == b {
+				continue
+			}
+			var less bool
+			af, aE := strconv.ParseFloat(a, 64)
+			bf, bE := strconv.ParseFloat(b, 64)
+			if aE == nil && bE == nil {
+				less = af < bf
+			} else {
+				less = a < b
+			}
+			if o.Desc {
+				return !less
+			}
+			return less
+		}
+		return false
+	})
+}
+
+// BigQuery SQL of the statement on the table
+func (s *SelectStatement) BigQuery(table string) string {
+	cols := "_raw"
+	if len(s.Columns) > 0 {
+		cols = strings.Join(s.Columns, ", ")
+	}
+	var where string
+	if s.Where != nil {
+		where = bigQueryExpr(s.Where)
+	}
+	if s.Range != nil && len(s.Range.BigQuery()) > 0 {
+		if len(where) > 0 {
+			where = fmt.Sprintf("%s AND %s", where, s.Range.BigQuery())
+		} else {
+			where = s.Range.BigQuery()
+		}
+	}
+	return s.render(cols, table, where, "")
+}
+
+func bigQueryExpr(expr SqlExpr) string {
+	switch e := expr.(type) {
+	case *SqlBinaryExpr:
+		return fmt.Sprintf("(%s %s %s)", bigQueryExpr(e.Left), e.Op, bigQueryExpr(e.Right))
+	case *SqlNotExpr:
+		return fmt.Sprintf("NOT %s", bigQueryExpr(e.Expr))
+	case *SqlMatchExpr:
+		return fmt.Sprintf("REGEXP_MATCH(_raw, %s)", quoteSqlString(e.Pattern))
+	case *SqlComparisonExpr:
+		switch e.Op {
+		case "REGEXP":
+			return fmt.Sprintf("REGEXP_MATCH(%s, %s)", e.Column, quoteSqlString(e.Value))
+		case "NOT REGEXP":
+			return fmt.Sprintf("NOT REGEXP_MATCH(%s, %s)", e.Column, quoteSqlString(e.Value))
+		}
+		if _, err := strconv.ParseFloat(e.Value, 64); err == nil && e.Op != "LIKE" && e.Op != "NOT LIKE" {
+			return fmt.Sprintf("%s %s %s", e.Column, e.Op, e.Value)
+		}
+		return e.String()
+	}
+	return ""
+}
+
+func quoteSqlString(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "'", "\\'", -1)
+	return fmt.Sprintf("'%s'", s)
+}
+
+// LIKE pattern to regex, % matches any sequence and _ a single character
+func likeToRegex(pattern string) string {
+	var buf []string
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			buf = append(buf, ".*")
+		case '_':
+			buf = append(buf, ".")
+		default:
+			buf = append(buf, regexp.QuoteMeta(string(r)))
+		}
+	}
+	return fmt.Sprintf("(?s)^%s$", strings.Join(buf, ""))
+}
+
+// Lexer
+func isSqlIdentChar(r byte) bool {
+	return r < 128 && (unicode.IsLetter(rune(r)) || unicode.IsDigit(rune(r)) || strings.IndexByte("_:.-/@$", r) != -1)
+}
+
+func lexSql(input string) ([]*SqlToken, error) {
+	tokens := make([]*SqlToken, 0)
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == ';' && strings.TrimSpace(strings.Trim(input[i:], ";")) == "":
+			// Trailing semicolons
+			i = len(input)
+		case c == ',':
+			tokens = append(tokens, &SqlToken{Type: SQL_COMMA, Value: ",", Pos: i})
+			i++
+		case c == '(':
+			tokens = append(tokens, &SqlToken{Type: SQL_LPAREN, Value: "(", Pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, &SqlToken{Type: SQL_RPAREN, Value: ")", Pos: i})
+			i++
+		case c == '*':
+			tokens = append(tokens, &SqlToken{Type: SQL_STAR, Value: "*", Pos: i})
+			i++
+		case c == '=' || c == '<' || c == '>' || c == '!':
+			op := string(c)
+			if i+1 < len(input) && (input[i+1] == '=' || (c == '<' && input[i+1] == '>')) {
+				op = input[i : i+2]
+			}
+			if op == "!" {
+				return nil, &SqlSyntaxError{Input: input, Pos: i, Msg: "unexpected '!', did you mean '!='"}
+			}
+			if op == "<>" {
+				tokens = append(tokens, &SqlToken{Type: SQL_OPERATOR, Value: "!=", Pos: i})
+			} else {
+				tokens = append(tokens, &SqlToken{Type: SQL_OPERATOR, Value: op, Pos: i})
+			}
+			i += len(op)
+		case c == '\'' || c == '"' || c == '`':
+			// Quoted string (backslash escapes the next character, a doubled quote is a single quote), backticks quote identifiers
+			start := i
+			var buf []byte
+			i++
+			closed := false
+			for i < len(input) {
+				if input[i] == '\\' && i+1 < len(input) {
+					if input[i+1] == c || input[i+1] == '\\' {
+						buf = append(buf, input[i+1])
+					} else {
+						// Keep regex escapes such as \d
+						buf = append(buf, input[i], input[i+1])
+					}
+					i += 2
+					continue
+				}
+				if input[i] == c {
+					if i+1 < len(input) && input[i+1] == c {
+						buf = append(buf, c)
+						i += 2
+						continue
+					}
+					closed = true
+					i++
+					break
+				}
+				buf = append(buf, input[i])
+				i++
+			}
+			if !closed {
+				return nil, &SqlSyntaxError{Input: input, Pos: start, Msg: "unterminated quoted string"}
+			}
+			if c == '`' {
+				tokens = append(tokens, &SqlToken{Type: SQL_IDENT, Value: string(buf), Pos: start})
+			} else {
+				tokens = append(tokens, &SqlToken{Type: SQL_STRING, Value: string(buf), Pos: start})
+			}
+		case isSqlIdentChar(c):
+			start := i
+			for i < len(input) && isSqlIdentChar(input[i]) {
+				i++
+			}
+			word := input[start:i]
+			if sqlKeywords[strings.ToUpper(word)] {
+				tokens = append(tokens, &SqlToken{Type: SQL_KEYWORD, Value: strings.ToUpper(word), Pos: start})
+			} else if _, err := strconv.ParseFloat(word, 64); err == nil {
+				tokens = append(tokens, &SqlToken{Type: SQL_NUMBER, Value: word, Pos: start})
+			} else {
+				tokens = append(tokens, &SqlToken{Type: SQL_IDENT, Value: word, Pos: start})
+			}
+		default:
+			return nil, &SqlSyntaxError{Input: input, Pos: i, Msg: fmt.Sprintf("unexpected character '%c'", c)}
+		}
+	}
+	tokens = append(tokens, &SqlToken{Type: SQL_EOF, Pos: len(input)})
+	return tokens, nil
+}
+
+// Parser
+type SqlParser struct {
+	input  string
+	tokens []*SqlToken
+	pos    int
+}
+
+func (p *SqlParser) peek() *SqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *SqlParser) next() *SqlToken {
+	tok := p.tokens[p.pos]
+	if tok.Type != SQL_EOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *SqlParser) isKeyword(keyword string) bool {
+	tok := p.peek()
+	return tok.Type == SQL_KEYWORD && tok.Value == keyword
+}
+
+func (p *SqlParser) errorAt(tok *SqlToken, format string, args ...interface{}) error {
+	return &SqlSyntaxError{Input: p.input, Pos: tok.Pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *SqlParser) expectKeyword(keyword string) error {
+	if !p.isKeyword(keyword) {
+		return p.errorAt(p.peek(), "expected %s, found %s", keyword, p.peek())
+	}
+	p.next()
+	return nil
+}
+
+func (p *SqlParser) parseSelect() (*SelectStatement, error) {
+	stmt := &SelectStatement{
+		Columns: make([]string, 0),
+		Limit:   -1,
+	}
+
+	// Columns
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	if p.peek().Type == SQL_STAR {
+		p.next()
+	} else {
+		for {
+			tok := p.next()
+			if tok.Type != SQL_IDENT {
+				return nil, p.errorAt(tok, "expected column name or *, found %s", tok)
+			}
+			stmt.Columns = append(stmt.Columns, tok.Value)
+			if p.peek().Type != SQL_COMMA {
+				break
+			}
+			p.next()
+		}
+	}
+
+	// From
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	tok := p.next()
+	if tok.Type != SQL_IDENT && tok.Type != SQL_STRING && tok.Type != SQL_NUMBER {
+		return nil, p.errorAt(tok, "expected filter name, found %s", tok)
+	}
+	stmt.From = tok.Value
+
+	// Where
+	if p.isKeyword("WHERE") {
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Where = expr
+	}
+
+	// Time range
+	for p.isKeyword("SINCE") || p.isKeyword("UNTIL") || p.isKeyword("WINDOW") {
+		opt := p.next()
+		val := p.next()
+		if val.Type != SQL_IDENT && val.Type != SQL_NUMBER && val.Type != SQL_STRING {
+			return nil, p.errorAt(val, "expected value of %s, found %s", opt.Value, val)
+		}
+		if stmt.Range == nil {
+			stmt.Range = &TimeRange{}
+		}
+		if err := stmt.Range.Set(opt.Value, val.Value); err != nil {
+			return nil, p.errorAt(val, "%s", err)
+		}
+	}
+
+	// Order by
+	if p.isKeyword("ORDER") {
+		p.next()
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			tok := p.next()
+			if tok.Type != SQL_IDENT {
+				return nil, p.errorAt(tok, "expected column name, found %s", tok)
+			}
+			o := &SqlOrderBy{Column: tok.Value}
+			if p.isKeyword("DESC") {
+				p.next()
+				o.Desc = true
+			} else if p.isKeyword("ASC") {
+				p.next()
+			}
+			stmt.OrderBy = append(stmt.OrderBy, o)
+			if p.peek().Type != SQL_COMMA {
+				break
+			}
+			p.next()
+		}
+	}
+
+	// Limit
+	if p.isKeyword("LIMIT") {
+		p.next()
+		tok := p.next()
+		limit, err := strconv.ParseInt(tok.Value, 10, 64)
+		if tok.Type != SQL_NUMBER || err != nil || limit < 1 {
+			return nil, p.errorAt(tok, "expected positive number, found %s", tok)
+		}
+		stmt.Limit = limit
+	}
+
+	// Into outfile
+	if p.isKeyword("INTO") {
+		p.next()
+		if err := p.expectKeyword("OUTFILE"); err != nil {
+			return nil, err
+		}
+		tok := p.next()
+		if tok.Type != SQL_STRING {
+			return nil, p.errorAt(tok, "expected quoted file name, found %s", tok)
+		}
+		stmt.Into = &OutFile{Path: tok.Value}
+		for p.peek().Type == SQL_IDENT {
+			opt := p.next()
+			val := p.next()
+			if val.Type != SQL_IDENT && val.Type != SQL_NUMBER && val.Type != SQL_STRING {
+				return nil, p.errorAt(val, "expected value of %s, found %s", opt.Value, val)
+			}
+			switch strings.ToUpper(opt.Value) {
+			case "FORMAT":
+				stmt.Into.Format = strings.ToLower(val.Value)
+				if err := ValidateOutputFormat(stmt.Into.Format); err != nil {
+					return nil, p.errorAt(val, "%s", err)
+				}
+			case "ROTATE":
+				size, err := parseSize(val.Value)
+				if err != nil {
+					return nil, p.errorAt(val, "%s", err)
+				}
+				stmt.Into.Rotate = size
+			default:
+				return nil, p.errorAt(opt, "expected FORMAT or ROTATE, found %s", opt)
+			}
+		}
+	}
+
+	// Done
+	if tok := p.peek(); tok.Type != SQL_EOF {
+		return nil, p.errorAt(tok, "unexpected %s", tok)
+	}
+	return stmt, nil
+}
+
+func (p *SqlParser) parseOr() (SqlExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &SqlBinaryExpr{Op: "OR", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *SqlParser) parseAnd() (SqlExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &SqlBinaryExpr{Op: "AND", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *SqlParser) parseNot() (SqlExpr, error) {
+	if p.isKeyword("NOT") {
+		p.next()
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &SqlNotExpr{Expr: expr}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *SqlParser) parsePrimary() (SqlExpr, error) {
+	tok := p.next()
+	switch tok.Type {
+	case SQL_LPAREN:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.Type != SQL_RPAREN {
+			return nil, p.errorAt(closing, "expected ')', found %s", closing)
+		}
+		return expr, nil
+	case SQL_STRING:
+		regex, err := regexp.Compile(tok.Value)
+		if err != nil {
+			return nil, p.errorAt(tok, "invalid regex: %s", err)
+		}
+		return &SqlMatchExpr{Pattern: tok.Value, regex: regex}, nil
+	case SQL_IDENT:
+		expr := &SqlComparisonExpr{Column: tok.Value}
+		opTok := p.next()
+		not := false
+		if opTok.Type == SQL_KEYWORD && opTok.Value == "NOT" {
+			not = true
+			opTok = p.next()
+		}
+		if opTok.Type == SQL_KEYWORD && (opTok.Value == "LIKE" || opTok.Value == "REGEXP") {
+			expr.Op = opTok.Value
+			if not {
+				expr.Op = fmt.Sprintf("NOT %s", opTok.Value)
+			}
+			valTok := p.next()
+			if valTok.Type != SQL_STRING {
+				return nil, p.errorAt(valTok, "expected quoted pattern after %s, found %s", opTok.Value, valTok)
+			}
+			expr.Value = valTok.Value
+			pattern := valTok.Value
+			if opTok.Value == "LIKE" {
+				pattern = likeToRegex(valTok.Value)
+			}
+			regex, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, p.errorAt(valTok, "invalid regex: %s", err)
+			}
+			expr.regex = regex
+			return expr, nil
+		}
+		if not || opTok.Type != SQL_OPERATOR {
+			return nil, p.errorAt(opTok, "expected comparison operator, LIKE or REGEXP after %s, found %s", tok.Value, opTok)
+		}
+		expr.Op = opTok.Value
+		valTok := p.next()
+		if valTok.Type != SQL_STRING && valTok.Type != SQL_NUMBER && valTok.Type != SQL_IDENT {
+			return nil, p.errorAt(valTok, "expected value, found %s", valTok)
+		}
+		expr.Value = valTok.Value
+		return expr, nil
+	}
+	return nil, p.errorAt(tok, "expected condition, found %s", tok)
+}
+
+// Parse a select statement
+func ParseSql(input string) (*SelectStatement, error) {
+	tokens, err := lexSql(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &SqlParser{
+		input:  input,
+		tokens: tokens,
+	}
+	return p.parseSelect()
+}
+
+// Parse a condition (the where clause of a select statement), the supervisor evaluates these on filter results
+func ParseSqlCondition(input string) (SqlExpr, error) {
+	tokens, err := lexSql(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &SqlParser{
+		input:  input,
+		tokens: tokens,
+	}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.Type != SQL_EOF {
+		return nil, p.errorAt(tok, "unexpected %s", tok)
+	}
+	return expr, nil
+}
+
+// Print errors of the parser with a marker below the position
+func (c *Console) printSqlError(err error) {
+	if se, ok := err.(*SqlSyntaxError); ok {
+		fmt.Fprintf(c.Out, "%s\n", se.Pretty())
+		return
+	}
+	fmt.Fprintf(c.Out, "%s\n", err)
+}
@@ -0,0 +1,181 @@
+package console
+
+import (
+	"testing"
+)
+
+func TestParseSql(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"select * from a", "SELECT * FROM a"},
+		{"SELECT host, status FROM `my filter`;", "SELECT host, status FROM my filter"},
+		{"select * from a where 'err(or)?'", "SELECT * FROM a WHERE 'err(or)?'"},
+		{"select * from a where status >= 500 and host <> 'web-1'", "SELECT * FROM a WHERE (status >= '500' AND host != 'web-1')"},
+		{"select * from a where path not like '/api/%' or agent regexp 'bot\\d+'", "SELECT * FROM a WHERE (path NOT LIKE '/api/%' OR agent REGEXP 'bot\\\\d+')"},
+		{"select * from a order by status desc, host limit 10", "SELECT * FROM a ORDER BY status DESC, host ASC LIMIT 10"},
+		{"select * from a into outfile '/tmp/o.csv.gz' format CSV rotate 1m", "SELECT * FROM a INTO OUTFILE '/tmp/o.csv.gz' FORMAT csv ROTATE 1048576"},
+		{"select * from a where msg = 'it''s' and msg != \"say \\\"hi\\\"\"", "SELECT * FROM a WHERE (msg = 'it\\'s' AND msg != 'say \"hi\"')"},
+	}
+	for _, test := range tests {
+		stmt, err := ParseSql(test.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.input, err)
+			continue
+		}
+		if stmt.String() != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.input, test.expected, stmt.String())
+		}
+	}
+}
+
+func TestParseSqlErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   int
+	}{
+		{"select from a", 7},
+		{"select * a", 9},
+		{"select * from a where", 21},
+		{"select * from a where status ! 5", 29},
+		{"select * from a where 'unterminated", 22},
+		{"select * from a where (x = 1", 28},
+		{"select * from a where x like 5", 29},
+		{"select * from a where '('", 22},
+		{"select * from a limit 0", 22},
+		{"select * from a limit -1", 22},
+		{"select * from a into outfile x", 29},
+		{"select * from a into outfile 'x' format xml", 40},
+		{"select * from a extra", 16},
+		{"select * from a where x = 1 # comment", 28},
+	}
+	for _, test := range tests {
+		_, err := ParseSql(test.input)
+		se, ok := err.(*SqlSyntaxError)
+		if !ok {
+			t.Errorf("%s: expected a syntax error, got %v", test.input, err)
+			continue
+		}
+		if se.Pos != test.pos {
+			t.Errorf("%s: expected position %d, got %d (%s)", test.input, test.pos, se.Pos, se.Msg)
+		}
+	}
+}
+
+func TestParseSqlConditionPrecedence(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a = 1 or b = 2 and c = 3", "(a = '1' OR (b = '2' AND c = '3'))"},
+		{"a = 1 and b = 2 or c = 3", "((a = '1' AND b = '2') OR c = '3')"},
+		{"not a = 1 and b = 2", "(NOT a = '1' AND b = '2')"},
+		{"not (a = 1 or b = 2)", "NOT (a = '1' OR b = '2')"},
+		{"not not 'x'", "NOT NOT 'x'"},
+		{"(a = 1 or b = 2) and c = 3", "((a = '1' OR b = '2') AND c = '3')"},
+		{"a = 1 or b = 2 or c = 3", "((a = '1' OR b = '2') OR c = '3')"},
+	}
+	for _, test := range tests {
+		expr, err := ParseSqlCondition(test.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.input, err)
+			continue
+		}
+		if expr.String() != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.input, test.expected, expr.String())
+		}
+	}
+
+	// Evaluation follows the same precedence
+	expr, _ := ParseSqlCondition("a = 1 or b = 2 and c = 3")
+	if !expr.Eval(map[string]string{"a": "1", "b": "0", "c": "0"}) {
+		t.Errorf("Expected a = 1 to match without b and c")
+	}
+	if expr.Eval(map[string]string{"a": "0", "b": "2", "c": "0"}) {
+		t.Errorf("Expected b = 2 to require c = 3")
+	}
+}
+
+func TestLikeToRegex(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected string
+	}{
+		{"abc", "(?s)^abc$"},
+		{"%.log", "(?s)^.*\\.log$"},
+		{"a_c%", "(?s)^a.c.*$"},
+		{"(1+1)*", "(?s)^\\(1\\+1\\)\\*$"},
+	}
+	for _, test := range tests {
+		if regex := likeToRegex(test.pattern); regex != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.pattern, test.expected, regex)
+		}
+	}
+
+	expr, err := ParseSqlCondition("path like '/api/_/%.json'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, match := range map[string]bool{
+		"/api/v/users.json":  true,
+		"/api/v/a\nb.json":   true,
+		"/api/v2/users.json": false,
+		"/api/v/usersxjson":  false,
+		"x/api/v/users.json": false,
+	} {
+		if expr.Eval(map[string]string{"path": path}) != match {
+			t.Errorf("%q: expected match %v", path, match)
+		}
+	}
+}
+
+func TestQuoteSqlString(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"plain", "'plain'"},
+		{"it's", "'it\\'s'"},
+		{"c:\\dir", "'c:\\\\dir'"},
+		{"\\'", "'\\\\\\''"},
+	}
+	for _, test := range tests {
+		quoted := quoteSqlString(test.input)
+		if quoted != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.input, test.expected, quoted)
+		}
+
+		// The lexer reads the quoted string back
+		expr, err := ParseSqlCondition(quoted)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", quoted, err)
+		} else if expr.(*SqlMatchExpr).Pattern != test.input {
+			t.Errorf("%s: expected pattern %s, got %s", quoted, test.input, expr.(*SqlMatchExpr).Pattern)
+		}
+	}
+}
+
+func TestSplitCommands(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"a; b", []string{"a", " b"}},
+		{"select * from a where 'x;y'; help", []string{"select * from a where 'x;y'", " help"}},
+		{"grep \"a\\\";b\" f; help", []string{"grep \"a\\\";b\" f", " help"}},
+		{"select 'it''s;' from a", []string{"select 'it''s;' from a"}},
+	}
+	for _, test := range tests {
+		cmds := SplitCommands(test.input)
+		if len(cmds) != len(test.expected) {
+			t.Errorf("%s: expected %q, got %q", test.input, test.expected, cmds)
+			continue
+		}
+		for i := range cmds {
+			if cmds[i] != test.expected[i] {
+				t.Errorf("%s: expected %q, got %q", test.input, test.expected, cmds)
+			}
+		}
+	}
+}
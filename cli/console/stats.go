@@ -1,7 +1,7 @@
 // Statistics tool
 // @author Robin Verlangen
 
-package main
+package console
 
 import (
 	"bytes"
@@ -14,6 +14,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mgutz/ansi"
 )
@@ -26,11 +27,19 @@ type Statistics struct {
 	terminalHeight int
 	colorRed       string
 	colorGreen     string
+	colorYellow    string
 	colorReset     string
 	colorEnabled   bool
+	terminal       bool // False renders with a fixed size without colors (e.g. for Slack)
 }
 
 func (s *Statistics) loadTerminalDimensions() {
+	if !s.terminal {
+		s.colorEnabled = false
+		s.terminalWidth = 100
+		s.terminalHeight = 50
+		return
+	}
 	cmd := exec.Command("stty", "size")
 	cmd.Stdin = os.Stdin
 	out, err := cmd.Output()
@@ -40,7 +49,7 @@ func (s *Statistics) loadTerminalDimensions() {
 		s.colorEnabled = false
 		s.terminalWidth = 100
 		s.terminalHeight = 50
-		if verbose {
+		if Verbose {
 			log.Printf("Terminal dimension %dx%d (WxH)", s.terminalWidth, s.terminalHeight)
 		}
 		return
@@ -51,12 +60,13 @@ func (s *Statistics) loadTerminalDimensions() {
 	width, _ := strconv.ParseInt(split[1], 10, 0)
 	s.terminalHeight = int(height)
 	s.terminalWidth = int(width)
-	if verbose {
+	if Verbose {
 		log.Printf("Terminal dimension %dx%d (WxH)", s.terminalWidth, s.terminalHeight)
 	}
 }
 
-func (s *Statistics) RenderChart(filter *Filter, inputData map[int]map[int64]int64, flags map[string]bool) (string, error) {
+// Renders metric 1 (matches) and 2 (errors), time buckets (of rollup seconds) that contain an outlier are marked
+func (s *Statistics) RenderChart(filter *Filter, inputData map[int]map[int64]int64, outliers []*Outlier, rollup int64, flags map[string]bool) (string, error) {
 	// Random data (primary is top, secondary is filled, e.g. errors)
 	data := make([]int64, 0)
 	dataSecondary := make([]int64, 0)
@@ -77,6 +87,17 @@ func (s *Statistics) RenderChart(filter *Filter, inputData map[int]map[int64]int
 	primarySign := "o"
 	secondarySign := "*"
 
+	// Outliers
+	outlierColor := "yellow"
+	outlierSign := "X"
+	outlierAxisSign := "^"
+	if !s.colorEnabled {
+		outlierColor = "reset"
+	}
+	if flags["hide_outliers"] {
+		outliers = nil
+	}
+
 	// Flags
 	if flags["hide_error"] {
 		secondaryMetricId = -1 // Disable errors
@@ -118,9 +139,26 @@ func (s *Statistics) RenderChart(filter *Filter, inputData map[int]map[int64]int
 		log.Println("Warning, truncating data to match terminal width")
 		data = data[len(data)-maxDataLen:]
 		dataSecondary = dataSecondary[len(dataSecondary)-maxDataLen:]
+		keys = keys[len(keys)-maxDataLen:]
 		dataWidth = len(data)
 		// @todo Compress data (merge data points and get sums in order to fit in screen)
 	}
+
+	// Outliers per column, a column covers [bucket, bucket + rollup)
+	if rollup < 1 {
+		rollup = 60
+	}
+	outlierCols := make(map[int]bool)
+	chartOutliers := make([]*Outlier, 0)
+	for _, outlier := range outliers {
+		for col, k := range keys {
+			if outlier.Timestamp >= int64(k) && outlier.Timestamp < int64(k)+rollup {
+				outlierCols[col] = true
+				chartOutliers = append(chartOutliers, outlier)
+				break
+			}
+		}
+	}
 	maxHeight := int(math.Min(float64(20), float64(s.terminalHeight-4))) // remove some for padding
 	maxWidth := int(math.Max(float64(dataWidth), float64(s.terminalWidth)))
 
@@ -156,6 +194,10 @@ func (s *Statistics) RenderChart(filter *Filter, inputData map[int]map[int64]int
 				// Left axis
 				currentColor, colorStr = s.colorStr(currentColor, "reset", s.verticalSep)
 				buf.WriteString(colorStr)
+			} else if line == 0 && outlierCols[col] {
+				// Bottom axis, marker below a column with an outlier
+				currentColor, colorStr = s.colorStr(currentColor, outlierColor, outlierAxisSign)
+				buf.WriteString(colorStr)
 			} else if line == 0 {
 				// Bottom axis
 				currentColor, colorStr = s.colorStr(currentColor, "reset", s.horizontalSep)
@@ -167,7 +209,10 @@ func (s *Statistics) RenderChart(filter *Filter, inputData map[int]map[int64]int
 
 				// Print?
 				if colVal >= minLineVal {
-					if secondaryColVal >= minLineVal {
+					if outlierCols[col] {
+						currentColor, colorStr = s.colorStr(currentColor, outlierColor, outlierSign)
+						buf.WriteString(colorStr)
+					} else if secondaryColVal >= minLineVal {
 						currentColor, colorStr = s.colorStr(currentColor, secondaryColor, secondarySign)
 						buf.WriteString(colorStr)
 					} else {
@@ -192,6 +237,20 @@ func (s *Statistics) RenderChart(filter *Filter, inputData map[int]map[int64]int
 	}
 	buf.WriteString("\n") // Final whiteline
 
+	// Legend of outliers
+	if len(chartOutliers) > 0 {
+		currentColor, colorStr = s.colorStr(currentColor, "reset", "OUTLIERS\n")
+		buf.WriteString(colorStr)
+		for _, outlier := range chartOutliers {
+			ts := time.Unix(outlier.Timestamp, 0).Format("2006-01-02 15:04:05")
+			currentColor, colorStr = s.colorStr(currentColor, outlierColor, outlierAxisSign)
+			buf.WriteString(colorStr)
+			currentColor, colorStr = s.colorStr(currentColor, "reset", fmt.Sprintf(" %s score %.4f %s\n", ts, outlier.Score, outlier.Details))
+			buf.WriteString(colorStr)
+		}
+		buf.WriteString("\n")
+	}
+
 	// Reset color
 	if s.colorEnabled {
 		buf.WriteString(s.colorReset)
@@ -212,20 +271,24 @@ func (s *Statistics) colorStr(currentColor string, desiredColorName string, str
 		colorStr = s.colorGreen
 	} else if desiredColorName == "red" {
 		colorStr = s.colorRed
+	} else if desiredColorName == "yellow" {
+		colorStr = s.colorYellow
 	} else if desiredColorName == "reset" {
 		colorStr = s.colorReset
 	}
 	return desiredColorName, fmt.Sprintf("%s%s", colorStr, str)
 }
 
-func newStatistics() *Statistics {
+func newStatistics(terminal bool) *Statistics {
 	s := &Statistics{
+		terminal:      terminal,
 		verticalSep:   "|",
 		horizontalSep: "_",
 		colPad:        3,
 		colorEnabled:  true,
 		colorRed:      ansi.ColorCode("red"),
 		colorGreen:    ansi.ColorCode("green"),
+		colorYellow:   ansi.ColorCode("yellow"),
 		colorReset:    ansi.ColorCode("reset"),
 	}
 	s.loadTerminalDimensions()
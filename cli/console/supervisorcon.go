@@ -0,0 +1,1107 @@
+package console
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNoSearchBackend = errors.New("No search backend configured")
+
+const DEFAULT_SEARCH_DATASET string = "cloudpelican_lsd_v1"
+const DEFAULT_SEARCH_TABLE_VERSION int64 = 1
+const ERROR_CODE_NO_SEARCH_BACKEND string = "no_search_backend" // Code of the supervisor in case there are no search backends
+
+// Error status of the supervisor, with the machine-readable code and message of JSON errors (optional)
+type StatusError struct {
+	Status int
+	Code   string
+	Msg    string
+}
+
+func (e *StatusError) Error() string {
+	if len(e.Msg) > 0 {
+		return fmt.Sprintf("Status %d: %s", e.Status, e.Msg)
+	}
+	return fmt.Sprintf("Status %d", e.Status)
+}
+
+type SupervisorCon struct {
+	c               *Console
+	filtersCache    []*Filter
+	filtersCacheMux sync.RWMutex
+	httpClient      *http.Client
+	httpClientTls   string // TLS options of the session the client was created with
+	httpClientMux   sync.Mutex
+}
+
+type Filter struct {
+	Regex         string   `json:"regex"`
+	Name          string   `json:"name"`
+	ClientHost    string   `json:"client_host"`
+	Id            string   `json:"id"`
+	Extractors    []string `json:"extractors"`
+	SearchBackend string   `json:"search_backend"`
+
+	con *SupervisorCon
+}
+
+type Outlier struct {
+	Id        string  `json:"id"`
+	FilterId  string  `json:"filter_id"`
+	Score     float64 `json:"score"`
+	Timestamp int64   `json:"timestamp"`
+	Details   string  `json:"details"`
+}
+
+type AlertRule struct {
+	Id        string  `json:"id"`
+	Name      string  `json:"name"`
+	FilterId  string  `json:"filter_id"`
+	Condition string  `json:"condition"`
+	Operator  string  `json:"operator"`
+	Threshold float64 `json:"threshold"`
+	Window    int64   `json:"window"`
+	Notifier  string  `json:"notifier"`
+	Target    string  `json:"target"`
+	State     string  `json:"state"`
+	Value     float64 `json:"value"`
+	Since     int64   `json:"since"`
+}
+
+type Schedule struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Cron        string `json:"cron"`
+	Command     string `json:"command"`
+	Destination string `json:"destination"`
+	Paused      bool   `json:"paused"`
+	Role        string `json:"role"`
+	Creator     string `json:"creator"`
+	Created     int64  `json:"created"`
+	LastRun     int64  `json:"last_run"`
+	LastStatus  string `json:"last_status"`
+	NextRun     int64  `json:"next_run"`
+}
+
+type ScheduleRun struct {
+	Started  int64  `json:"started"`
+	Duration int64  `json:"duration"`
+	Status   string `json:"status"`
+	Error    string `json:"error"`
+	Messages int    `json:"messages"`
+}
+
+type User struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Created  int64  `json:"created"`
+}
+
+type Token struct {
+	Id       string   `json:"id"`
+	Username string   `json:"username"`
+	Role     string   `json:"role"`
+	Filters  []string `json:"filters"`
+	Created  int64    `json:"created"`
+	Expires  int64    `json:"expires"`
+	Current  bool     `json:"current"`
+}
+
+type SearchBackend struct {
+	Id           string `json:"id"`
+	Type         string `json:"type"`
+	ProjectId    string `json:"project_id"`
+	DatasetId    string `json:"dataset_id"`
+	TableVersion int64  `json:"table_version"`
+	Default      bool   `json:"default"`
+	Health       string `json:"health"`
+}
+
+// Search backend of queries on this filter: the backend of the session (use backend), the filter or the default (empty)
+func (f *Filter) GetSearchBackendId() string {
+	if len(f.con.c.Session["search_backend"]) > 0 {
+		return f.con.c.Session["search_backend"]
+	}
+	return f.SearchBackend
+}
+
+// Get table name from filter, multiple days are a TABLE_QUERY of the daily tables (days without results have no table)
+func (f *Filter) GetSearchTableName(r *TimeRange) (string, error) {
+	backend := f.con.SearchBackend(f.GetSearchBackendId())
+	if r == nil {
+		r = &TimeRange{}
+	}
+	days, err := r.Days()
+	if err != nil {
+		return "", err
+	}
+	tables := make([]string, 0)
+	for _, day := range days {
+		tables = append(tables, fmt.Sprintf("%s_results_%s_v%d", strings.Replace(f.Id, "-", "_", -1), day.Format("2006_01_02"), backend.TableVersion))
+	}
+	if len(tables) == 1 {
+		return fmt.Sprintf("%s.%s", backend.DatasetId, tables[0]), nil
+	}
+	return fmt.Sprintf("TABLE_QUERY([%s], 'table_id IN (\"%s\")')", backend.DatasetId, strings.Join(tables, "\", \"")), nil
+}
+
+// Returns a map of metricId => timestamp => count and the rollup of the buckets (at least the resolution of the tier)
+func (f *Filter) GetStats(window int64, rollup int64) (map[int]map[int64]int64, int64, error) {
+	// Request, the supervisor picks the finest timeseries tier that covers the window
+	uri := fmt.Sprintf("filter/%s/stats?window=%d", f.Id, window)
+	data, err := f.con._get(uri)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Parse JSON
+	var d map[string]interface{}
+	je := json.Unmarshal([]byte(data), &d)
+	if je != nil {
+		return nil, 0, je
+	}
+
+	// Now
+	var nowUnix int64 = time.Now().Unix()
+	var minTs int64 = nowUnix - window
+
+	// Rollup can not be finer than the resolution of the tier
+	if resolution, ok := d["resolution"].(float64); ok && rollup != -1 && int64(resolution) > rollup {
+		if Verbose {
+			log.Printf("Rollup %d is finer than the resolution of tier %s, using %d", rollup, d["tier"], int64(resolution))
+		}
+		rollup = int64(resolution)
+	}
+
+	// To map + rollup
+	var res map[int]map[int64]int64 = make(map[int]map[int64]int64)
+	for metricId, data := range d["stats"].(map[string]interface{}) {
+		// Convert metric to integer
+		i, _ := strconv.ParseInt(metricId, 10, 0)
+		metric := int(i)
+		if res[metric] == nil {
+			res[metric] = make(map[int64]int64)
+		}
+
+		// Iterate timestamp-value pairs
+		var lowestBucket int64 = math.MaxInt64
+		var highestBucket int64 = math.MinInt64
+		for tsStr, val := range data.(map[string]interface{}) {
+			// Convert to integer
+			tsI, _ := strconv.ParseInt(tsStr, 10, 64)
+			ts := int64(tsI)
+
+			// Honor window
+			if ts < minTs {
+				continue
+			}
+
+			// Determine bucket
+			bucket := ts
+			if rollup != -1 {
+				bucket = ts - (ts % rollup)
+			}
+
+			// Store first bucket
+			if bucket < lowestBucket {
+				lowestBucket = bucket
+			}
+			if bucket > highestBucket {
+				highestBucket = bucket
+			}
+
+			// Put in resultset
+			res[metric][bucket] += int64(val.(float64))
+		}
+
+		// Fill any gaps
+		// log.Printf("first key %d", lowestBucket)
+		// log.Printf("last key %d", highestBucket)
+		// log.Printf("step size %d", rollup)
+		// log.Printf("len before %d", len(res[metric]))
+		for i := lowestBucket; i < highestBucket; i += rollup {
+			// Explictly set the value
+			if res[metric][i] == 0 {
+				res[metric][i] = 0
+			}
+		}
+		// log.Printf("len after %d", len(res[metric]))
+
+	}
+
+	return res, rollup, nil
+}
+
+// Returns the outliers within the window (seconds), newest first
+func (f *Filter) GetOutliers(window int64, minScore float64, limit int) ([]*Outlier, error) {
+	// Request
+	from := time.Now().Unix() - window
+	uri := fmt.Sprintf("filter/%s/outliers?from=%d&min_score=%f&limit=%d", f.Id, from, minScore, limit)
+	data, err := f.con._get(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse JSON
+	var d struct {
+		Status   string     `json:"status"`
+		Outliers []*Outlier `json:"outliers"`
+	}
+	je := json.Unmarshal([]byte(data), &d)
+	if je != nil {
+		return nil, je
+	}
+	if d.Status != "OK" {
+		return nil, errors.New("Failed to load outliers, status not OK")
+	}
+	return d.Outliers, nil
+}
+
+// Search backends configured in the supervisor, optionally with their health (checked by the supervisor)
+func (s *SupervisorCon) SearchBackends(health bool) ([]*SearchBackend, error) {
+	data, err := s._get(fmt.Sprintf("search/backends?health=%t", health))
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse JSON
+	var d struct {
+		Status   string           `json:"status"`
+		Error    string           `json:"error"`
+		Backends []*SearchBackend `json:"backends"`
+	}
+	je := json.Unmarshal([]byte(data), &d)
+	if je != nil {
+		return nil, je
+	}
+	if d.Status != "OK" {
+		return nil, errors.New(fmt.Sprintf("Failed to load search backends: %s", d.Error))
+	}
+	for _, backend := range d.Backends {
+		if len(backend.DatasetId) < 1 {
+			backend.DatasetId = DEFAULT_SEARCH_DATASET
+		}
+		if backend.TableVersion < 1 {
+			backend.TableVersion = DEFAULT_SEARCH_TABLE_VERSION
+		}
+	}
+	return d.Backends, nil
+}
+
+// Search backend by ID (empty is the default), older supervisors and supervisors without backends get the defaults
+func (s *SupervisorCon) SearchBackend(id string) *SearchBackend {
+	backend := &SearchBackend{DatasetId: DEFAULT_SEARCH_DATASET, TableVersion: DEFAULT_SEARCH_TABLE_VERSION}
+	backends, err := s.SearchBackends(false)
+	if err != nil {
+		if Verbose {
+			log.Printf("Failed to load search backends, using defaults: %s", err)
+		}
+		return backend
+	}
+	for _, b := range backends {
+		if (len(id) < 1 && b.Default) || b.Id == id {
+			return b
+		}
+	}
+	if len(backends) > 0 {
+		return backends[0]
+	}
+	return backend
+}
+
+// Execute a query on the search backend of the filter, tokens restricted to filters can only query their filters
+func (s *SupervisorCon) Search(ctx context.Context, q string, filter *Filter) (string, error) {
+	backendId := filter.GetSearchBackendId()
+	if Verbose {
+		log.Printf("Executing search query on backend '%s': %s", backendId, q)
+	}
+	uri := fmt.Sprintf("bigquery/query?filter=%s", url.QueryEscape(filter.Id))
+	if len(backendId) > 0 {
+		uri = fmt.Sprintf("%s&backend=%s", uri, url.QueryEscape(backendId))
+	}
+	data, err := s._postDataWithContext(ctx, uri, q)
+	if se, ok := err.(*StatusError); ok && se.Code == ERROR_CODE_NO_SEARCH_BACKEND {
+		return "", ErrNoSearchBackend
+	}
+	return data, err
+}
+
+// All results of a filter held by the supervisor, oldest first
+func (s *SupervisorCon) Results(ctx context.Context, filterId string) ([]string, error) {
+	lines := make([]string, 0)
+	offset := uint64(0)
+	for {
+		data, err := s._getWithContext(ctx, fmt.Sprintf("filter/%s/result?result_offset=%d", filterId, offset))
+		if err != nil {
+			return nil, err
+		}
+
+		// Parse JSON
+		var d struct {
+			Status       string   `json:"status"`
+			Error        string   `json:"error"`
+			ResultOffset uint64   `json:"result_offset"`
+			Results      []string `json:"results"`
+		}
+		je := json.Unmarshal([]byte(data), &d)
+		if je != nil {
+			return nil, je
+		}
+		if d.Status != "OK" {
+			return nil, errors.New(fmt.Sprintf("Failed to load results: %s", d.Error))
+		}
+
+		// Done once a batch is empty
+		if len(d.Results) == 0 || d.ResultOffset <= offset {
+			break
+		}
+		lines = append(lines, d.Results...)
+		offset = d.ResultOffset
+	}
+	return lines, nil
+}
+
+func (s *SupervisorCon) Connect() bool {
+	if Verbose {
+		log.Printf("Connecting to %s", s.c.Session["supervisor_uri"])
+	}
+	_, err := s._get("filter")
+	if err == nil {
+		if !s.c.Silent {
+			fmt.Fprintf(s.c.Out, "Connected to %s\n", s.c.Session["supervisor_uri"])
+		}
+	} else {
+		fmt.Fprintf(s.c.Out, "Failed to connect: %s\n", err)
+		return false
+	}
+	return true
+}
+
+func (s *SupervisorCon) SetSupervisorConfig(k string, v string) (bool, error) {
+	_, err := s._put(fmt.Sprintf("admin/config?key=%s&value=%s", url.QueryEscape(k), url.QueryEscape(v)))
+	if err == nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *SupervisorCon) Ping() {
+	start := time.Now()
+	_, err := s._get("ping")
+	if err == nil {
+		duration := time.Now().Sub(start)
+		fmt.Fprintf(s.c.Out, "Pong, took %s\n", duration.String())
+	}
+}
+
+func (s *SupervisorCon) CreateFilter(name string, regex string, extractors []string, searchBackend string) (*Filter, error) {
+	if Verbose {
+		log.Printf("Creating filter '%s' with regex '%s'", name, regex)
+	}
+	// Create
+	data, err := s._post(fmt.Sprintf("filter?name=%s&regex=%s&extractors=%s&search_backend=%s", url.QueryEscape(name), url.QueryEscape(regex), url.QueryEscape(strings.Join(extractors, ",")), url.QueryEscape(searchBackend)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse JSON
+	var d struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}
+	je := json.Unmarshal([]byte(data), &d)
+	if je != nil {
+		return nil, je
+	}
+	if d.Status != "OK" {
+		if len(d.Error) > 0 {
+			return nil, errors.New(d.Error)
+		}
+		return nil, errors.New("Failed to create filter, status not OK")
+	}
+
+	// Clear cache
+	s.filtersCacheMux.Lock()
+	s.filtersCache = nil
+	s.filtersCacheMux.Unlock()
+
+	// Return instance
+	return s.FilterByName(name)
+}
+
+func (s *SupervisorCon) RemoveFilter(name string) bool {
+	if Verbose {
+		log.Printf("Deleting filter '%s'", name)
+	}
+	filter, e := s.FilterByName(name)
+	if e != nil {
+		return false
+	}
+	s._delete(fmt.Sprintf("filter/%s", url.QueryEscape(filter.Id)))
+
+	// Clear cache
+	s.filtersCacheMux.Lock()
+	s.filtersCache = nil
+	s.filtersCacheMux.Unlock()
+
+	// Verify
+	verify, _ := s.FilterByName(name)
+	return verify == nil
+}
+
+func (s *SupervisorCon) CreateAlert(rule *AlertRule) error {
+	if Verbose {
+		log.Printf("Creating alert '%s' on filter %s", rule.Name, rule.FilterId)
+	}
+	uri := fmt.Sprintf("alert?name=%s&filter_id=%s&condition=%s&operator=%s&threshold=%f&window=%d&notifier=%s&target=%s",
+		url.QueryEscape(rule.Name), url.QueryEscape(rule.FilterId), url.QueryEscape(rule.Condition), url.QueryEscape(rule.Operator),
+		rule.Threshold, rule.Window, url.QueryEscape(rule.Notifier), url.QueryEscape(rule.Target))
+	data, err := s._post(uri)
+	if err != nil {
+		return err
+	}
+
+	// Parse JSON
+	var d struct {
+		Status  string `json:"status"`
+		Error   string `json:"error"`
+		AlertId string `json:"alert_id"`
+	}
+	je := json.Unmarshal([]byte(data), &d)
+	if je != nil {
+		return je
+	}
+	if d.Status != "OK" {
+		if len(d.Error) > 0 {
+			return errors.New(d.Error)
+		}
+		return errors.New("Failed to create alert, status not OK")
+	}
+	rule.Id = d.AlertId
+	return nil
+}
+
+func (s *SupervisorCon) Alerts() ([]*AlertRule, error) {
+	data, err := s._get("alert")
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse JSON
+	var d struct {
+		Status string       `json:"status"`
+		Alerts []*AlertRule `json:"alerts"`
+	}
+	je := json.Unmarshal([]byte(data), &d)
+	if je != nil {
+		return nil, je
+	}
+	if d.Status != "OK" {
+		return nil, errors.New("Failed to load alerts, status not OK")
+	}
+	return d.Alerts, nil
+}
+
+func (s *SupervisorCon) RemoveAlert(name string) bool {
+	if Verbose {
+		log.Printf("Deleting alert '%s'", name)
+	}
+	alerts, err := s.Alerts()
+	if err != nil {
+		return false
+	}
+	for _, alert := range alerts {
+		if strings.ToLower(alert.Name) == strings.ToLower(name) {
+			_, e := s._delete(fmt.Sprintf("alert/%s", url.QueryEscape(alert.Id)))
+			return e == nil
+		}
+	}
+	return false
+}
+
+func (s *SupervisorCon) CreateSchedule(schedule *Schedule) error {
+	if Verbose {
+		log.Printf("Creating schedule '%s' (%s)", schedule.Name, schedule.Cron)
+	}
+	uri := fmt.Sprintf("schedule?name=%s&cron=%s&command=%s&destination=%s",
+		url.QueryEscape(schedule.Name), url.QueryEscape(schedule.Cron), url.QueryEscape(schedule.Command), url.QueryEscape(schedule.Destination))
+	data, err := s._post(uri)
+	if err != nil {
+		return err
+	}
+
+	// Parse JSON
+	var d struct {
+		Status     string `json:"status"`
+		Error      string `json:"error"`
+		ScheduleId string `json:"schedule_id"`
+	}
+	je := json.Unmarshal([]byte(data), &d)
+	if je != nil {
+		return je
+	}
+	if d.Status != "OK" {
+		if len(d.Error) > 0 {
+			return errors.New(d.Error)
+		}
+		return errors.New("Failed to create schedule, status not OK")
+	}
+	schedule.Id = d.ScheduleId
+	return nil
+}
+
+func (s *SupervisorCon) Schedules() ([]*Schedule, error) {
+	data, err := s._get("schedule")
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse JSON
+	var d struct {
+		Status    string      `json:"status"`
+		Schedules []*Schedule `json:"schedules"`
+	}
+	je := json.Unmarshal([]byte(data), &d)
+	if je != nil {
+		return nil, je
+	}
+	if d.Status != "OK" {
+		return nil, errors.New("Failed to load schedules, status not OK")
+	}
+	return d.Schedules, nil
+}
+
+func (s *SupervisorCon) ScheduleByName(name string) (*Schedule, error) {
+	schedules, err := s.Schedules()
+	if err != nil {
+		return nil, err
+	}
+	for _, schedule := range schedules {
+		if strings.ToLower(schedule.Name) == strings.ToLower(name) {
+			return schedule, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("Schedule '%s' not found", name))
+}
+
+func (s *SupervisorCon) PauseSchedule(name string, paused bool) error {
+	if Verbose {
+		log.Printf("Setting paused of schedule '%s' to %t", name, paused)
+	}
+	schedule, err := s.ScheduleByName(name)
+	if err != nil {
+		return err
+	}
+	data, err := s._put(fmt.Sprintf("schedule/%s?paused=%t", url.QueryEscape(schedule.Id), paused))
+	if err != nil {
+		return err
+	}
+
+	// Parse JSON
+	var d struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}
+	je := json.Unmarshal([]byte(data), &d)
+	if je != nil {
+		return je
+	}
+	if d.Status != "OK" {
+		if len(d.Error) > 0 {
+			return errors.New(d.Error)
+		}
+		return errors.New("Failed to update schedule, status not OK")
+	}
+	return nil
+}
+
+func (s *SupervisorCon) RemoveSchedule(name string) bool {
+	if Verbose {
+		log.Printf("Deleting schedule '%s'", name)
+	}
+	schedule, err := s.ScheduleByName(name)
+	if err != nil {
+		return false
+	}
+	_, e := s._delete(fmt.Sprintf("schedule/%s", url.QueryEscape(schedule.Id)))
+	return e == nil
+}
+
+// History of the schedule, most recent first
+func (s *SupervisorCon) ScheduleRuns(schedule *Schedule, limit int) ([]*ScheduleRun, error) {
+	data, err := s._get(fmt.Sprintf("schedule/%s/runs?limit=%d", url.QueryEscape(schedule.Id), limit))
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse JSON
+	var d struct {
+		Status string         `json:"status"`
+		Runs   []*ScheduleRun `json:"runs"`
+	}
+	je := json.Unmarshal([]byte(data), &d)
+	if je != nil {
+		return nil, je
+	}
+	if d.Status != "OK" {
+		return nil, errors.New("Failed to load runs, status not OK")
+	}
+	return d.Runs, nil
+}
+
+func (s *SupervisorCon) CreateUser(username string, password string, role string) error {
+	if Verbose {
+		log.Printf("Creating user '%s' with role '%s'", username, role)
+	}
+	data, err := s._post(fmt.Sprintf("admin/user?username=%s&password=%s&role=%s", url.QueryEscape(username), url.QueryEscape(password), url.QueryEscape(role)))
+	if err != nil {
+		return err
+	}
+
+	// Parse JSON
+	var d struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}
+	je := json.Unmarshal([]byte(data), &d)
+	if je != nil {
+		return je
+	}
+	if d.Status != "OK" {
+		if len(d.Error) > 0 {
+			return errors.New(d.Error)
+		}
+		return errors.New("Failed to create user, status not OK")
+	}
+	return nil
+}
+
+func (s *SupervisorCon) Users() ([]*User, error) {
+	data, err := s._get("admin/user")
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse JSON
+	var d struct {
+		Status string  `json:"status"`
+		Users  []*User `json:"users"`
+	}
+	je := json.Unmarshal([]byte(data), &d)
+	if je != nil {
+		return nil, je
+	}
+	if d.Status != "OK" {
+		return nil, errors.New("Failed to load users, status not OK")
+	}
+	return d.Users, nil
+}
+
+func (s *SupervisorCon) RemoveUser(username string) bool {
+	if Verbose {
+		log.Printf("Deleting user '%s'", username)
+	}
+	data, err := s._delete(fmt.Sprintf("admin/user/%s", url.QueryEscape(username)))
+	if err != nil {
+		return false
+	}
+	var d struct {
+		Deleted bool `json:"deleted"`
+	}
+	json.Unmarshal([]byte(data), &d)
+	return d.Deleted
+}
+
+// Request a token with the basic auth credentials of the session, returns the token
+func (s *SupervisorCon) Login(role string, filters string, expires int64) (string, error) {
+	uri := fmt.Sprintf("auth/login?role=%s&filters=%s", url.QueryEscape(role), url.QueryEscape(filters))
+	if expires >= 0 {
+		uri = fmt.Sprintf("%s&expires=%d", uri, expires)
+	}
+	data, err := s._post(uri)
+	if err != nil {
+		return "", err
+	}
+
+	// Parse JSON
+	var d struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Token  string `json:"token"`
+	}
+	je := json.Unmarshal([]byte(data), &d)
+	if je != nil {
+		return "", je
+	}
+	if d.Status != "OK" {
+		if len(d.Error) > 0 {
+			return "", errors.New(d.Error)
+		}
+		return "", errors.New("Failed to login, status not OK")
+	}
+	return d.Token, nil
+}
+
+func (s *SupervisorCon) Tokens() ([]*Token, error) {
+	data, err := s._get("auth/token")
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse JSON
+	var d struct {
+		Status string   `json:"status"`
+		Tokens []*Token `json:"tokens"`
+	}
+	je := json.Unmarshal([]byte(data), &d)
+	if je != nil {
+		return nil, je
+	}
+	if d.Status != "OK" {
+		return nil, errors.New("Failed to load tokens, status not OK")
+	}
+	return d.Tokens, nil
+}
+
+func (s *SupervisorCon) RevokeToken(id string) bool {
+	if Verbose {
+		log.Printf("Revoking token '%s'", id)
+	}
+	data, err := s._delete(fmt.Sprintf("auth/token/%s", url.QueryEscape(id)))
+	if err != nil {
+		return false
+	}
+	var d struct {
+		Revoked bool `json:"revoked"`
+	}
+	json.Unmarshal([]byte(data), &d)
+	return d.Revoked
+}
+
+func isUuid(in string) bool {
+	isUuid, _ := regexp.MatchString("[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}", in)
+	return isUuid
+}
+
+func (s *SupervisorCon) FilterByName(name string) (*Filter, error) {
+	if isUuid(name) {
+		return s.FilterById(name)
+	}
+	filters, err := s.Filters()
+	if err != nil {
+		return nil, err
+	}
+	for _, filter := range filters {
+		if strings.ToLower(filter.Name) == strings.ToLower(name) {
+			return filter, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("Filter '%s' not found", name))
+}
+
+func (s *SupervisorCon) FilterById(id string) (*Filter, error) {
+	filters, err := s.Filters()
+	if err != nil {
+		return nil, err
+	}
+	for _, filter := range filters {
+		if id == filter.Id {
+			return filter, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("Filter '%s' not found", id))
+}
+
+func (s *SupervisorCon) Filters() ([]*Filter, error) {
+	// Update function
+	fetchData := func() ([]*Filter, error) {
+		// List holder
+		list := make([]*Filter, 0)
+
+		// Fetch API
+		data, err := s._get("filter")
+		if err != nil {
+			return nil, err
+		}
+		// Parse and create list
+		var resp map[string]interface{}
+		jErr := json.Unmarshal([]byte(data), &resp)
+		if jErr != nil {
+			return nil, jErr
+		}
+		for _, v := range resp["filters"].([]interface{}) {
+			elm := v.(map[string]interface{})
+			filter := newFilter(s)
+			filter.Regex = fmt.Sprintf("%s", elm["regex"])
+			filter.Name = fmt.Sprintf("%s", elm["name"])
+			filter.ClientHost = fmt.Sprintf("%s", elm["client_host"])
+			filter.Id = fmt.Sprintf("%s", elm["id"])
+			if searchBackend, ok := elm["search_backend"].(string); ok {
+				filter.SearchBackend = searchBackend
+			}
+			if extractors, ok := elm["extractors"].([]interface{}); ok {
+				for _, extractor := range extractors {
+					filter.Extractors = append(filter.Extractors, fmt.Sprintf("%s", extractor))
+				}
+			}
+
+			// Tmp?
+			if strings.HasPrefix(filter.Name, TMP_FILTER_PREFIX) {
+				// Remove if they are older than x hours
+				tsStr := filter.Name[len(TMP_FILTER_PREFIX):]
+				tsVal, tsE := strconv.ParseInt(tsStr, 10, 64)
+				if tsE != nil {
+					tsVal = 0
+				}
+				tsNow := time.Now().Unix()
+				maxHours := int64(1)
+				tsMin := tsNow - (maxHours * 3600)
+				if tsVal < tsMin {
+					if Verbose {
+						log.Println(fmt.Sprintf("Removing stale filter %s", filter.Id))
+					}
+					go func(id string) {
+						s._delete(fmt.Sprintf("filter/%s", url.QueryEscape(id)))
+					}(filter.Id)
+
+					// Do not show in list
+					continue
+				}
+			}
+
+			// Append
+			list = append(list, filter)
+		}
+
+		// Put in cache
+		if len(list) > 0 {
+			s.filtersCacheMux.Lock()
+			s.filtersCache = list
+			s.filtersCacheMux.Unlock()
+		}
+
+		// Return
+		return list, nil
+	}
+
+	// From cache?
+	s.filtersCacheMux.RLock()
+	if s.filtersCache != nil {
+		s.filtersCacheMux.RUnlock()
+		go fetchData() // Async update
+		return s.filtersCache, nil
+	}
+	s.filtersCacheMux.RUnlock()
+
+	// Not from cache
+	return fetchData()
+}
+
+func (s *SupervisorCon) _get(uri string) (string, error) {
+	return s._doRequest("GET", uri, "")
+}
+
+// Request that can be aborted, e.g. a long-polling request that is interrupted by the user
+func (s *SupervisorCon) _getWithContext(ctx context.Context, uri string) (string, error) {
+	return s._doRequestWithContext(ctx, "GET", uri, "")
+}
+
+func (s *SupervisorCon) _put(uri string) (string, error) {
+	return s._doRequest("PUT", uri, "")
+}
+
+func (s *SupervisorCon) _post(uri string) (string, error) {
+	return s._doRequest("POST", uri, "")
+}
+
+func (s *SupervisorCon) _putData(uri string, data string) (string, error) {
+	return s._doRequest("PUT", uri, data)
+}
+
+func (s *SupervisorCon) _postData(uri string, data string) (string, error) {
+	return s._doRequest("POST", uri, data)
+}
+
+func (s *SupervisorCon) _postDataWithContext(ctx context.Context, uri string, data string) (string, error) {
+	return s._doRequestWithContext(ctx, "POST", uri, data)
+}
+
+func (s *SupervisorCon) _delete(uri string) (string, error) {
+	return s._doRequest("DELETE", uri, "")
+}
+
+func (s *SupervisorCon) _doRequest(method string, uri string, data string) (string, error) {
+	return s._doRequestWithContext(context.Background(), method, uri, data)
+}
+
+func (s *SupervisorCon) _doRequestWithContext(ctx context.Context, method string, uri string, data string) (string, error) {
+	// Client
+	client, err := s._getHttpClient()
+	if err != nil {
+		return "", err
+	}
+
+	// Request
+	var reqBody *bytes.Buffer
+	if len(data) > 0 {
+		reqBody = bytes.NewBuffer([]byte(data))
+	} else {
+		reqBody = bytes.NewBuffer(make([]byte, 0))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s%s", s.c.Session["supervisor_uri"], uri), reqBody)
+	if err != nil {
+		return "", err
+	}
+
+	// Auth header, the token is preferred over basic auth
+	if len(s.c.Session["supervisor_token"]) > 0 {
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.c.Session["supervisor_token"]))
+	} else {
+		req.Header.Add("Authorization", fmt.Sprintf("Basic %s", s._getBasicAuthToken()))
+	}
+
+	// Execute
+	resp, respErr := client.Do(req)
+	if respErr != nil {
+		return "", respErr
+	}
+
+	// Read body
+	defer resp.Body.Close()
+	contents, readErr := ioutil.ReadAll(resp.Body)
+	if readErr != nil {
+		return "", readErr
+	}
+	str := string(contents)
+
+	// Status, plain text errors (e.g. of searches) and JSON errors are added to the error
+	if resp.StatusCode >= 400 {
+		statusErr := &StatusError{Status: resp.StatusCode}
+		contentType := resp.Header.Get("Content-Type")
+		if strings.HasPrefix(contentType, "text/plain") {
+			statusErr.Msg = strings.TrimSpace(str)
+		} else if strings.HasPrefix(contentType, "application/json") {
+			var jsonErr struct {
+				Code  string `json:"code"`
+				Error string `json:"error"`
+			}
+			if json.Unmarshal(contents, &jsonErr) == nil {
+				statusErr.Code = jsonErr.Code
+				statusErr.Msg = jsonErr.Error
+			}
+		}
+		return "", statusErr
+	}
+	if Verbose {
+		log.Printf("Received body %s", str)
+	}
+
+	// Error after the first results of a search, the results are incomplete
+	if msg := resp.Trailer.Get("X-Search-Error"); len(msg) > 0 {
+		return "", errors.New(fmt.Sprintf("Incomplete results: %s", msg))
+	}
+	return str, nil
+}
+
+func (s *SupervisorCon) _getBasicAuthToken() string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", s.c.Session["supervisor_username"], s.c.Session["supervisor_password"])))
+}
+
+// HTTP client, re-created once the TLS options of the session change
+// Invalid TLS options (e.g. an unreadable CA bundle) are an error, requests are never sent without them
+func (s *SupervisorCon) _getHttpClient() (*http.Client, error) {
+	s.httpClientMux.Lock()
+	defer s.httpClientMux.Unlock()
+
+	// Client of the console, e.g. in-process requests of the supervisor
+	if s.c.HttpClient != nil {
+		return s.c.HttpClient, nil
+	}
+
+	// TLS options of the session: custom CA bundle and/or pinned certificates
+	caFile := s.c.Session["supervisor_ca_file"]
+	pins := s.c.Session["supervisor_pin_sha256"]
+	tlsKey := fmt.Sprintf("%s|%s", caFile, pins)
+	if s.httpClient != nil && s.httpClientTls == tlsKey {
+		return s.httpClient, nil
+	}
+	s.httpClient = nil
+	client := &http.Client{}
+	if len(caFile) > 0 || len(pins) > 0 {
+		tlsConf, err := _getTlsConfig(caFile, pins)
+		if err != nil {
+			return nil, err
+		}
+		client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConf,
+		}
+	}
+	s.httpClient = client
+	s.httpClientTls = tlsKey
+	return client, nil
+}
+
+// TLS config with a custom CA bundle, pins (comma separated) are SHA-256 hashes of the public key (hex or base64)
+// In case only pins are provided the certificate chain is not verified, this allows self-signed certificates
+func _getTlsConfig(caFile string, pins string) (*tls.Config, error) {
+	tlsConf := &tls.Config{}
+	if len(caFile) > 0 {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to read CA bundle: %s", err))
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New(fmt.Sprintf("No certificates found in CA bundle %s", caFile))
+		}
+		tlsConf.RootCAs = pool
+	}
+	if len(pins) > 0 {
+		tlsConf.InsecureSkipVerify = len(caFile) < 1
+		tlsConf.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			if len(rawCerts) < 1 {
+				return errors.New("No certificate presented by supervisor")
+			}
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range strings.Split(pins, ",") {
+				pin = strings.TrimSpace(pin)
+				if strings.ToLower(pin) == hex.EncodeToString(sum[:]) || pin == base64.StdEncoding.EncodeToString(sum[:]) {
+					return nil
+				}
+			}
+			return errors.New("Certificate of supervisor does not match pinned public key")
+		}
+	}
+	return tlsConf, nil
+}
+
+func NewSupervisorCon(c *Console) *SupervisorCon {
+	return &SupervisorCon{c: c}
+}
+
+func newFilter(con *SupervisorCon) *Filter {
+	return &Filter{con: con}
+}
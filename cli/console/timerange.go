@@ -0,0 +1,140 @@
+// Time range of searches, results are stored in a table per filter per day (<filter>_results_YYYY_MM_DD_v<version>)
+// - since <time>, until <time>: 2015-06-01, '2015-06-01 12:00', RFC 3339 or a duration ago (e.g. 2h)
+// - window <duration>: the last period until now, e.g. 30m, 12h, 3d or 2w
+// @author Robin Verlangen
+
+package console
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MAX_SEARCH_DAYS int = 93
+
+var durationRegex = regexp.MustCompile(`^(\d+)\s*([smhdw])$`)
+
+var timeFormats []string = []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02 15:04:05", time.RFC3339}
+
+type TimeRange struct {
+	Since time.Time // Zero is the start of today
+	Until time.Time // Zero is now
+}
+
+func (r *TimeRange) String() string {
+	var buf []string
+	if !r.Since.IsZero() {
+		buf = append(buf, fmt.Sprintf("SINCE '%s'", r.Since.Format("2006-01-02 15:04:05")))
+	}
+	if !r.Until.IsZero() {
+		buf = append(buf, fmt.Sprintf("UNTIL '%s'", r.Until.Format("2006-01-02 15:04:05")))
+	}
+	return strings.Join(buf, " ")
+}
+
+// Days of the range, oldest first
+func (r *TimeRange) Days() ([]time.Time, error) {
+	until := r.Until
+	if until.IsZero() {
+		until = time.Now()
+	}
+	since := r.Since
+	if since.IsZero() {
+		since = until
+	}
+	if since.After(until) {
+		return nil, errors.New(fmt.Sprintf("Invalid time range, %s is after %s", since.Format("2006-01-02 15:04:05"), until.Format("2006-01-02 15:04:05")))
+	}
+	day := time.Date(since.Year(), since.Month(), since.Day(), 0, 0, 0, 0, since.Location())
+	days := make([]time.Time, 0)
+	for !day.After(until) {
+		days = append(days, day)
+		if len(days) > MAX_SEARCH_DAYS {
+			return nil, errors.New(fmt.Sprintf("Time range exceeds the maximum of %d days", MAX_SEARCH_DAYS))
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return days, nil
+}
+
+// Condition on the timestamp column, empty if the range only selects tables
+func (r *TimeRange) BigQuery() string {
+	var buf []string
+	if !r.Since.IsZero() {
+		buf = append(buf, fmt.Sprintf("timestamp >= TIMESTAMP('%s')", r.Since.UTC().Format("2006-01-02 15:04:05")))
+	}
+	if !r.Until.IsZero() {
+		buf = append(buf, fmt.Sprintf("timestamp <= TIMESTAMP('%s')", r.Until.UTC().Format("2006-01-02 15:04:05")))
+	}
+	return strings.Join(buf, " AND ")
+}
+
+// Duration like 30m, 12h, 3d or 2w
+func parseDuration(s string) (time.Duration, error) {
+	m := durationRegex.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, errors.New(fmt.Sprintf("Invalid duration %s, use for example 30m, 12h or 3d", s))
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	unit := time.Second
+	switch m[2] {
+	case "m":
+		unit = time.Minute
+	case "h":
+		unit = time.Hour
+	case "d":
+		unit = 24 * time.Hour
+	case "w":
+		unit = 7 * 24 * time.Hour
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// Point in time, either a date (time) in local time or a duration ago
+func parseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if d, err := parseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, format := range timeFormats {
+		if t, err := time.ParseInLocation(format, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New(fmt.Sprintf("Invalid time %s, use for example 2015-06-01, '2015-06-01 12:00' or 2h", s))
+}
+
+// Set an option of the range: since, until or window
+func (r *TimeRange) Set(option string, value string) error {
+	switch strings.ToLower(option) {
+	case "since":
+		t, err := parseTime(value)
+		if err != nil {
+			return err
+		}
+		r.Since = t
+	case "until":
+		t, err := parseTime(value)
+		if err != nil {
+			return err
+		}
+		r.Until = t
+	case "window":
+		d, err := parseDuration(value)
+		if err != nil {
+			return err
+		}
+		r.Until = time.Time{}
+		r.Since = time.Now().Add(-d)
+	default:
+		return errors.New(fmt.Sprintf("Unknown time range option %s, use since, until or window", option))
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package console
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeRangeDays(t *testing.T) {
+	zone := time.FixedZone("CEST", 2*3600)
+	date := func(year int, month time.Month, day int, hour int, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, zone)
+	}
+	tests := []struct {
+		name  string
+		r     *TimeRange
+		first time.Time
+		days  int
+	}{
+		{"same day", &TimeRange{Since: date(2015, 6, 1, 8, 0), Until: date(2015, 6, 1, 9, 0)}, date(2015, 6, 1, 0, 0), 1},
+		{"across midnight", &TimeRange{Since: date(2015, 6, 1, 23, 30), Until: date(2015, 6, 2, 0, 10)}, date(2015, 6, 1, 0, 0), 2},
+		{"until midnight", &TimeRange{Since: date(2015, 6, 1, 12, 0), Until: date(2015, 6, 2, 0, 0)}, date(2015, 6, 1, 0, 0), 2},
+		{"across months", &TimeRange{Since: date(2015, 2, 27, 0, 0), Until: date(2015, 3, 2, 0, 0)}, date(2015, 2, 27, 0, 0), 4},
+		{"without since", &TimeRange{Until: date(2015, 6, 2, 10, 0)}, date(2015, 6, 2, 0, 0), 1},
+		{"maximum", &TimeRange{Since: date(2015, 1, 1, 0, 0), Until: date(2015, 4, 3, 23, 59)}, date(2015, 1, 1, 0, 0), MAX_SEARCH_DAYS},
+	}
+	for _, test := range tests {
+		days, err := test.r.Days()
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.name, err)
+			continue
+		}
+		if len(days) != test.days || !days[0].Equal(test.first) {
+			t.Errorf("%s: expected %d days from %s, got %v", test.name, test.days, test.first, days)
+			continue
+		}
+		for i := 1; i < len(days); i++ {
+			if days[i].Sub(days[i-1]) != 24*time.Hour {
+				t.Errorf("%s: expected consecutive days, got %s and %s", test.name, days[i-1], days[i])
+			}
+		}
+	}
+
+	// Errors
+	for name, r := range map[string]*TimeRange{
+		"since after until": {Since: date(2015, 6, 2, 0, 0), Until: date(2015, 6, 1, 0, 0)},
+		"exceeds maximum":   {Since: date(2015, 1, 1, 0, 0), Until: date(2015, 4, 4, 0, 0)},
+	} {
+		if _, err := r.Days(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestTimeRangeBigQuery(t *testing.T) {
+	zone := time.FixedZone("CEST", 2*3600)
+	tests := []struct {
+		r        *TimeRange
+		expected string
+	}{
+		{&TimeRange{}, ""},
+		{&TimeRange{Since: time.Date(2015, 6, 2, 1, 0, 0, 0, zone)}, "timestamp >= TIMESTAMP('2015-06-01 23:00:00')"},
+		{&TimeRange{Since: time.Date(2015, 6, 1, 12, 0, 0, 0, zone), Until: time.Date(2015, 6, 2, 0, 30, 15, 0, zone)}, "timestamp >= TIMESTAMP('2015-06-01 10:00:00') AND timestamp <= TIMESTAMP('2015-06-01 22:30:15')"},
+		{&TimeRange{Until: time.Date(2015, 6, 2, 0, 0, 0, 0, time.UTC)}, "timestamp <= TIMESTAMP('2015-06-02 00:00:00')"},
+	}
+	for _, test := range tests {
+		if sql := test.r.BigQuery(); sql != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.r, test.expected, sql)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+	}{
+		{"30s", 30 * time.Second},
+		{"30m", 30 * time.Minute},
+		{"12H", 12 * time.Hour},
+		{"3 d", 3 * 24 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+	}
+	for _, test := range tests {
+		d, err := parseDuration(test.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.input, err)
+		} else if d != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.input, test.expected, d)
+		}
+	}
+	for _, input := range []string{"", "3", "3y", "-1h", "1.5h"} {
+		if _, err := parseDuration(input); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+// Alert manager
+// - Rules are stored in BoltDB next to the filters
+// - Rules are evaluated in the background, state changes (firing, resolved) are sent to a notifier
+// @author Robin Verlangen
+
+package main
+
+import (
+	"bytes"
+	"code.google.com/p/go-uuid/uuid"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const ALERT_STATE_OK string = "ok"
+const ALERT_STATE_FIRING string = "firing"
+
+type AlertManager struct {
+	db          *bolt.DB
+	alertTable  string
+	notifiers   map[string]Notifier
+	evaluateMux sync.Mutex
+}
+
+type AlertRule struct {
+	Id        string  `json:"id"`
+	Name      string  `json:"name"`
+	FilterId  string  `json:"filter_id"`
+	Condition string  `json:"condition"` // errors, matches, outlier_score
+	Operator  string  `json:"operator"`  // >, >=, <, <=, ==, !=
+	Threshold float64 `json:"threshold"`
+	Window    int64   `json:"window"`   // Seconds
+	Notifier  string  `json:"notifier"` // slack, webhook, stdout
+	Target    string  `json:"target"`   // Slack channel or webhook URL
+	State     string  `json:"state"`
+	Value     float64 `json:"value"` // Value of the last evaluation
+	Since     int64   `json:"since"` // Unix timestamp of the last state change
+}
+
+// Notifiers send state changes of alerts to the outside world
+type Notifier interface {
+	Notify(rule *AlertRule, filter *Filter) error
+}
+
+// Message of a state change
+func (a *AlertRule) Message(filter *Filter) string {
+	filterName := a.FilterId
+	if filter != nil {
+		filterName = filter.Name
+	}
+	if a.State == ALERT_STATE_FIRING {
+		return fmt.Sprintf("FIRING alert %s: %s of filter %s is %g (%s %g over %ds)", a.Name, a.Condition, filterName, a.Value, a.Operator, a.Threshold, a.Window)
+	}
+	return fmt.Sprintf("RESOLVED alert %s: %s of filter %s is %g", a.Name, a.Condition, filterName, a.Value)
+}
+
+// Compare the value with the threshold
+func (a *AlertRule) Matches(value float64) bool {
+	switch a.Operator {
+	case ">":
+		return value > a.Threshold
+	case ">=":
+		return value >= a.Threshold
+	case "<":
+		return value < a.Threshold
+	case "<=":
+		return value <= a.Threshold
+	case "==":
+		return value == a.Threshold
+	case "!=":
+		return value != a.Threshold
+	}
+	return false
+}
+
+func (a *AlertRule) Validate(am *AlertManager) error {
+	if len(a.Name) < 1 {
+		return errors.New("Please provide a name")
+	}
+	if a.Condition != "errors" && a.Condition != "matches" && a.Condition != "outlier_score" {
+		return errors.New(fmt.Sprintf("Unsupported condition %s, use errors, matches or outlier_score", a.Condition))
+	}
+	if a.Operator != ">" && a.Operator != ">=" && a.Operator != "<" && a.Operator != "<=" && a.Operator != "==" && a.Operator != "!=" {
+		return errors.New(fmt.Sprintf("Unsupported operator %s", a.Operator))
+	}
+	if a.Window < 60 {
+		return errors.New("Window must be at least 60 seconds")
+	}
+	if am.notifiers[a.Notifier] == nil {
+		return errors.New(fmt.Sprintf("Unsupported notifier %s", a.Notifier))
+	}
+	if a.Notifier == "webhook" {
+		if _, err := url.ParseRequestURI(a.Target); err != nil {
+			return errors.New(fmt.Sprintf("Please provide a valid webhook URL: %s", err))
+		}
+	}
+	return nil
+}
+
+func (am *AlertManager) CreateAlert(rule *AlertRule) (string, error) {
+	// Validate
+	if err := rule.Validate(am); err != nil {
+		return "", err
+	}
+	for _, existing := range am.GetAlerts() {
+		if existing.Name == rule.Name {
+			return "", errors.New(fmt.Sprintf("There already exist an alert with the name %s", rule.Name))
+		}
+	}
+
+	// Init
+	rule.Id = uuid.New()
+	rule.State = ALERT_STATE_OK
+	rule.Since = time.Now().Unix()
+	if err := am.saveAlert(rule); err != nil {
+		return "", err
+	}
+	log.Printf("Created alert %s", rule.Id)
+	return rule.Id, nil
+}
+
+// Store the evaluated state of an existing rule, rules that were removed in the meantime are not restored
+func (am *AlertManager) updateAlert(rule *AlertRule) (bool, error) {
+	b, err := json.Marshal(rule)
+	if err != nil {
+		return false, err
+	}
+	exists := false
+	err = am.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(am.alertTable))
+		if bucket.Get([]byte(rule.Id)) == nil {
+			return nil
+		}
+		exists = true
+		return bucket.Put([]byte(rule.Id), b)
+	})
+	return exists, err
+}
+
+func (am *AlertManager) saveAlert(rule *AlertRule) error {
+	b, err := json.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	return am.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(am.alertTable)).Put([]byte(rule.Id), b)
+	})
+}
+
+func (am *AlertManager) GetAlerts() []*AlertRule {
+	list := make([]*AlertRule, 0)
+	am.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(am.alertTable)).ForEach(func(k []byte, v []byte) error {
+			rule := &AlertRule{}
+			if err := json.Unmarshal(v, rule); err != nil {
+				log.Printf("Failed json umarshal %s", err)
+				return nil
+			}
+			list = append(list, rule)
+			return nil
+		})
+	})
+	return list
+}
+
+func (am *AlertManager) GetAlert(id string) *AlertRule {
+	var rule *AlertRule
+	am.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(am.alertTable)).Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		rule = &AlertRule{}
+		if err := json.Unmarshal(v, rule); err != nil {
+			log.Printf("Failed json umarshal %s", err)
+			rule = nil
+		}
+		return nil
+	})
+	return rule
+}
+
+// Remove the rules of a filter, returns the amount of removed rules
+func (am *AlertManager) DeleteAlertsOf(filterId string) int {
+	removed := 0
+	for _, rule := range am.GetAlerts() {
+		if rule.FilterId == filterId && am.DeleteAlert(rule.Id) {
+			removed++
+		}
+	}
+	if removed > 0 {
+		log.Printf("Removed %d alerts of filter %s", removed, filterId)
+	}
+	return removed
+}
+
+func (am *AlertManager) DeleteAlert(id string) bool {
+	err := am.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(am.alertTable)).Delete([]byte(id))
+	})
+	if err != nil {
+		log.Printf("Failed to remove alert %s: %s", id, err)
+		return false
+	}
+	return true
+}
+
+// Current value of the rule, the minute that is still being collected is left out
+func (am *AlertManager) evaluateValue(rule *AlertRule, filter *Filter) float64 {
+	nowUnix := time.Now().Unix()
+	until := nowUnix - (nowUnix % 60)
+	from := until - rule.Window
+
+	// Outliers, highest score within the window
+	if rule.Condition == "outlier_score" {
+		var max float64 = 0
+		for _, outlier := range filter.Outliers(from, until, 0, -1) {
+			if outlier.Score > max {
+				max = outlier.Score
+			}
+		}
+		return max
+	}
+
+	// Counters, sum within the window
+	metric := 1
+	if rule.Condition == "errors" {
+		metric = 2
+	}
+	var sum int64 = 0
+	stats := filter.GetStatsRange(filterManager.TimeseriesTierForWindow(rule.Window), from, until)
+	if stats.Metrics[metric] != nil {
+		for _, val := range stats.Metrics[metric].Data {
+			sum += val
+		}
+	}
+	return float64(sum)
+}
+
+// Evaluate all rules, notify on state changes
+func (am *AlertManager) Evaluate() {
+	am.evaluateMux.Lock()
+	defer am.evaluateMux.Unlock()
+	for _, rule := range am.GetAlerts() {
+		filter := filterManager.GetFilter(rule.FilterId)
+		if filter == nil {
+			if verbose {
+				log.Printf("Filter %s of alert %s not found", rule.FilterId, rule.Id)
+			}
+			continue
+		}
+
+		// Determine state
+		rule.Value = am.evaluateValue(rule, filter)
+		state := ALERT_STATE_OK
+		if rule.Matches(rule.Value) {
+			state = ALERT_STATE_FIRING
+		}
+		if verbose {
+			log.Printf("Alert %s value %g state %s", rule.Name, rule.Value, state)
+		}
+		if state == rule.State {
+			continue
+		}
+
+		// State change, stored before notifying so a failed notification does not repeat on the next evaluation
+		rule.State = state
+		rule.Since = time.Now().Unix()
+		exists, err := am.updateAlert(rule)
+		if err != nil {
+			log.Printf("Failed to save alert %s: %s", rule.Id, err)
+		} else if !exists {
+			// Removed during the evaluation
+			continue
+		}
+		log.Println(rule.Message(filter))
+		if err := am.notifiers[rule.Notifier].Notify(rule, filter); err != nil {
+			log.Printf("Failed to notify %s of alert %s: %s", rule.Notifier, rule.Id, err)
+		}
+	}
+}
+
+// Evaluate the rules every once in a while
+func (am *AlertManager) Evaluator() {
+	go func() {
+		for {
+			// Interval is read every time, this allows changing it at runtime
+			interval := conf.GetIntOrDefault("alert_interval_seconds", 60)
+			if interval < 1 {
+				interval = 1
+			}
+			time.Sleep(time.Duration(interval) * time.Second)
+			am.Evaluate()
+		}
+	}()
+}
+
+// Log line on standard output
+type StdoutNotifier struct {
+}
+
+func (n *StdoutNotifier) Notify(rule *AlertRule, filter *Filter) error {
+	fmt.Println(rule.Message(filter))
+	return nil
+}
+
+// Slack incoming webhook, the target is an optional channel
+type SlackNotifier struct {
+}
+
+func (n *SlackNotifier) Notify(rule *AlertRule, filter *Filter) error {
+	webhook := conf.Get("slack_incoming_webhook")
+	if len(webhook) < 1 {
+		return errors.New("No slack_incoming_webhook configured")
+	}
+	var jsonData map[string]string = make(map[string]string)
+	if len(rule.Target) > 0 {
+		jsonData["channel"] = rule.Target
+	}
+	jsonData["username"] = "CloudPelican"
+	jsonData["text"] = rule.Message(filter)
+	jsonData["icon_emoji"] = ":rotating_light:"
+	jsonBytes, jsonE := json.Marshal(jsonData)
+	if jsonE != nil {
+		return jsonE
+	}
+	reqBody := bytes.NewBuffer([]byte(fmt.Sprintf("payload=%s", url.QueryEscape(string(jsonBytes)))))
+	return postNotification(webhook, "application/x-www-form-urlencoded", reqBody)
+}
+
+// Generic HTTP webhook, the rule is posted as JSON to the target
+type WebhookNotifier struct {
+}
+
+func (n *WebhookNotifier) Notify(rule *AlertRule, filter *Filter) error {
+	var jsonData map[string]interface{} = make(map[string]interface{})
+	jsonData["alert"] = rule
+	jsonData["message"] = rule.Message(filter)
+	if filter != nil {
+		jsonData["filter"] = filter
+	}
+	jsonBytes, jsonE := json.Marshal(jsonData)
+	if jsonE != nil {
+		return jsonE
+	}
+	return postNotification(rule.Target, "application/json", bytes.NewBuffer(jsonBytes))
+}
+
+func postNotification(uri string, contentType string, body *bytes.Buffer) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(uri, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return errors.New(fmt.Sprintf("Status %d", resp.StatusCode))
+	}
+	return nil
+}
+
+// Init the alert manager
+func NewAlertManager(db *bolt.DB) *AlertManager {
+	am := &AlertManager{
+		db:         db,
+		alertTable: "alerts",
+		notifiers:  make(map[string]Notifier),
+	}
+	am.notifiers["stdout"] = &StdoutNotifier{}
+	am.notifiers["slack"] = &SlackNotifier{}
+	am.notifiers["webhook"] = &WebhookNotifier{}
+
+	// Create bucket
+	err := am.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(am.alertTable))
+		return err
+	})
+	if err != nil {
+		log.Fatal(fmt.Errorf("create bucket: %s", err))
+	}
+	am.Evaluator()
+	return am
+}
@@ -0,0 +1,277 @@
+// BigQuery search backend, native client of the REST API (v2) authenticated as a service account
+// - project_id: project that runs the queries, service_account_id: email address of the service account
+// - pk12base64: base64 of the P12 key file (see tools/bigquery) or private_key: PEM of the key (e.g. of a JSON key file)
+// - api_url, token_url: endpoints of the API, defaults to Google (e.g. a fake server for testing, see tools/testing)
+// - query_timeout: seconds before a query is cancelled (default 300)
+// Queries use legacy SQL, the dialect of the queries generated by the CLI
+// @author Robin Verlangen
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/pkcs12"
+	"golang.org/x/oauth2/jwt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const BIGQUERY_API_URL string = "https://www.googleapis.com/bigquery/v2"
+const BIGQUERY_TOKEN_URL string = "https://accounts.google.com/o/oauth2/token"
+const BIGQUERY_SCOPE string = "https://www.googleapis.com/auth/bigquery"
+const BIGQUERY_P12_PASSWORD string = "notasecret"
+const BIGQUERY_PAGE_SIZE int = 10000
+const BIGQUERY_WAIT_MS int = 10000 // Time the API holds a request for the results of a running query
+const BIGQUERY_DEFAULT_TIMEOUT int64 = 300
+
+type BigQuerySearchBackend struct {
+	projectId string
+	datasetId string
+	apiUrl    string
+	client    *http.Client
+	timeout   time.Duration
+}
+
+type bigQueryJobReference struct {
+	ProjectId string `json:"projectId"`
+	JobId     string `json:"jobId"`
+	Location  string `json:"location"`
+}
+
+// Response of jobs.query and jobs.getQueryResults
+type bigQueryResults struct {
+	JobReference bigQueryJobReference `json:"jobReference"`
+	JobComplete  bool                 `json:"jobComplete"`
+	Schema       struct {
+		Fields []struct {
+			Name string `json:"name"`
+		} `json:"fields"`
+	} `json:"schema"`
+	Rows []struct {
+		F []struct {
+			V interface{} `json:"v"`
+		} `json:"f"`
+	} `json:"rows"`
+	PageToken string `json:"pageToken"`
+}
+
+type bigQueryError struct {
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (b *BigQuerySearchBackend) Query(ctx context.Context, q string, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+
+	// Start query, the API waits a while for the results
+	res := &bigQueryResults{}
+	body := map[string]interface{}{
+		"query":        q,
+		"useLegacySql": true,
+		"timeoutMs":    BIGQUERY_WAIT_MS,
+		"maxResults":   BIGQUERY_PAGE_SIZE,
+	}
+	if err := b.do(ctx, "POST", fmt.Sprintf("projects/%s/queries", url.PathEscape(b.projectId)), body, res); err != nil {
+		return err
+	}
+	job := res.JobReference
+
+	// Wait for the results
+	for !res.JobComplete {
+		res = &bigQueryResults{}
+		if err := b.do(ctx, "GET", b.resultsUri(job, ""), nil, res); err != nil {
+			b.cancelJob(ctx, job)
+			return err
+		}
+	}
+
+	// Header
+	for _, field := range res.Schema.Fields {
+		fmt.Fprintf(w, "%s\t", field.Name)
+	}
+	fmt.Fprintln(w)
+
+	// Rows, page by page
+	for {
+		for _, row := range res.Rows {
+			for _, cell := range row.F {
+				fmt.Fprintf(w, "%s\t", bigQueryValue(cell.V))
+			}
+			fmt.Fprintln(w)
+		}
+		if len(res.PageToken) < 1 {
+			break
+		}
+		pageToken := res.PageToken
+		res = &bigQueryResults{}
+		if err := b.do(ctx, "GET", b.resultsUri(job, pageToken), nil, res); err != nil {
+			b.cancelJob(ctx, job)
+			return err
+		}
+	}
+	return nil
+}
+
+// The dataset of the results can be read
+func (b *BigQuerySearchBackend) Health(ctx context.Context) error {
+	var res map[string]interface{}
+	return b.do(ctx, "GET", fmt.Sprintf("projects/%s/datasets/%s", url.PathEscape(b.projectId), url.PathEscape(b.datasetId)), nil, &res)
+}
+
+func (b *BigQuerySearchBackend) resultsUri(job bigQueryJobReference, pageToken string) string {
+	params := url.Values{}
+	params.Set("timeoutMs", fmt.Sprintf("%d", BIGQUERY_WAIT_MS))
+	params.Set("maxResults", fmt.Sprintf("%d", BIGQUERY_PAGE_SIZE))
+	if len(job.Location) > 0 {
+		params.Set("location", job.Location)
+	}
+	if len(pageToken) > 0 {
+		params.Set("pageToken", pageToken)
+	}
+	return fmt.Sprintf("projects/%s/queries/%s?%s", url.PathEscape(b.projectId), url.PathEscape(job.JobId), params.Encode())
+}
+
+// Cancel the job in case the query was cancelled (client disconnected or timeout)
+func (b *BigQuerySearchBackend) cancelJob(ctx context.Context, job bigQueryJobReference) {
+	if ctx.Err() == nil || len(job.JobId) < 1 {
+		return
+	}
+	cancelCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	uri := fmt.Sprintf("projects/%s/jobs/%s/cancel", url.PathEscape(b.projectId), url.PathEscape(job.JobId))
+	if len(job.Location) > 0 {
+		uri = fmt.Sprintf("%s?location=%s", uri, url.QueryEscape(job.Location))
+	}
+	var res map[string]interface{}
+	if err := b.do(cancelCtx, "POST", uri, nil, &res); err != nil {
+		log.Printf("Failed to cancel BigQuery job %s: %s", job.JobId, err)
+		return
+	}
+	log.Printf("Cancelled BigQuery job %s", job.JobId)
+}
+
+func (b *BigQuerySearchBackend) do(ctx context.Context, method string, uri string, body interface{}, res interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		bodyBytes, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(bodyBytes)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s/%s", b.apiUrl, uri), reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if verbose {
+		log.Printf("BigQuery %s %s: %d %s", method, uri, resp.StatusCode, data)
+	}
+
+	// Errors of the API have a message
+	if resp.StatusCode >= 300 {
+		var e bigQueryError
+		if json.Unmarshal(data, &e) == nil && e.Error != nil && len(e.Error.Message) > 0 {
+			return errors.New(fmt.Sprintf("BigQuery: %s", e.Error.Message))
+		}
+		return errors.New(fmt.Sprintf("BigQuery: status %d", resp.StatusCode))
+	}
+	return json.Unmarshal(data, res)
+}
+
+// Cells are strings or null, nested (repeated) values are kept as JSON
+func bigQueryValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// PEM of the private key, from the P12 key (base64) or the PEM itself
+func bigQueryPrivateKey(pk12base64 string, privateKey string) ([]byte, error) {
+	if len(privateKey) > 0 {
+		// Newlines can be escaped in the configuration
+		privateKey = strings.Replace(privateKey, "\\n", "\n", -1)
+		if block, _ := pem.Decode([]byte(privateKey)); block == nil {
+			return nil, errors.New("Invalid private_key, expected a PEM encoded key")
+		}
+		return []byte(privateKey), nil
+	}
+	if len(pk12base64) < 1 {
+		return nil, errors.New("Please configure the pk12base64 or private_key")
+	}
+	p12, err := base64.StdEncoding.DecodeString(strings.TrimSpace(pk12base64))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid base64 of pk12base64: %s", err))
+	}
+	key, _, err := pkcs12.Decode(p12, BIGQUERY_P12_PASSWORD)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Failed to load P12 key: %s", err))
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("Unsupported P12 key, expected an RSA key")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), nil
+}
+
+func newBigQuerySearchBackend(id string) (*BigQuerySearchBackend, error) {
+	setting := func(key string, d string) string {
+		return conf.GetOrDefault(fmt.Sprintf("search_backends.%s.%s", id, key), d)
+	}
+	projectId := setting("project_id", "")
+	serviceAccountId := setting("service_account_id", "")
+	if len(projectId) < 1 || len(serviceAccountId) < 1 {
+		return nil, errors.New(fmt.Sprintf("Please configure the project_id and service_account_id of search backend %s", id))
+	}
+	key, err := bigQueryPrivateKey(setting("pk12base64", ""), setting("private_key", ""))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Search backend %s: %s", id, err))
+	}
+
+	// Tokens are requested (and refreshed) with a JWT signed by the key of the service account
+	jwtConf := &jwt.Config{
+		Email:      serviceAccountId,
+		PrivateKey: key,
+		Scopes:     []string{BIGQUERY_SCOPE},
+		TokenURL:   setting("token_url", BIGQUERY_TOKEN_URL),
+	}
+	return &BigQuerySearchBackend{
+		projectId: projectId,
+		datasetId: setting("dataset_id", DEFAULT_SEARCH_DATASET),
+		apiUrl:    strings.TrimRight(setting("api_url", BIGQUERY_API_URL), "/"),
+		client:    jwtConf.Client(context.Background()),
+		timeout:   time.Duration(conf.GetIntOrDefault(fmt.Sprintf("search_backends.%s.query_timeout", id), BIGQUERY_DEFAULT_TIMEOUT)) * time.Second,
+	}, nil
+}
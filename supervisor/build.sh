@@ -1,4 +1,6 @@
 #!/bin/bash
 export GOPATH=`pwd`
+mkdir -p src/github.com/RobinUS2/cloudpelican-lsd
+ln -sfn "$(cd ../cli && pwd)" src/github.com/RobinUS2/cloudpelican-lsd/cli # Console of the cli (Slack)
 go get ./...
 go build .
@@ -6,6 +6,8 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -66,6 +68,33 @@ func (c *Conf) GetOrDefault(k string, d string) string {
 	return c.data[k]
 }
 
+// All values with keys that start with the prefix
+func (c *Conf) GetWithPrefix(prefix string) map[string]string {
+	c.dataMux.RLock()
+	defer c.dataMux.RUnlock()
+	res := make(map[string]string)
+	for k, v := range c.data {
+		if strings.HasPrefix(k, prefix) {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+func (c *Conf) GetIntOrDefault(k string, d int64) int64 {
+	// Locking in base function
+	val := c.GetOrDefault(k, "")
+	if len(val) < 1 {
+		return d
+	}
+	i, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		log.Printf("Invalid integer %s for conf %s, using default %d", val, k, d)
+		return d
+	}
+	return i
+}
+
 func newConf(path string) *Conf {
 	c := &Conf{}
 
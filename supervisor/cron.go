@@ -0,0 +1,172 @@
+// Cron expressions of scheduled commands
+// - Five fields: minute, hour, day of month, month and day of week (0-7, 0 and 7 are Sunday), in the time zone of the supervisor
+// - Fields support *, lists (1,15), ranges (1-5), steps (*/10, 8-18/2) and names (jan, mon)
+// - Macros: @hourly, @daily (@midnight), @weekly, @monthly and @yearly (@annually)
+// - Like cron, a day matches the day of month OR the day of week in case both are restricted (do not start with *)
+// @author Robin Verlangen
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const CRON_MAX_SEARCH_YEARS int = 5 // Expressions that never match (e.g. 30 feb) have no next time
+
+var CRON_MACROS map[string]string = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var CRON_MONTHS []string = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var CRON_WEEKDAYS []string = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+type CronSchedule struct {
+	minutes    uint64 // Bit per allowed value
+	hours      uint64
+	days       uint64
+	months     uint64
+	weekdays   uint64
+	anyDay     bool
+	anyWeekday bool
+}
+
+func parseCron(expr string) (*CronSchedule, error) {
+	expr = strings.TrimSpace(strings.ToLower(expr))
+	if macro, ok := CRON_MACROS[expr]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New(fmt.Sprintf("Invalid cron expression '%s', expected 5 fields (minute hour day month weekday)", expr))
+	}
+	// Fields starting with * (e.g. */2) are not restricted for the day of month OR day of week rule, like cron
+	c := &CronSchedule{
+		anyDay:     strings.HasPrefix(fields[2], "*"),
+		anyWeekday: strings.HasPrefix(fields[4], "*"),
+	}
+	var err error
+	if c.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid minute: %s", err))
+	}
+	if c.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid hour: %s", err))
+	}
+	if c.days, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid day of month: %s", err))
+	}
+	if c.months, err = parseCronField(fields[3], 1, 12, CRON_MONTHS); err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid month: %s", err))
+	}
+	if c.weekdays, err = parseCronField(fields[4], 0, 7, CRON_WEEKDAYS); err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid day of week: %s", err))
+	}
+	if c.weekdays&(1<<7) != 0 {
+		// Sunday
+		c.weekdays |= 1
+	}
+	return c, nil
+}
+
+// Bits of the values of a field, names start at the minimum value
+func parseCronField(field string, min int, max int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		// Step
+		step := 1
+		if split := strings.SplitN(part, "/", 2); len(split) == 2 {
+			var err error
+			if step, err = strconv.Atoi(split[1]); err != nil || step < 1 {
+				return 0, errors.New(fmt.Sprintf("invalid step in %s", part))
+			}
+			part = split[0]
+		}
+
+		// Range
+		from, to := min, max
+		if part != "*" {
+			split := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = parseCronValue(split[0], min, max, names); err != nil {
+				return 0, err
+			}
+			to = from
+			if len(split) == 2 {
+				if to, err = parseCronValue(split[1], min, max, names); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// E.g. 5/15 is 5-max/15
+				to = max
+			}
+			if to < from {
+				return 0, errors.New(fmt.Sprintf("invalid range %s", part))
+			}
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(value string, min int, max int, names []string) (int, error) {
+	for i, name := range names {
+		if value == name {
+			return min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < min || v > max {
+		return 0, errors.New(fmt.Sprintf("%s is not within %d-%d", value, min, max))
+	}
+	return v, nil
+}
+
+// Does the minute of the time match the expression
+func (c *CronSchedule) Matches(t time.Time) bool {
+	return c.minutes&(1<<uint(t.Minute())) != 0 && c.hours&(1<<uint(t.Hour())) != 0 && c.matchesDay(t)
+}
+
+func (c *CronSchedule) matchesDay(t time.Time) bool {
+	if c.months&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	day := c.days&(1<<uint(t.Day())) != 0
+	weekday := c.weekdays&(1<<uint(t.Weekday())) != 0
+	if c.anyDay || c.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// First matching minute after the time, zero in case there is none
+func (c *CronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(CRON_MAX_SEARCH_YEARS, 0, 0)
+	for t.Before(limit) {
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
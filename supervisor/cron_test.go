@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronDayOrWeekday(t *testing.T) {
+	// 2026-10-17 is a Saturday
+	saturday17 := time.Date(2026, 10, 17, 9, 0, 0, 0, time.Local)
+	sunday18 := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	monday19 := time.Date(2026, 10, 19, 9, 0, 0, 0, time.Local)
+	tests := []struct {
+		expr    string
+		times   []time.Time
+		matches []bool
+	}{
+		// Both restricted: day of month OR day of week
+		{"0 9 17 * 1", []time.Time{saturday17, sunday18, monday19}, []bool{true, false, true}},
+		// Day of month unrestricted (also with a step): only the day of week
+		{"0 9 * * 1", []time.Time{saturday17, sunday18, monday19}, []bool{false, false, true}},
+		{"0 9 */2 * 1", []time.Time{saturday17, sunday18, monday19}, []bool{false, false, true}},
+		// Day of week unrestricted (also with a step): only the day of month
+		{"0 9 17 * */2", []time.Time{saturday17, sunday18, monday19}, []bool{true, false, false}},
+		{"0 9 18 * sun", []time.Time{saturday17, sunday18, monday19}, []bool{false, true, false}},
+	}
+	for _, test := range tests {
+		cron, err := parseCron(test.expr)
+		if err != nil {
+			t.Fatalf("%s: %s", test.expr, err)
+		}
+		for i, tm := range test.times {
+			if cron.Matches(tm) != test.matches[i] {
+				t.Errorf("%s: expected %v on %s", test.expr, test.matches[i], tm.Format("Mon 2"))
+			}
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	after := time.Date(2026, 10, 17, 9, 1, 30, 0, time.Local)
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"*/15 * * * *", time.Date(2026, 10, 17, 9, 15, 0, 0, time.Local)},
+		{"@daily", time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local)},
+		{"0 9 * * mon-fri", time.Date(2026, 10, 19, 9, 0, 0, 0, time.Local)},
+		{"0 0 1 jan *", time.Date(2027, 1, 1, 0, 0, 0, 0, time.Local)},
+		{"0 0 30 feb *", time.Time{}},
+	}
+	for _, test := range tests {
+		cron, err := parseCron(test.expr)
+		if err != nil {
+			t.Fatalf("%s: %s", test.expr, err)
+		}
+		if next := cron.Next(after); !next.Equal(test.expected) {
+			t.Errorf("%s: expected %s, got %s", test.expr, test.expected, next)
+		}
+	}
+}
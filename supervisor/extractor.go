@@ -0,0 +1,180 @@
+// Field extraction of filter results
+// - Extractors are configured per filter and run at ingest time, the fields are stored next to _raw
+// - syslog: priority, timestamp, host, program, pid and message from the syslog header (RFC 3164 and RFC 5424)
+// - regex: named capture groups of the filter regex, e.g. (?P<status>\d{3})
+// - json: JSON object in the line (after the syslog header), nested keys are joined with a dot
+// - kv: key=value pairs, values can be quoted
+// @author Robin Verlangen
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const EXTRACTOR_SYSLOG string = "syslog"
+const EXTRACTOR_REGEX string = "regex"
+const EXTRACTOR_JSON string = "json"
+const EXTRACTOR_KV string = "kv"
+
+type Extractor interface {
+	// Add the fields found in the raw line, _raw itself is never overwritten
+	Extract(raw string, fields map[string]string)
+}
+
+type SyslogExtractor struct{}
+
+type RegexExtractor struct {
+	regex *regexp.Regexp
+}
+
+type JsonExtractor struct{}
+
+type KvExtractor struct{}
+
+// <PRI>Mmm dd hh:mm:ss host program[pid]: message
+var syslog3164Regex = regexp.MustCompile(`^(?:<(\d{1,3})>)?([A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}) (\S+) ([^\s:\[]+)(?:\[(\d+)\])?: ?(.*)$`)
+
+// <PRI>1 timestamp host app procid msgid [structured data] message
+var syslog5424Regex = regexp.MustCompile(`^<(\d{1,3})>1 (\S+) (\S+) (\S+) (\S+) \S+ (?:-|(?:\[.*?\])+) ?(.*)$`)
+
+var kvRegex = regexp.MustCompile(`([A-Za-z_][A-Za-z0-9_.-]*)=("(?:[^"\\]|\\.)*"|\S*)`)
+
+func (e *SyslogExtractor) Extract(raw string, fields map[string]string) {
+	if m := syslog5424Regex.FindStringSubmatch(raw); m != nil {
+		setField(fields, "priority", m[1])
+		setField(fields, "timestamp", m[2])
+		setField(fields, "host", nilValue(m[3]))
+		setField(fields, "program", nilValue(m[4]))
+		setField(fields, "pid", nilValue(m[5]))
+		setField(fields, "message", m[6])
+		return
+	}
+	if m := syslog3164Regex.FindStringSubmatch(raw); m != nil {
+		setField(fields, "priority", m[1])
+		setField(fields, "timestamp", m[2])
+		setField(fields, "host", m[3])
+		setField(fields, "program", m[4])
+		setField(fields, "pid", m[5])
+		setField(fields, "message", m[6])
+	}
+}
+
+func (e *RegexExtractor) Extract(raw string, fields map[string]string) {
+	m := e.regex.FindStringSubmatch(raw)
+	if m == nil {
+		return
+	}
+	for i, name := range e.regex.SubexpNames() {
+		if i == 0 || len(name) == 0 {
+			continue
+		}
+		setField(fields, name, m[i])
+	}
+}
+
+func (e *JsonExtractor) Extract(raw string, fields map[string]string) {
+	// The object usually follows a syslog header
+	start := strings.Index(raw, "{")
+	if start == -1 {
+		return
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(raw[start:]), &obj); err != nil {
+		return
+	}
+	flattenJson("", obj, fields)
+}
+
+func (e *KvExtractor) Extract(raw string, fields map[string]string) {
+	for _, m := range kvRegex.FindAllStringSubmatch(raw, -1) {
+		val := m[2]
+		if strings.HasPrefix(val, "\"") {
+			if unquoted, err := strconv.Unquote(val); err == nil {
+				val = unquoted
+			} else {
+				val = strings.Trim(val, "\"")
+			}
+		}
+		setField(fields, m[1], val)
+	}
+}
+
+// Nested objects are joined with a dot, arrays are kept as JSON
+func flattenJson(prefix string, obj map[string]interface{}, fields map[string]string) {
+	for k, v := range obj {
+		key := k
+		if len(prefix) > 0 {
+			key = fmt.Sprintf("%s.%s", prefix, k)
+		}
+		switch val := v.(type) {
+		case map[string]interface{}:
+			flattenJson(key, val, fields)
+		case string:
+			setField(fields, key, val)
+		case nil:
+			setField(fields, key, "")
+		case float64:
+			setField(fields, key, strconv.FormatFloat(val, 'f', -1, 64))
+		default:
+			b, _ := json.Marshal(val)
+			setField(fields, key, string(b))
+		}
+	}
+}
+
+func setField(fields map[string]string, key string, value string) {
+	if key == "_raw" {
+		return
+	}
+	fields[key] = value
+}
+
+// Subset of the fields of a result, * selects all fields, missing fields are left out
+func selectFields(fields map[string]string, names []string) map[string]string {
+	res := make(map[string]string)
+	for _, name := range names {
+		if name == "*" {
+			for k, v := range fields {
+				res[k] = v
+			}
+			continue
+		}
+		if val, ok := fields[name]; ok {
+			res[name] = val
+		}
+	}
+	return res
+}
+
+// RFC 5424 uses a dash for empty values
+func nilValue(s string) string {
+	if s == "-" {
+		return ""
+	}
+	return s
+}
+
+// Extractor by name, the regex extractor uses the regex of the filter
+func newExtractor(name string, filter *Filter) (Extractor, error) {
+	switch name {
+	case EXTRACTOR_SYSLOG:
+		return &SyslogExtractor{}, nil
+	case EXTRACTOR_REGEX:
+		regex, err := regexp.Compile(filter.Regex)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to compile regex of filter %s: %s", filter.Name, err))
+		}
+		return &RegexExtractor{regex: regex}, nil
+	case EXTRACTOR_JSON:
+		return &JsonExtractor{}, nil
+	case EXTRACTOR_KV:
+		return &KvExtractor{}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unsupported extractor %s, use %s, %s, %s or %s", name, EXTRACTOR_SYSLOG, EXTRACTOR_REGEX, EXTRACTOR_JSON, EXTRACTOR_KV))
+}
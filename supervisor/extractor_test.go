@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+)
+
+// Extract the fields of the line, the expected fields must match exactly
+func extractorTest(t *testing.T, name string, extractor Extractor, raw string, expected map[string]string) {
+	fields := make(map[string]string)
+	extractor.Extract(raw, fields)
+	if len(fields) != len(expected) {
+		t.Errorf("%s: expected %d fields, got %v", name, len(expected), fields)
+	}
+	for k, v := range expected {
+		if fields[k] != v {
+			t.Errorf("%s: expected %s=%q, got %q", name, k, v, fields[k])
+		}
+	}
+}
+
+func TestSyslogExtractor(t *testing.T) {
+	extractor := &SyslogExtractor{}
+	extractorTest(t, "rfc 3164", extractor, "<34>Oct 11 22:14:15 mymachine su[230]: 'su root' failed on /dev/pts/8", map[string]string{
+		"priority":  "34",
+		"timestamp": "Oct 11 22:14:15",
+		"host":      "mymachine",
+		"program":   "su",
+		"pid":       "230",
+		"message":   "'su root' failed on /dev/pts/8",
+	})
+	extractorTest(t, "rfc 3164 without priority and pid", extractor, "Oct  1 08:00:00 web nginx: GET /", map[string]string{
+		"priority":  "",
+		"timestamp": "Oct  1 08:00:00",
+		"host":      "web",
+		"program":   "nginx",
+		"pid":       "",
+		"message":   "GET /",
+	})
+	extractorTest(t, "rfc 5424", extractor, `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3"] An application event`, map[string]string{
+		"priority":  "165",
+		"timestamp": "2003-10-11T22:14:15.003Z",
+		"host":      "mymachine.example.com",
+		"program":   "evntslog",
+		"pid":       "",
+		"message":   "An application event",
+	})
+	extractorTest(t, "rfc 5424 without structured data", extractor, "<14>1 2020-01-01T00:00:00Z host app 42 - - started", map[string]string{
+		"priority":  "14",
+		"timestamp": "2020-01-01T00:00:00Z",
+		"host":      "host",
+		"program":   "app",
+		"pid":       "42",
+		"message":   "started",
+	})
+	extractorTest(t, "no syslog", extractor, "just a line", map[string]string{})
+}
+
+func TestKvExtractor(t *testing.T) {
+	extractor := &KvExtractor{}
+	extractorTest(t, "pairs", extractor, `status=200 path=/index.html user.name="John \"J\" Doe" empty= _raw=x`, map[string]string{
+		"status":    "200",
+		"path":      "/index.html",
+		"user.name": `John "J" Doe`,
+		"empty":     "",
+	})
+	extractorTest(t, "no pairs", extractor, "nothing to see here", map[string]string{})
+}
+
+func TestJsonExtractor(t *testing.T) {
+	extractor := &JsonExtractor{}
+	extractorTest(t, "after syslog header", extractor, `Oct 11 22:14:15 web app: {"status":200,"ok":true,"user":{"name":"john","id":7.5},"tags":["a","b"],"none":null,"_raw":"x"}`, map[string]string{
+		"status":    "200",
+		"ok":        "true",
+		"user.name": "john",
+		"user.id":   "7.5",
+		"tags":      `["a","b"]`,
+		"none":      "",
+	})
+	extractorTest(t, "invalid json", extractor, `app: {"status":`, map[string]string{})
+	extractorTest(t, "no json", extractor, "plain line", map[string]string{})
+}
+
+func TestFilterExtractors(t *testing.T) {
+	filter := filterFromJson([]byte(`{"id":"a","regex":"(?P<status>\\d{3})","extractors":["regex","kv"]}`))
+	if filter == nil || filter.extractorsErr != nil || len(filter.extractors) != 2 {
+		t.Fatalf("Expected 2 compiled extractors")
+	}
+	result := filter.newFilterResult("code 404 path=/x", filter.extractors)
+	if result.fields["status"] != "404" || result.fields["path"] != "/x" || result.fields["_raw"] != "code 404 path=/x" {
+		t.Errorf("Unexpected fields %v", result.fields)
+	}
+
+	invalid := filterFromJson([]byte(`{"id":"b","regex":"x","extractors":["xml"]}`))
+	if invalid == nil || invalid.extractorsErr == nil {
+		t.Errorf("Expected an error of the unsupported extractor")
+	}
+}
@@ -6,11 +6,13 @@ package main
 import (
 	"bytes"
 	"code.google.com/p/go-uuid/uuid"
-	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/boltdb/bolt"
 	"log"
+	"math"
+	"sort"
 	"sync"
 	"time"
 )
@@ -18,15 +20,14 @@ import (
 type FilterManager struct {
 	db                  *bolt.DB
 	filterTable         string
-	filterResults       map[string][]*FilterResult
-	filterResultsMux    sync.RWMutex
+	resultStore         ResultStore
 	filterStatsTable    string
-	filterStats         map[string]*FilterStats
+	timeseriesTiers     []*TimeseriesTier
 	filterOutliersTable string
 
-	// Result counters
-	filterResultCounter    map[string]uint64
-	filterResultCounterMux sync.RWMutex
+	// Result notifications, channels are closed as soon as new results arrive
+	filterResultsNotify    map[string]chan bool
+	filterResultsNotifyMux sync.Mutex
 
 	// Caches
 	filtersCache    []*Filter
@@ -47,27 +48,72 @@ type FilterTimeseries struct {
 }
 
 type Filter struct {
-	Regex      string       `json:"regex"`
-	Name       string       `json:"name"`
-	ClientHost string       `json:"client_host"`
-	Id         string       `json:"id"`
-	Stats      *FilterStats `json:"-"`
+	Regex      string   `json:"regex"`
+	Name       string   `json:"name"`
+	ClientHost string   `json:"client_host"`
+	Id         string   `json:"id"`
+	Extractors []string `json:"extractors"` // Field extractors applied at ingest time
+	// Search backend of the filter, empty uses the default backend
+	SearchBackend string `json:"search_backend,omitempty"`
 	//Results    []string `json:"results"`
-	statsMux sync.RWMutex
+
+	extractors    []Extractor // Compiled once the filter is created or loaded
+	extractorsErr error
 }
 
 func (f *Filter) Results() []*FilterResult {
-	filterManager.filterResultsMux.RLock()
-	if filterManager.filterResults[f.Id] == nil {
-		filterManager.filterResultsMux.RUnlock()
-		filterManager.filterResultsMux.Lock()
-		filterManager.filterResults[f.Id] = make([]*FilterResult, 0)
-		filterManager.filterResultsMux.Unlock()
-	} else {
-		defer filterManager.filterResultsMux.RUnlock()
+	return f.ResultsAfter(0, -1)
+}
+
+// Results with an ID above the offset, at most limit results (-1 = unlimited)
+func (f *Filter) ResultsAfter(offset uint64, limit int) []*FilterResult {
+	list, err := filterManager.resultStore.ResultsAfter(f.Id, offset, limit)
+	if err != nil {
+		log.Printf("Failed to read results of filter %s: %s", f.Id, err)
+		return make([]*FilterResult, 0)
+	}
+	return list
+}
+
+// Channel that is closed when new results are added to this filter
+func (f *Filter) ResultsNotify() <-chan bool {
+	filterManager.filterResultsNotifyMux.Lock()
+	defer filterManager.filterResultsNotifyMux.Unlock()
+	if filterManager.filterResultsNotify[f.Id] == nil {
+		filterManager.filterResultsNotify[f.Id] = make(chan bool)
 	}
+	return filterManager.filterResultsNotify[f.Id]
+}
 
-	return filterManager.filterResults[f.Id]
+// Wake up everyone waiting for new results
+func (f *Filter) notifyResults() {
+	filterManager.filterResultsNotifyMux.Lock()
+	defer filterManager.filterResultsNotifyMux.Unlock()
+	if filterManager.filterResultsNotify[f.Id] != nil {
+		close(filterManager.filterResultsNotify[f.Id])
+		delete(filterManager.filterResultsNotify, f.Id)
+	}
+}
+
+// Block until there are results above the offset, returns false on timeout or cancel
+func (f *Filter) WaitResults(offset uint64, timeout time.Duration, cancel <-chan struct{}) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		// Get the channel before looking at the results, this way we can not miss an update
+		notify := f.ResultsNotify()
+		if len(f.ResultsAfter(offset, 1)) > 0 {
+			return true
+		}
+		select {
+		case <-notify:
+			continue
+		case <-timer.C:
+			return false
+		case <-cancel:
+			return false
+		}
+	}
 }
 
 func (f *Filter) ToJson() (string, error) {
@@ -102,71 +148,73 @@ func (f *Filter) Save() bool {
 	return res
 }
 
-// @todo Support multiple adapters for storage of statistics, currently only in memory
 func (f *Filter) AddStats(metric int, timeBucket int64, count int64) bool {
-	// Lock
-	f.statsMux.Lock()
+	return filterManager.AddStats([]*TimeseriesPoint{&TimeseriesPoint{FilterId: f.Id, Metric: metric, Bucket: timeBucket, Count: count}})
+}
 
-	// Stats wrapper
-	if f.Stats == nil {
-		f.Stats = newFilterStats()
+// Store a batch of data points, possibly of multiple filters, into every tier
+func (fm *FilterManager) AddStats(points []*TimeseriesPoint) bool {
+	for _, tier := range fm.timeseriesTiers {
+		if !tier.Enabled() {
+			continue
+		}
+		err := tier.Store.Add(tier.Downsample(points))
+		if err != nil {
+			log.Printf("Failed to store %s timeseries: %s", tier.Name, err)
+			return false
+		}
 	}
-
-	// Metric wrapper?
-	if f.Stats.Metrics[metric] == nil {
-		f.Stats.Metrics[metric] = newFilterTimeseries()
+	if verbose {
+		log.Printf("Persisted %d timeseries data points", len(points))
 	}
-
-	// Store
-	f.Stats.Metrics[metric].Data[timeBucket] += count
-
-	// Persist in filter manager
-	filterManager.filterStats[f.Id] = f.Stats
-
-	// Unlock
-	f.statsMux.Unlock()
-
-	// Encode
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	f.statsMux.RLock()
-	enc.Encode(f.Stats)
-	f.statsMux.RUnlock()
-
-	// Lazy persist
-	go func(filterId string, buf bytes.Buffer) {
-		filterManager.PersistStats(filterId, buf)
-	}(f.Id, buf)
-
 	return true
 }
 
-func (fm *FilterManager) PersistStats(filterId string, buf bytes.Buffer) {
-	// Write to database
-	var wg sync.WaitGroup
-	wg.Add(1)
-	var err error = nil
-	filterManager.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(filterManager.filterStatsTable))
-		err = b.Put([]byte(filterId), buf.Bytes())
-		if err == nil {
-			if verbose {
-				log.Printf("Persisted filter %s timeseries", filterId)
-			}
+// Finest enabled tier that still holds the full window (in seconds), or the coarsest if none does
+func (fm *FilterManager) TimeseriesTierForWindow(window int64) *TimeseriesTier {
+	var tier *TimeseriesTier = nil
+	for _, t := range fm.timeseriesTiers {
+		if !t.Enabled() {
+			continue
 		}
-		wg.Done()
-		return err
-	})
-	wg.Wait()
+		tier = t
+		if t.Covers(window) {
+			break
+		}
+	}
+	return tier
 }
 
+// Tier by name
+func (fm *FilterManager) TimeseriesTier(name string) *TimeseriesTier {
+	for _, t := range fm.timeseriesTiers {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// All raw data points of this filter
 func (f *Filter) GetStats() *FilterStats {
-	f.statsMux.RLock()
-	defer f.statsMux.RUnlock()
-	return f.Stats
+	return f.GetStatsRange(filterManager.TimeseriesTier("raw"), 0, math.MaxInt64)
+}
+
+// Data points of this filter from a tier with a time bucket in [from, until)
+func (f *Filter) GetStatsRange(tier *TimeseriesTier, from int64, until int64) *FilterStats {
+	if tier == nil {
+		return newFilterStats()
+	}
+	stats, err := tier.Store.Get(f.Id, from, until)
+	if err != nil {
+		log.Printf("Failed to load %s timeseries of filter %s: %s", tier.Name, f.Id, err)
+		return newFilterStats()
+	}
+	return stats
 }
 
 type Outlier struct {
+	Id        string  `json:"id"`
 	FilterId  string  `json:"filter_id"`
 	Score     float64 `json:"score"`
 	Timestamp int64   `json:"timestamp"`
@@ -218,42 +266,13 @@ func (fm *FilterManager) TruncateOutliers() bool {
 // Remove all stats
 func (fm *FilterManager) TruncateStats() bool {
 	log.Println("Truncating stats")
-
-	keys := make([]string, 0)
-	var wg sync.WaitGroup
-	wg.Add(1)
-	fm.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(fm.filterStatsTable))
-		c := b.Cursor()
-
-		for k, _ := c.First(); k != nil; k, _ = c.Next() {
-			keys = append(keys, fmt.Sprintf("%s", k))
+	for _, tier := range fm.timeseriesTiers {
+		err := tier.Store.Truncate()
+		if err != nil {
+			log.Printf("Failed to truncate %s stats: %s", tier.Name, err)
+			return false
 		}
-		wg.Done()
-		return nil
-	})
-	wg.Wait()
-
-	// Remove keys
-	var wgrm sync.WaitGroup
-	deleteCount := 0
-	for _, key := range keys {
-		wgrm.Add(1)
-		// Remove key
-		fm.db.Update(func(tx *bolt.Tx) error {
-			b := tx.Bucket([]byte(fm.filterStatsTable))
-			err := b.Delete([]byte(key))
-			if err != nil {
-				log.Printf("Failed to remove stat: %s", err)
-			}
-			deleteCount++
-			wgrm.Done()
-			return nil
-		})
-		wgrm.Wait()
 	}
-	log.Printf("Removed %d stats", deleteCount)
-
 	return true
 }
 
@@ -264,6 +283,7 @@ func (f *Filter) AddOutlier(ts int64, score float64, details string) bool {
 
 	// Struct
 	outlier := &Outlier{}
+	outlier.Id = id
 	outlier.FilterId = f.Id
 	outlier.Timestamp = ts
 	outlier.Score = score
@@ -294,64 +314,96 @@ func (f *Filter) AddOutlier(ts int64, score float64, details string) bool {
 	return err == nil
 }
 
-// New result for this filter
-func (f *Filter) newFilterResult(raw string) *FilterResult {
-	// Get auto-increment key
-	filterManager.filterResultCounterMux.Lock()
-	filterManager.filterResultCounter[f.Id]++
-	id := filterManager.filterResultCounter[f.Id]
-	filterManager.filterResultCounterMux.Unlock()
+// Outliers with a timestamp in [from, until) and at least the minimum score, newest first, at most limit outliers (negative = unlimited)
+func (f *Filter) Outliers(from int64, until int64, minScore float64, limit int) []*Outlier {
+	list := make([]*Outlier, 0)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	filterManager.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(filterManager.filterOutliersTable))
+		c := b.Cursor()
 
-	// Init
-	elm := &FilterResult{
-		id:     id,
-		fields: make(map[string]string),
+		// Prefix scan on f-<filterid>-
+		prefix := []byte(fmt.Sprintf("f-%s-", f.Id))
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			outlier := &Outlier{}
+			if err := json.Unmarshal(v, outlier); err != nil {
+				log.Printf("Failed to read outlier %s: %s", k, err)
+				continue
+			}
+			if outlier.Timestamp < from || outlier.Timestamp >= until || outlier.Score < minScore {
+				continue
+			}
+			// Older outliers do not have the ID in their body
+			outlier.Id = string(k[len(prefix):])
+			list = append(list, outlier)
+		}
+		wg.Done()
+		return nil
+	})
+	wg.Wait()
+
+	// Newest first
+	sort.Sort(OutliersByTimestamp(list))
+	if limit >= 0 && len(list) > limit {
+		list = list[:limit]
 	}
-	elm.fields["_raw"] = raw
-	return elm
+	return list
 }
 
-// @todo Support multiple adapters for storage of results, currently only in memory
-func (f *Filter) AddResults(res []string) bool {
-	filterManager.filterResultsMux.Lock()
+type OutliersByTimestamp []*Outlier
 
-	// Init variable
-	if filterManager.filterResults[f.Id] == nil {
-		filterManager.filterResults[f.Id] = make([]*FilterResult, 0)
-	}
+func (a OutliersByTimestamp) Len() int           { return len(a) }
+func (a OutliersByTimestamp) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a OutliersByTimestamp) Less(i, j int) bool { return a[i].Timestamp > a[j].Timestamp }
 
-	// Exceed limit?
-	newCount := len(res)
-	currentCount := len(filterManager.filterResults[f.Id])
-	newPlusCurrent := newCount + currentCount
-	if newPlusCurrent > maxMsgMemory {
-		tooMany := newPlusCurrent - maxMsgMemory
-		if verbose {
-			log.Printf("Truncating memory for filter %s, exceeding limit of %d messages. Before %d. New %d. Too many %d", f.Id, maxMsgMemory, currentCount, newCount, tooMany)
+// Compile the extractors configured for this filter, see compileExtractors for the cached ones
+func (f *Filter) newExtractors() ([]Extractor, error) {
+	list := make([]Extractor, 0)
+	for _, name := range f.Extractors {
+		extractor, err := newExtractor(name, f)
+		if err != nil {
+			return nil, err
 		}
-		tmp := make([]*FilterResult, 0)
-		for i := tooMany; i < currentCount-1; i++ {
-			// Debug out of bounds
-			if i < 0 || i > currentCount-1 {
-				if verbose {
-					log.Printf("I %d out of bounds", i)
-				}
-				continue
-			}
+		list = append(list, extractor)
+	}
+	return list, nil
+}
 
-			// Append to TMP array
-			tmp = append(tmp, filterManager.filterResults[f.Id][i])
-		}
-		filterManager.filterResults[f.Id] = tmp
+// Compile the extractors once, in case they are invalid only the raw lines are stored
+func (f *Filter) compileExtractors() {
+	f.extractors, f.extractorsErr = f.newExtractors()
+	if f.extractorsErr != nil {
+		log.Printf("Invalid extractors of filter %s: %s", f.Id, f.extractorsErr)
 	}
+}
 
-	// Add lines
+// New result for this filter, the ID is assigned by the result store
+func (f *Filter) newFilterResult(raw string, extractors []Extractor) *FilterResult {
+	elm := &FilterResult{
+		fields: make(map[string]string),
+	}
+	for _, extractor := range extractors {
+		extractor.Extract(raw, elm.fields)
+	}
+	elm.fields["_raw"] = raw
+	return elm
+}
+
+func (f *Filter) AddResults(res []string) bool {
 	// @todo It is possible that there is a big resultset immediately overflow maxMsgMemory
+	results := make([]*FilterResult, 0)
 	for _, line := range res {
-		res := f.newFilterResult(line)
-		filterManager.filterResults[f.Id] = append(filterManager.filterResults[f.Id], res)
+		results = append(results, f.newFilterResult(line, f.extractors))
 	}
-	filterManager.filterResultsMux.Unlock()
+	err := filterManager.resultStore.Append(f.Id, results)
+	if err != nil {
+		log.Printf("Failed to store results of filter %s: %s", f.Id, err)
+		return false
+	}
+
+	// Wake up long-polling and streaming clients
+	f.notifyResults()
 	return true
 }
 
@@ -375,15 +427,6 @@ func (fm *FilterManager) Open() {
 		return nil
 	})
 	wg.Add(1)
-	fm.db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(fm.filterStatsTable))
-		if err != nil {
-			log.Fatal(fmt.Errorf("create bucket: %s", err))
-		}
-		wg.Done()
-		return nil
-	})
-	wg.Add(1)
 	fm.db.Update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists([]byte(fm.filterOutliersTable))
 		if err != nil {
@@ -435,60 +478,13 @@ func (fm *FilterManager) GetFilters() []*Filter {
 }
 
 func (fm *FilterManager) GetFilter(id string) *Filter {
-	// Load from cache
-	fm.filtersCacheMux.RLock()
-	defer fm.filtersCacheMux.RUnlock()
-	for _, filter := range fm.filtersCache {
+	// Load from cache, filters are loaded once this way (with their compiled extractors)
+	for _, filter := range fm.GetFilters() {
 		if filter.Id == id {
 			return filter
 		}
 	}
-
-	// Load from db
-	var wg sync.WaitGroup
-	var elm *Filter = nil
-	wg.Add(1)
-	// Load filter
-	fm.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(fm.filterTable))
-		res := b.Get([]byte(id))
-		elm = filterFromJson(res)
-		wg.Done()
-		return nil
-	})
-	// Load filter stats
-	wg.Add(1)
-	var stats *FilterStats
-	fm.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(fm.filterStatsTable))
-		res := b.Get([]byte(id))
-		if res != nil {
-			var buf bytes.Buffer
-			buf.Write(res)
-			dec := gob.NewDecoder(&buf)
-			de := dec.Decode(&stats)
-			if de != nil {
-				stats = nil
-				log.Printf("Failed to load timeseries %s", de)
-			}
-		}
-		wg.Done()
-		return nil
-	})
-	wg.Wait()
-
-	// Elm found?
-	if elm == nil {
-		return nil
-	}
-
-	// Load stats
-	if stats != nil {
-		elm.Stats = stats
-	} else {
-		elm.Stats = newFilterStats()
-	}
-	return elm
+	return nil
 }
 
 func (fm *FilterManager) DeleteFilter(id string) bool {
@@ -508,6 +504,21 @@ func (fm *FilterManager) DeleteFilter(id string) bool {
 	})
 	wg.Wait()
 
+	// Remove results
+	if val {
+		if err := fm.resultStore.Remove(id); err != nil {
+			log.Printf("Failed to remove results of filter %s: %s", id, err)
+		}
+		for _, tier := range fm.timeseriesTiers {
+			if err := tier.Store.Remove(id); err != nil {
+				log.Printf("Failed to remove %s timeseries of filter %s: %s", tier.Name, id, err)
+			}
+		}
+		if alertManager != nil {
+			alertManager.DeleteAlertsOf(id)
+		}
+	}
+
 	// Invalidate cache
 	fm.filtersCacheMux.Lock()
 	fm.filtersCache = nil
@@ -516,57 +527,37 @@ func (fm *FilterManager) DeleteFilter(id string) bool {
 	return val
 }
 
-// This will cleanup the timeseries database every once in a while
+// This will cleanup the timeseries database every once in a while, every tier has its own retention
 func (fm *FilterManager) TimeseriesCleaner() {
 	go func() {
-		c := time.Tick(5 * time.Minute)
-		for _ = range c {
+		for {
+			// Interval is read every time, this allows changing it at runtime
+			interval := conf.GetIntOrDefault("stats_cleaner_interval_minutes", 5)
+			if interval < 1 {
+				interval = 1
+			}
+			time.Sleep(time.Duration(interval) * time.Minute)
+
 			if verbose {
 				log.Println("Cleaning timeseries database")
 			}
 
-			// Scan keys
-			fm.db.View(func(tx *bolt.Tx) error {
-				b := tx.Bucket([]byte(fm.filterStatsTable))
-				c := b.Cursor()
-
-				// Iterate
-				for k, v := c.First(); k != nil; k, v = c.Next() {
-					// Read data
-					var stats *FilterStats
-					var buf bytes.Buffer
-					buf.Write(v)
-					dec := gob.NewDecoder(&buf)
-					de := dec.Decode(&stats)
-					nowUnix := time.Now().Unix()
-					maxUnixAge := nowUnix - (7 * 86400)
-					dirty := false
-					if de == nil && stats != nil {
-						for _, timeseries := range stats.Metrics {
-							for ts, _ := range timeseries.Data {
-								if ts < maxUnixAge {
-									delete(timeseries.Data, ts)
-									dirty = true
-									if verbose {
-										log.Printf("Filter %s is dirty", string(k))
-									}
-								}
-							}
-						}
-					}
-
-					// Store (async, else it will block with the read transaction)
-					if dirty {
-						var writeBuf bytes.Buffer
-						enc := gob.NewEncoder(&writeBuf)
-						enc.Encode(stats)
-						go func(filterId string, writeBuf bytes.Buffer) {
-							filterManager.PersistStats(filterId, writeBuf)
-						}(string(k), writeBuf)
-					}
+			// Remove data points that passed the retention of their tier
+			nowUnix := time.Now().Unix()
+			for _, tier := range fm.timeseriesTiers {
+				if tier.Retention() == 0 {
+					continue // Kept forever
+				}
+				maxUnixAge := nowUnix - tier.Retention()
+				removed, err := tier.Store.RemoveBefore(maxUnixAge)
+				if err != nil {
+					log.Printf("Failed to clean %s timeseries: %s", tier.Name, err)
+					continue
 				}
-				return nil
-			})
+				if verbose {
+					log.Printf("Cleaned %s timeseries, removed %d data points", tier.Name, removed)
+				}
+			}
 
 			if verbose {
 				log.Println("Cleaned timeseries database")
@@ -576,13 +567,26 @@ func (fm *FilterManager) TimeseriesCleaner() {
 }
 
 // Create a new filter
-func (fm *FilterManager) CreateFilter(name string, clientHost string, regex string) (string, error) {
+func (fm *FilterManager) CreateFilter(name string, clientHost string, regex string, extractors []string, searchBackend string) (string, error) {
 	var id string = uuid.New()
 	var filter *Filter = newFilter()
 	filter.Regex = regex
 	filter.Name = name
 	filter.ClientHost = clientHost
 	filter.Id = id
+	filter.Extractors = extractors
+	filter.SearchBackend = searchBackend
+
+	// Validate extractors
+	filter.compileExtractors()
+	if filter.extractorsErr != nil {
+		return "", filter.extractorsErr
+	}
+
+	// Validate search backend
+	if len(searchBackend) > 0 && !isSearchBackend(searchBackend) {
+		return "", errors.New(fmt.Sprintf("Unknown search backend %s", searchBackend))
+	}
 
 	// To JSON
 	json, jsonErr := filter.ToJson()
@@ -620,6 +624,7 @@ func filterFromJson(b []byte) *Filter {
 		log.Printf("Failed json umarshal %s", err)
 		return nil
 	}
+	f.compileExtractors()
 	return f
 }
 
@@ -629,19 +634,32 @@ func NewFilterManager() *FilterManager {
 		filterTable:         "filters",
 		filterStatsTable:    "filter_stats",
 		filterOutliersTable: "filter_outliers",
-		filterResults:       make(map[string][]*FilterResult),
-		filterStats:         make(map[string]*FilterStats),
-		filterResultCounter: make(map[string]uint64),
+		filterResultsNotify: make(map[string]chan bool),
 	}
 	fm.Open()
+
+	// Result storage
+	resultStore, err := newResultStore(resultStoreType, fm.db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fm.resultStore = resultStore
+	log.Printf("Storing results in %s", resultStoreType)
+
+	// Timeseries storage, older versions stored a blob per filter in the stats table
+	timeseriesTiers, err := newTimeseriesTiers(statsStoreType, fm.db, fm.filterStatsTable)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fm.timeseriesTiers = timeseriesTiers
+	log.Printf("Storing timeseries in %s", statsStoreType)
+
 	fm.TimeseriesCleaner()
 	return fm
 }
 
 func newFilter() *Filter {
-	return &Filter{
-		Stats: newFilterStats(),
-	}
+	return &Filter{}
 }
 
 func newFilterStats() *FilterStats {
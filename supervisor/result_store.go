@@ -0,0 +1,282 @@
+// Storage of filter results
+// - memory: ring per filter, lost on restart (the offsets are kept in BoltDB)
+// - bolt: segments per filter in BoltDB, survives restarts
+// @author Robin Verlangen
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"log"
+	"sync"
+)
+
+const RESULT_ID_BLOCK_SIZE uint64 = 10000 // IDs the memory store reserves per write of the last ID
+
+type ResultStore interface {
+	// Store results, the store assigns the (monotonic) result IDs
+	Append(filterId string, results []*FilterResult) error
+
+	// Results with an ID above the offset, at most limit results (-1 = unlimited)
+	ResultsAfter(filterId string, offset uint64, limit int) ([]*FilterResult, error)
+
+	// Remove all results of a filter
+	Remove(filterId string) error
+}
+
+// In memory result store, the last reserved ID of each filter is kept in BoltDB (<table> => <filter id> => last reserved ID)
+// this keeps offsets monotonic across restarts, clients do not miss the results after a restart
+// IDs are reserved in blocks, BoltDB is only written once a block runs out and after a restart the IDs continue after the block
+type MemoryResultStore struct {
+	results    map[string][]*FilterResult
+	counters   map[string]uint64
+	reserved   map[string]uint64 // Last reserved ID
+	mux        sync.RWMutex
+	maxResults int
+	db         *bolt.DB
+	table      string
+}
+
+func (s *MemoryResultStore) Append(filterId string, results []*FilterResult) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	// Assign IDs, continue after the reserved block of before a restart
+	counter, found := s.counters[filterId]
+	reserved := s.reserved[filterId]
+	if !found {
+		err := s.db.View(func(tx *bolt.Tx) error {
+			if v := tx.Bucket([]byte(s.table)).Get([]byte(filterId)); v != nil {
+				counter = binary.BigEndian.Uint64(v)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		reserved = counter
+	}
+	if last := counter + uint64(len(results)); last > reserved {
+		reserved = last + RESULT_ID_BLOCK_SIZE
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte(s.table)).Put([]byte(filterId), uint64Key(reserved))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, result := range results {
+		counter++
+		result.id = counter
+	}
+	s.counters[filterId] = counter
+	s.reserved[filterId] = reserved
+	list := append(s.results[filterId], results...)
+
+	// Exceed limit? Keep the newest results
+	if len(list) > s.maxResults {
+		tooMany := len(list) - s.maxResults
+		if verbose {
+			log.Printf("Truncating memory for filter %s, exceeding limit of %d messages. Too many %d", filterId, s.maxResults, tooMany)
+		}
+		tmp := make([]*FilterResult, s.maxResults)
+		copy(tmp, list[tooMany:])
+		list = tmp
+	}
+	s.results[filterId] = list
+	return nil
+}
+
+func (s *MemoryResultStore) ResultsAfter(filterId string, offset uint64, limit int) ([]*FilterResult, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	list := make([]*FilterResult, 0)
+	for _, result := range s.results[filterId] {
+		// Skip all below or equal to offset
+		if result.id <= offset {
+			continue
+		}
+		list = append(list, result)
+		if limit != -1 && len(list) >= limit {
+			break
+		}
+	}
+	return list, nil
+}
+
+func (s *MemoryResultStore) Remove(filterId string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.results, filterId)
+	delete(s.counters, filterId)
+	delete(s.reserved, filterId)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(s.table)).Delete([]byte(filterId))
+	})
+}
+
+// BoltDB result store, layout: <table> => <filter id> => <segment number> => <result id> => fields JSON
+// The sequence of the filter bucket is used for the result IDs, this keeps offsets monotonic across restarts
+type BoltResultStore struct {
+	db          *bolt.DB
+	table       string
+	segmentSize uint64
+	maxSegments int
+}
+
+func (s *BoltResultStore) Append(filterId string, results []*FilterResult) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		fb, err := tx.Bucket([]byte(s.table)).CreateBucketIfNotExists([]byte(filterId))
+		if err != nil {
+			return err
+		}
+		for _, result := range results {
+			// Assign ID
+			id, err := fb.NextSequence()
+			if err != nil {
+				return err
+			}
+			result.id = id
+
+			// Segment
+			sb, err := fb.CreateBucketIfNotExists(uint64Key(id / s.segmentSize))
+			if err != nil {
+				return err
+			}
+
+			// Store
+			b, err := json.Marshal(result.fields)
+			if err != nil {
+				return err
+			}
+			if err := sb.Put(uint64Key(id), b); err != nil {
+				return err
+			}
+		}
+		return s.truncate(fb)
+	})
+}
+
+// Remove the oldest segments in case there are too many
+func (s *BoltResultStore) truncate(fb *bolt.Bucket) error {
+	segments := make([][]byte, 0)
+	c := fb.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v == nil {
+			segments = append(segments, k)
+		}
+	}
+	for i := 0; i < len(segments)-s.maxSegments; i++ {
+		if verbose {
+			log.Printf("Removing result segment %d", binary.BigEndian.Uint64(segments[i]))
+		}
+		if err := fb.DeleteBucket(segments[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BoltResultStore) ResultsAfter(filterId string, offset uint64, limit int) ([]*FilterResult, error) {
+	list := make([]*FilterResult, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		fb := tx.Bucket([]byte(s.table)).Bucket([]byte(filterId))
+		if fb == nil {
+			return nil
+		}
+
+		// Seek to the segment that holds the first result we need
+		start := uint64Key(offset + 1)
+		c := fb.Cursor()
+		for k, v := c.Seek(uint64Key((offset + 1) / s.segmentSize)); k != nil; k, v = c.Next() {
+			if v != nil {
+				continue // Not a segment
+			}
+			sc := fb.Bucket(k).Cursor()
+			for rk, rv := sc.Seek(start); rk != nil; rk, rv = sc.Next() {
+				result := &FilterResult{
+					id:     binary.BigEndian.Uint64(rk),
+					fields: make(map[string]string),
+				}
+				if err := json.Unmarshal(rv, &result.fields); err != nil {
+					return errors.New(fmt.Sprintf("Corrupt result %d of filter %s: %s", result.id, filterId, err))
+				}
+				list = append(list, result)
+				if limit != -1 && len(list) >= limit {
+					return nil
+				}
+			}
+		}
+		return nil
+	})
+	return list, err
+}
+
+func (s *BoltResultStore) Remove(filterId string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte(s.table)).DeleteBucket([]byte(filterId))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// Big endian keys keep the byte order equal to the numeric order
+func uint64Key(i uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, i)
+	return b
+}
+
+func newMemoryResultStore(db *bolt.DB, table string, maxResults int) (*MemoryResultStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(table))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &MemoryResultStore{
+		results:    make(map[string][]*FilterResult),
+		counters:   make(map[string]uint64),
+		reserved:   make(map[string]uint64),
+		maxResults: maxResults,
+		db:         db,
+		table:      table,
+	}, nil
+}
+
+func newBoltResultStore(db *bolt.DB, table string, segmentSize int, maxResults int) (*BoltResultStore, error) {
+	if segmentSize < 1 {
+		return nil, errors.New("Result segment size must be positive")
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(table))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltResultStore{
+		db:          db,
+		table:       table,
+		segmentSize: uint64(segmentSize),
+		maxSegments: maxResults/segmentSize + 1,
+	}, nil
+}
+
+// Result store based on the type passed in the flags
+func newResultStore(storeType string, db *bolt.DB) (ResultStore, error) {
+	switch storeType {
+	case "memory":
+		return newMemoryResultStore(db, "filter_result_offsets", maxMsgMemory)
+	case "bolt":
+		return newBoltResultStore(db, "filter_results", resultSegmentSize, maxMsgDisk)
+	}
+	return nil, errors.New(fmt.Sprintf("Unsupported result store %s", storeType))
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/binary"
+	"github.com/boltdb/bolt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Temporary BoltDB, the returned function closes and removes it
+func resultStoreTestDb(t *testing.T) (*bolt.DB, string, func()) {
+	dir, err := ioutil.TempDir("", "results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "test.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, path, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func resultStoreTestResults(n int) []*FilterResult {
+	list := make([]*FilterResult, n)
+	for i := range list {
+		list[i] = &FilterResult{fields: map[string]string{"_raw": "line"}}
+	}
+	return list
+}
+
+// IDs of the results after the offset, fails the test on errors
+func resultStoreTestIds(t *testing.T, store ResultStore, filterId string, offset uint64, limit int) []uint64 {
+	list, err := store.ResultsAfter(filterId, offset, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]uint64, len(list))
+	for i, result := range list {
+		ids[i] = result.id
+		if result.fields["_raw"] != "line" {
+			t.Errorf("Unexpected fields %v of result %d", result.fields, result.id)
+		}
+	}
+	return ids
+}
+
+func resultStoreTestEqual(a []uint64, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMemoryResultStoreOffsets(t *testing.T) {
+	db, _, done := resultStoreTestDb(t)
+	defer done()
+	reserved := func() uint64 {
+		var last uint64
+		db.View(func(tx *bolt.Tx) error {
+			if v := tx.Bucket([]byte("filter_result_offsets")).Get([]byte("a")); v != nil {
+				last = binary.BigEndian.Uint64(v)
+			}
+			return nil
+		})
+		return last
+	}
+
+	store, err := newMemoryResultStore(db, "filter_result_offsets", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Append("a", resultStoreTestResults(3)); err != nil {
+		t.Fatal(err)
+	}
+	if last := reserved(); last != 3+RESULT_ID_BLOCK_SIZE {
+		t.Errorf("Expected IDs up to %d to be reserved, got %d", 3+RESULT_ID_BLOCK_SIZE, last)
+	}
+
+	// Within the block nothing is written
+	if err := store.Append("a", resultStoreTestResults(2)); err != nil {
+		t.Fatal(err)
+	}
+	if last := reserved(); last != 3+RESULT_ID_BLOCK_SIZE {
+		t.Errorf("Expected the reservation to stay at %d, got %d", 3+RESULT_ID_BLOCK_SIZE, last)
+	}
+	if ids := resultStoreTestIds(t, store, "a", 3, -1); !resultStoreTestEqual(ids, []uint64{4, 5}) {
+		t.Errorf("Expected results 4 and 5, got %v", ids)
+	}
+
+	// A batch beyond the block reserves the next block
+	if err := store.Append("a", resultStoreTestResults(int(RESULT_ID_BLOCK_SIZE))); err != nil {
+		t.Fatal(err)
+	}
+	if last := reserved(); last != 5+2*RESULT_ID_BLOCK_SIZE {
+		t.Errorf("Expected IDs up to %d to be reserved, got %d", 5+2*RESULT_ID_BLOCK_SIZE, last)
+	}
+
+	// Restart, the IDs continue after the reserved block
+	store, err = newMemoryResultStore(db, "filter_result_offsets", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Append("a", resultStoreTestResults(2)); err != nil {
+		t.Fatal(err)
+	}
+	first := 5 + 2*RESULT_ID_BLOCK_SIZE + 1
+	if ids := resultStoreTestIds(t, store, "a", 5+RESULT_ID_BLOCK_SIZE, -1); !resultStoreTestEqual(ids, []uint64{first, first + 1}) {
+		t.Errorf("Expected results %d and %d after a restart, got %v", first, first+1, ids)
+	}
+
+	// Removed filters start over
+	if err := store.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	if last := reserved(); last != 0 {
+		t.Errorf("Expected no reservation after removing the filter, got %d", last)
+	}
+	if err := store.Append("a", resultStoreTestResults(1)); err != nil {
+		t.Fatal(err)
+	}
+	if ids := resultStoreTestIds(t, store, "a", 0, -1); !resultStoreTestEqual(ids, []uint64{1}) {
+		t.Errorf("Expected result 1 after removing the filter, got %v", ids)
+	}
+}
+
+func TestBoltResultStore(t *testing.T) {
+	db, path, done := resultStoreTestDb(t)
+	defer done()
+
+	// Segments of 4 results, at most 2 full segments are kept besides the current one
+	store, err := newBoltResultStore(db, "filter_results", 4, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Append("a", resultStoreTestResults(6)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Append("b", resultStoreTestResults(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Across segments (0-3, 4-7), with limit
+	if ids := resultStoreTestIds(t, store, "a", 2, -1); !resultStoreTestEqual(ids, []uint64{3, 4, 5, 6}) {
+		t.Errorf("Expected results 3 to 6, got %v", ids)
+	}
+	if ids := resultStoreTestIds(t, store, "a", 2, 2); !resultStoreTestEqual(ids, []uint64{3, 4}) {
+		t.Errorf("Expected results 3 and 4, got %v", ids)
+	}
+	if ids := resultStoreTestIds(t, store, "a", 3, -1); !resultStoreTestEqual(ids, []uint64{4, 5, 6}) {
+		t.Errorf("Expected results 4 to 6, got %v", ids)
+	}
+	if ids := resultStoreTestIds(t, store, "a", 6, -1); len(ids) != 0 {
+		t.Errorf("Expected no results after the last, got %v", ids)
+	}
+	if ids := resultStoreTestIds(t, store, "unknown", 0, -1); len(ids) != 0 {
+		t.Errorf("Expected no results of an unknown filter, got %v", ids)
+	}
+
+	// Reopen, the IDs continue
+	db.Close()
+	db, err = bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store, err = newBoltResultStore(db, "filter_results", 4, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Append("a", resultStoreTestResults(7)); err != nil {
+		t.Fatal(err)
+	}
+	if ids := resultStoreTestIds(t, store, "b", 0, -1); !resultStoreTestEqual(ids, []uint64{1}) {
+		t.Errorf("Expected result 1 of filter b, got %v", ids)
+	}
+
+	// Truncated: results 7-13 are in segments 1-3, segment 0 (results 1-3) is removed
+	if ids := resultStoreTestIds(t, store, "a", 0, -1); !resultStoreTestEqual(ids, []uint64{4, 5, 6, 7, 8, 9, 10, 11, 12, 13}) {
+		t.Errorf("Expected results 4 to 13 after truncation, got %v", ids)
+	}
+
+	// Seek past the removed segment
+	if ids := resultStoreTestIds(t, store, "a", 1, 2); !resultStoreTestEqual(ids, []uint64{4, 5}) {
+		t.Errorf("Expected results 4 and 5 after the removed segment, got %v", ids)
+	}
+
+	// Removed filters start over, other filters are kept
+	if err := store.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Remove("a"); err != nil {
+		t.Errorf("Expected removing a removed filter to succeed, got %s", err)
+	}
+	if ids := resultStoreTestIds(t, store, "a", 0, -1); len(ids) != 0 {
+		t.Errorf("Expected no results after removing the filter, got %v", ids)
+	}
+	if err := store.Append("a", resultStoreTestResults(1)); err != nil {
+		t.Fatal(err)
+	}
+	if ids := resultStoreTestIds(t, store, "a", 0, -1); !resultStoreTestEqual(ids, []uint64{1}) {
+		t.Errorf("Expected result 1 after removing the filter, got %v", ids)
+	}
+	if ids := resultStoreTestIds(t, store, "b", 0, -1); !resultStoreTestEqual(ids, []uint64{1}) {
+		t.Errorf("Expected result 1 of filter b after removing filter a, got %v", ids)
+	}
+}
@@ -1,4 +0,0 @@
-#!/bin/bash
-CMD="../cli/cli -e $1"
-echo $CMD
-bash -c "$CMD"
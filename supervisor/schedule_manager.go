@@ -0,0 +1,412 @@
+// Schedule manager
+// - Named commands that are executed in the background on a cron expression (see cron.go), stored in BoltDB next to the filters
+// - Commands are executed in-process with the console of the Slack integration, limited to the role of the creator of the schedule
+//   at the time of the run, schedules are removed together with their creator
+// - The output is posted to a Slack channel (#channel or @user, uses the incoming webhook) or a HTTP webhook (JSON POST)
+// - The history of the runs is kept per schedule, layout: <run table> => <schedule id> => <run sequence> => run JSON
+// @author Robin Verlangen
+
+package main
+
+import (
+	"bytes"
+	"code.google.com/p/go-uuid/uuid"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const SCHEDULE_RUN_OK string = "ok"
+const SCHEDULE_RUN_TIMEOUT string = "timeout"
+const SCHEDULE_RUN_FAILED string = "failed"
+const SCHEDULE_DEFAULT_COMMAND_TIMEOUT int64 = 60
+const SCHEDULE_DEFAULT_HISTORY_SIZE int64 = 100
+
+type ScheduleManager struct {
+	db            *bolt.DB
+	scheduleTable string
+	runTable      string
+	running       map[string]bool
+	runningMux    sync.Mutex
+}
+
+type Schedule struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Cron        string `json:"cron"`
+	Command     string `json:"command"`
+	Destination string `json:"destination"` // Slack channel (#channel, @user) or webhook URL
+	Paused      bool   `json:"paused"`
+	Role        string `json:"role"` // Role of the creator, commands are executed with this role at most
+	Creator     string `json:"creator"`
+	Created     int64  `json:"created"`
+	LastRun     int64  `json:"last_run"` // Unix timestamp of the start of the last run
+	LastStatus  string `json:"last_status"`
+	NextRun     int64  `json:"next_run"` // Unix timestamp, not stored
+}
+
+type ScheduleRun struct {
+	Started  int64  `json:"started"`  // Unix timestamp
+	Duration int64  `json:"duration"` // Milliseconds
+	Status   string `json:"status"`   // ok, timeout, failed
+	Error    string `json:"error"`
+	Messages int    `json:"messages"` // Slack messages or webhook requests posted
+}
+
+// Output is posted to Slack, otherwise to a webhook
+func (s *Schedule) IsSlack() bool {
+	return strings.HasPrefix(s.Destination, "#") || strings.HasPrefix(s.Destination, "@")
+}
+
+// Role the commands are executed with, never above the current role of the creator, empty in case the creator no longer exists
+func (s *Schedule) CurrentRole() string {
+	role := userManager.GetRole(s.Creator)
+	if roleLevel(role) > roleLevel(s.Role) {
+		return s.Role
+	}
+	return role
+}
+
+func (s *Schedule) Validate() error {
+	if ok, _ := regexp.MatchString("^[a-zA-Z0-9_.-]+$", s.Name); !ok {
+		return errors.New("Please provide a valid name (letters, digits, _ . -)")
+	}
+	if _, err := parseCron(s.Cron); err != nil {
+		return err
+	}
+	if len(strings.TrimSpace(s.Command)) < 1 {
+		return errors.New("Please provide a command")
+	}
+	if err := checkSlackCommands(s.Command, s.Role); err != nil {
+		return err
+	}
+	if !s.IsSlack() {
+		u, err := url.ParseRequestURI(s.Destination)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return errors.New("Please provide a Slack channel (#channel, @user) or a valid webhook URL")
+		}
+	}
+	return nil
+}
+
+func (sm *ScheduleManager) CreateSchedule(schedule *Schedule) (string, error) {
+	// Validate
+	if err := schedule.Validate(); err != nil {
+		return "", err
+	}
+	for _, existing := range sm.GetSchedules() {
+		if existing.Name == schedule.Name {
+			return "", errors.New(fmt.Sprintf("There already exist a schedule with the name %s", schedule.Name))
+		}
+	}
+
+	// Init
+	schedule.Id = uuid.New()
+	schedule.Created = time.Now().Unix()
+	schedule.LastRun = 0
+	schedule.LastStatus = ""
+	if err := sm.saveSchedule(schedule); err != nil {
+		return "", err
+	}
+	log.Printf("Created schedule %s", schedule.Id)
+	return schedule.Id, nil
+}
+
+func (sm *ScheduleManager) saveSchedule(schedule *Schedule) error {
+	b, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+	return sm.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(sm.scheduleTable)).Put([]byte(schedule.Id), b)
+	})
+}
+
+// Change the stored schedule within a single transaction, schedules that were removed are not restored
+func (sm *ScheduleManager) updateSchedule(tx *bolt.Tx, id string, update func(schedule *Schedule)) error {
+	bucket := tx.Bucket([]byte(sm.scheduleTable))
+	v := bucket.Get([]byte(id))
+	if v == nil {
+		return errors.New(fmt.Sprintf("Schedule %s not found", id))
+	}
+	schedule := &Schedule{}
+	if err := json.Unmarshal(v, schedule); err != nil {
+		return err
+	}
+	update(schedule)
+	b, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(id), b)
+}
+
+func (sm *ScheduleManager) GetSchedules() []*Schedule {
+	list := make([]*Schedule, 0)
+	sm.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(sm.scheduleTable)).ForEach(func(k []byte, v []byte) error {
+			schedule := &Schedule{}
+			if err := json.Unmarshal(v, schedule); err != nil {
+				log.Printf("Failed json umarshal %s", err)
+				return nil
+			}
+			list = append(list, schedule)
+			return nil
+		})
+	})
+	return list
+}
+
+func (sm *ScheduleManager) GetSchedule(id string) *Schedule {
+	for _, schedule := range sm.GetSchedules() {
+		if schedule.Id == id {
+			return schedule
+		}
+	}
+	return nil
+}
+
+func (sm *ScheduleManager) SetPaused(id string, paused bool) error {
+	return sm.db.Update(func(tx *bolt.Tx) error {
+		return sm.updateSchedule(tx, id, func(schedule *Schedule) {
+			schedule.Paused = paused
+		})
+	})
+}
+
+// Remove the schedule and its history
+func (sm *ScheduleManager) DeleteSchedule(id string) bool {
+	err := sm.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte(sm.runTable)).DeleteBucket([]byte(id)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return tx.Bucket([]byte(sm.scheduleTable)).Delete([]byte(id))
+	})
+	if err != nil {
+		log.Printf("Failed to remove schedule %s: %s", id, err)
+		return false
+	}
+	return true
+}
+
+// Remove the schedules of a creator, returns the amount of removed schedules
+func (sm *ScheduleManager) DeleteSchedulesOf(creator string) int {
+	removed := 0
+	for _, schedule := range sm.GetSchedules() {
+		if schedule.Creator == creator && sm.DeleteSchedule(schedule.Id) {
+			removed++
+		}
+	}
+	return removed
+}
+
+// Runs of the schedule, most recent first
+func (sm *ScheduleManager) GetRuns(id string, limit int) []*ScheduleRun {
+	list := make([]*ScheduleRun, 0)
+	sm.db.View(func(tx *bolt.Tx) error {
+		rb := tx.Bucket([]byte(sm.runTable)).Bucket([]byte(id))
+		if rb == nil {
+			return nil
+		}
+		c := rb.Cursor()
+		for k, v := c.Last(); k != nil && (limit < 1 || len(list) < limit); k, v = c.Prev() {
+			run := &ScheduleRun{}
+			if err := json.Unmarshal(v, run); err != nil {
+				log.Printf("Failed json umarshal %s", err)
+				continue
+			}
+			list = append(list, run)
+		}
+		return nil
+	})
+	return list
+}
+
+// Store the run, the oldest runs are removed once the history exceeds schedule_history_size
+func (sm *ScheduleManager) saveRun(schedule *Schedule, run *ScheduleRun) error {
+	b, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	historySize := conf.GetIntOrDefault("schedule_history_size", SCHEDULE_DEFAULT_HISTORY_SIZE)
+	return sm.db.Update(func(tx *bolt.Tx) error {
+		// Last run, schedules that were removed during the run are skipped
+		err := sm.updateSchedule(tx, schedule.Id, func(schedule *Schedule) {
+			schedule.LastRun = run.Started
+			schedule.LastStatus = run.Status
+		})
+		if err != nil {
+			return err
+		}
+
+		// History
+		rb, err := tx.Bucket([]byte(sm.runTable)).CreateBucketIfNotExists([]byte(schedule.Id))
+		if err != nil {
+			return err
+		}
+		seq, err := rb.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := rb.Put(uint64Key(seq), b); err != nil {
+			return err
+		}
+		expired := make([][]byte, 0)
+		c := rb.Cursor()
+		for k, _ := c.First(); k != nil && seq-binary.BigEndian.Uint64(k) >= uint64(historySize); k, _ = c.Next() {
+			expired = append(expired, k)
+		}
+		for _, k := range expired {
+			if err := rb.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Execute the command of the schedule and post the output
+func (sm *ScheduleManager) Run(schedule *Schedule) *ScheduleRun {
+	// One run per schedule at a time
+	sm.runningMux.Lock()
+	if sm.running[schedule.Id] {
+		sm.runningMux.Unlock()
+		log.Printf("Schedule %s is still running, skipping", schedule.Name)
+		return nil
+	}
+	sm.running[schedule.Id] = true
+	sm.runningMux.Unlock()
+	defer func() {
+		sm.runningMux.Lock()
+		delete(sm.running, schedule.Id)
+		sm.runningMux.Unlock()
+	}()
+
+	// Console, the command is cancelled after the timeout or once the output exceeds the limit
+	start := time.Now()
+	run := &ScheduleRun{Started: start.Unix(), Status: SCHEDULE_RUN_OK}
+	log.Printf("Running schedule %s: %s", schedule.Name, schedule.Command)
+	timeout := time.Duration(conf.GetIntOrDefault("schedule_command_timeout", SCHEDULE_DEFAULT_COMMAND_TIMEOUT)) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	out := &slackOutput{limit: SLACK_MAX_OUTPUT, cancel: cancel}
+	role := schedule.CurrentRole()
+	cons := newSlackConsole(out, role)
+
+	// Execute and post
+	var err error
+	if len(role) < 1 {
+		err = errors.New(fmt.Sprintf("Creator %s of the schedule does not exist anymore", schedule.Creator))
+	} else if schedule.IsSlack() {
+		header := slackContextBlock(fmt.Sprintf(":alarm_clock: *%s*: `%s`", slackEscape(schedule.Name), slackEscape(schedule.Command)))
+		blocks := append([]slackBlock{header}, executeSlackCommands(ctx, cons, out, schedule.Command)...)
+		if ctx.Err() == context.DeadlineExceeded {
+			run.Status = SCHEDULE_RUN_TIMEOUT
+		}
+		msgs := slackMessages(blocks, "", SLACK_MAX_RESPONSES)
+		webhook := conf.Get("slack_incoming_webhook")
+		if len(webhook) < 1 {
+			err = errors.New("No slack_incoming_webhook configured")
+		} else {
+			run.Messages, err = postSlackResponses(webhook, schedule.Destination, msgs)
+		}
+	} else {
+		cons.Execute(ctx, schedule.Command)
+		if ctx.Err() == context.DeadlineExceeded {
+			run.Status = SCHEDULE_RUN_TIMEOUT
+		}
+		var jsonData map[string]interface{} = make(map[string]interface{})
+		jsonData["schedule"] = schedule
+		jsonData["started"] = run.Started
+		jsonData["status"] = run.Status
+		jsonData["output"] = out.String()
+		jsonData["truncated"] = out.truncated
+		jsonBytes, jsonE := json.Marshal(jsonData)
+		if jsonE != nil {
+			err = jsonE
+		} else if err = postNotification(schedule.Destination, "application/json", bytes.NewBuffer(jsonBytes)); err == nil {
+			run.Messages = 1
+		}
+	}
+	if err != nil {
+		run.Status = SCHEDULE_RUN_FAILED
+		run.Error = fmt.Sprintf("%s", err)
+		log.Printf("Failed to run schedule %s: %s", schedule.Name, err)
+	}
+	run.Duration = int64(time.Since(start) / time.Millisecond)
+	if verbose {
+		log.Printf("Schedule %s finished with status %s in %dms", schedule.Name, run.Status, run.Duration)
+	}
+	if err := sm.saveRun(schedule, run); err != nil {
+		log.Printf("Failed to save run of schedule %s: %s", schedule.Id, err)
+	}
+	return run
+}
+
+// Run the schedules that match a minute after the first and up to the second time, once per schedule
+func (sm *ScheduleManager) runDue(after time.Time, until time.Time) {
+	for _, schedule := range sm.GetSchedules() {
+		if schedule.Paused {
+			continue
+		}
+		cron, err := parseCron(schedule.Cron)
+		if err != nil {
+			log.Printf("Invalid cron expression of schedule %s: %s", schedule.Id, err)
+			continue
+		}
+		if next := cron.Next(after); !next.IsZero() && !next.After(until) {
+			go sm.Run(schedule)
+		}
+	}
+}
+
+// Check the schedules at the start of every minute
+// Minutes that passed during a pause (e.g. GC or a suspended host) are caught up, with a single run per schedule
+// Runs that were due while the supervisor was down are skipped, they are not caught up from the last run after a restart
+func (sm *ScheduleManager) Scheduler() {
+	go func() {
+		checked := time.Now().Truncate(time.Minute)
+		for {
+			time.Sleep(checked.Add(time.Minute).Sub(time.Now()))
+			minute := time.Now().Truncate(time.Minute)
+			if !minute.After(checked) {
+				continue
+			}
+			sm.runDue(checked, minute)
+			checked = minute
+		}
+	}()
+}
+
+// Init the schedule manager
+func NewScheduleManager(db *bolt.DB) *ScheduleManager {
+	sm := &ScheduleManager{
+		db:            db,
+		scheduleTable: "schedules",
+		runTable:      "schedule_runs",
+		running:       make(map[string]bool),
+	}
+
+	// Create buckets
+	err := sm.db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(sm.scheduleTable)); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists([]byte(sm.runTable))
+		return err
+	})
+	if err != nil {
+		log.Fatal(fmt.Errorf("create bucket: %s", err))
+	}
+	sm.Scheduler()
+	return sm
+}
@@ -0,0 +1,177 @@
+// Search backends of the supervisor, configured with search_backends (comma separated IDs) and search_backends.<id>.*
+// - type: bigquery (see bigquery.go)
+// - Backends are created on first use and recreated once their settings change
+// - Queries use the backend of the request, the backend of the filter or the first backend (default)
+// @author Robin Verlangen
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const SEARCH_BACKEND_BIGQUERY string = "bigquery"
+const DEFAULT_SEARCH_DATASET string = "cloudpelican_lsd_v1"
+const DEFAULT_SEARCH_TABLE_VERSION int64 = 1
+const SEARCH_BACKEND_HEALTH_TIMEOUT time.Duration = 10 * time.Second
+const SEARCH_BACKEND_HEALTHY string = "ok"
+const SEARCH_ERROR_TRAILER string = "X-Search-Error"       // Error after the first results were sent
+const SEARCH_ERROR_NO_BACKEND string = "no_search_backend" // Code of the error in case there are no backends, clients fall back to the result store
+
+var ErrNoSearchBackend = errors.New("no search backend configured")
+
+type SearchBackend interface {
+	// Execute the query, the results are written as a header line followed by the rows, every value ends with a tab
+	// Nothing is written in case of an error before the first results, the query is cancelled once the context is done
+	// Errors after the first results are sent to the client in the SEARCH_ERROR_TRAILER
+	Query(ctx context.Context, q string, w io.Writer) error
+
+	// Check whether the backend can be reached with its credentials
+	Health(ctx context.Context) error
+}
+
+// Settings of a backend that can be shared with clients, credentials are left out
+type SearchBackendInfo struct {
+	Id           string `json:"id"`
+	Type         string `json:"type"`
+	ProjectId    string `json:"project_id"`
+	DatasetId    string `json:"dataset_id"`
+	TableVersion int64  `json:"table_version"` // Version suffix of the daily result tables (<filter>_results_YYYY_MM_DD_v<version>)
+	Default      bool   `json:"default"`
+	Health       string `json:"health,omitempty"` // "ok" or the error, only if requested
+}
+
+type cachedSearchBackend struct {
+	settings string
+	backend  SearchBackend
+}
+
+var searchBackends map[string]*cachedSearchBackend = make(map[string]*cachedSearchBackend)
+var searchBackendsMux sync.Mutex
+
+func getSearchBackendIds() []string {
+	list := make([]string, 0)
+	for _, id := range strings.Split(conf.Get("search_backends"), ",") {
+		id = strings.TrimSpace(id)
+		if len(id) > 0 {
+			list = append(list, id)
+		}
+	}
+	return list
+}
+
+func isSearchBackend(id string) bool {
+	for _, backendId := range getSearchBackendIds() {
+		if backendId == id {
+			return true
+		}
+	}
+	return false
+}
+
+func getSearchBackends() []*SearchBackendInfo {
+	list := make([]*SearchBackendInfo, 0)
+	for i, id := range getSearchBackendIds() {
+		info := getSearchBackendInfo(id)
+		info.Default = i == 0
+		list = append(list, info)
+	}
+	return list
+}
+
+// Check the health of all backends in parallel
+func checkSearchBackends(list []*SearchBackendInfo) {
+	var wg sync.WaitGroup
+	for _, info := range list {
+		wg.Add(1)
+		go func(info *SearchBackendInfo) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), SEARCH_BACKEND_HEALTH_TIMEOUT)
+			defer cancel()
+			backend, err := getSearchBackend(info.Id)
+			if err == nil {
+				err = backend.Health(ctx)
+			}
+			if err != nil {
+				info.Health = fmt.Sprintf("%s", err)
+				return
+			}
+			info.Health = SEARCH_BACKEND_HEALTHY
+		}(info)
+	}
+	wg.Wait()
+}
+
+func getSearchBackendInfo(id string) *SearchBackendInfo {
+	return &SearchBackendInfo{
+		Id:           id,
+		Type:         conf.Get(fmt.Sprintf("search_backends.%s.type", id)),
+		ProjectId:    conf.Get(fmt.Sprintf("search_backends.%s.project_id", id)),
+		DatasetId:    conf.GetOrDefault(fmt.Sprintf("search_backends.%s.dataset_id", id), DEFAULT_SEARCH_DATASET),
+		TableVersion: conf.GetIntOrDefault(fmt.Sprintf("search_backends.%s.table_version", id), DEFAULT_SEARCH_TABLE_VERSION),
+	}
+}
+
+// All settings of a backend, used to detect changes
+func getSearchBackendSettings(id string) string {
+	buf := make([]string, 0)
+	for k, v := range conf.GetWithPrefix(fmt.Sprintf("search_backends.%s.", id)) {
+		buf = append(buf, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(buf)
+	return strings.Join(buf, "\n")
+}
+
+// Search backend by ID, empty is the default backend (the first one configured)
+func getSearchBackend(id string) (SearchBackend, error) {
+	ids := getSearchBackendIds()
+	if len(ids) == 0 {
+		return nil, ErrNoSearchBackend
+	}
+	if len(id) < 1 {
+		id = ids[0]
+	} else if !isSearchBackend(id) {
+		return nil, errors.New(fmt.Sprintf("Unknown search backend %s", id))
+	}
+	settings := getSearchBackendSettings(id)
+
+	searchBackendsMux.Lock()
+	defer searchBackendsMux.Unlock()
+	if cached := searchBackends[id]; cached != nil && cached.settings == settings {
+		return cached.backend, nil
+	}
+	backend, err := newSearchBackend(id)
+	if err != nil {
+		return nil, err
+	}
+	searchBackends[id] = &cachedSearchBackend{settings: settings, backend: backend}
+	return backend, nil
+}
+
+func newSearchBackend(id string) (SearchBackend, error) {
+	backendType := conf.Get(fmt.Sprintf("search_backends.%s.type", id))
+	switch backendType {
+	case SEARCH_BACKEND_BIGQUERY:
+		return newBigQuerySearchBackend(id)
+	}
+	return nil, errors.New(fmt.Sprintf("Unsupported search backend type '%s' of %s", backendType, id))
+}
+
+// Writer that keeps track of the bytes written, e.g. to know whether a response has started
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
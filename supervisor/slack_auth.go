@@ -0,0 +1,156 @@
+// Authentication and authorization of Slack requests
+// - Requests are signed by Slack (slack_signing_secret), see https://api.slack.com/authentication/verifying-requests-from-slack
+// - The legacy verification token (slack_token) is only used in case no signing secret is configured
+// - Slack users and channels are mapped to roles by ID: slack_roles.user.<user_id>, slack_roles.channel.<channel_id> and slack_default_role (default reader),
+//   names are not used as they can be changed by the users
+// - Commands that change filters, alerts or the supervisor require a minimum role (SLACK_COMMAND_ROLES)
+// @author Robin Verlangen
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/RobinUS2/cloudpelican-lsd/cli/console"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const SLACK_SIGNATURE_VERSION string = "v0"
+const SLACK_MAX_REQUEST_AGE int64 = 300 // Seconds, older requests are rejected (replay protection)
+const SLACK_MAX_BODY_SIZE int64 = 64 * 1024
+const SLACK_DEFAULT_ROLE string = ROLE_READER
+const SLACK_ROLE_NONE string = "none"
+
+// Minimum role of commands, checked before executing in order to give a clear answer (the supervisor API enforces the same roles)
+var SLACK_COMMAND_ROLES map[string]string = map[string]string{
+	"create filter":        ROLE_WRITER,
+	"drop filter":          ROLE_WRITER,
+	"create alert":         ROLE_WRITER,
+	"drop alert":           ROLE_WRITER,
+	"create schedule":      ROLE_WRITER,
+	"drop schedule":        ROLE_WRITER,
+	"pause schedule":       ROLE_WRITER,
+	"resume schedule":      ROLE_WRITER,
+	"create user":          ROLE_ADMIN,
+	"drop user":            ROLE_ADMIN,
+	"show users":           ROLE_ADMIN,
+	"configure supervisor": ROLE_ADMIN,
+}
+
+type slackRoleKey struct{}
+
+// Verify the request was sent by Slack, the body can be read afterwards
+func verifySlackRequest(w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, SLACK_MAX_BODY_SIZE))
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to read body: %s", err))
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	// Signature
+	secret := conf.Get("slack_signing_secret")
+	if len(secret) > 0 {
+		return verifySlackSignature(secret, r.Header.Get("X-Slack-Request-Timestamp"), r.Header.Get("X-Slack-Signature"), body, time.Now())
+	}
+
+	// Legacy verification token
+	expectedToken := conf.Get("slack_token")
+	if len(expectedToken) < 1 {
+		return errors.New("Please configure the slack_signing_secret")
+	}
+	token := r.PostFormValue("token")
+	if len(r.PostFormValue("payload")) > 0 {
+		// Interactive components
+		var interaction slackInteraction
+		json.Unmarshal([]byte(r.PostFormValue("payload")), &interaction)
+		token = interaction.Token
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(expectedToken)) != 1 {
+		return errors.New("Invalid token")
+	}
+	return nil
+}
+
+func verifySlackSignature(secret string, timestamp string, signature string, body []byte, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("Invalid timestamp")
+	}
+	if math.Abs(float64(now.Unix()-ts)) > float64(SLACK_MAX_REQUEST_AGE) {
+		return errors.New(fmt.Sprintf("Timestamp %d is more than %d seconds off", ts, SLACK_MAX_REQUEST_AGE))
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%s:%s:", SLACK_SIGNATURE_VERSION, timestamp)))
+	mac.Write(body)
+	expected := fmt.Sprintf("%s=%s", SLACK_SIGNATURE_VERSION, hex.EncodeToString(mac.Sum(nil)))
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return errors.New("Invalid signature")
+	}
+	return nil
+}
+
+// Role of the Slack user, the mapping of the user takes precedence over the channel
+func slackRole(r *http.Request) string {
+	keys := make([]string, 0)
+	if userId := r.PostFormValue("user_id"); len(userId) > 0 {
+		keys = append(keys, fmt.Sprintf("slack_roles.user.%s", userId))
+	}
+	if channelId := r.PostFormValue("channel_id"); len(channelId) > 0 {
+		keys = append(keys, fmt.Sprintf("slack_roles.channel.%s", channelId))
+	}
+	role := ""
+	for _, k := range keys {
+		if role = conf.Get(k); len(role) > 0 {
+			break
+		}
+	}
+	if len(role) < 1 {
+		role = conf.GetOrDefault("slack_default_role", SLACK_DEFAULT_ROLE)
+	}
+	if role != SLACK_ROLE_NONE && roleLevel(role) == 0 {
+		log.Printf("Invalid Slack role %s, expected %s, %s, %s or %s", role, ROLE_READER, ROLE_WRITER, ROLE_ADMIN, SLACK_ROLE_NONE)
+		return SLACK_ROLE_NONE
+	}
+	return role
+}
+
+// Every command of the input is allowed for the role
+func checkSlackCommands(input string, role string) error {
+	for _, cmd := range console.SplitCommands(input) {
+		cmd = strings.ToLower(strings.Join(strings.Fields(cmd), " "))
+		for prefix, minRole := range SLACK_COMMAND_ROLES {
+			if (cmd == prefix || strings.HasPrefix(cmd, prefix+" ")) && roleLevel(role) < roleLevel(minRole) {
+				return errors.New(fmt.Sprintf("%s requires the %s role, your role is %s", prefix, minRole, role))
+			}
+		}
+	}
+	return nil
+}
+
+// Requests of Slack users are limited to the role of the Slack user
+func withSlackRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, slackRoleKey{}, role)
+}
+
+func slackRestrictedUser(r *http.Request, user *User) *User {
+	role, ok := r.Context().Value(slackRoleKey{}).(string)
+	if !ok || roleLevel(role) >= roleLevel(user.Role) {
+		return user
+	}
+	restricted := *user
+	restricted.Role = role
+	return &restricted
+}
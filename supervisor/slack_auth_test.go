@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func slackTestSignature(secret string, timestamp string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("v0:%s:%s", timestamp, body)))
+	return fmt.Sprintf("v0=%s", hex.EncodeToString(mac.Sum(nil)))
+}
+
+func TestVerifySlackSignature(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	body := "token=x&team_id=T1&user_id=U1&command=%2Fcloudpelican&text=ping"
+	ts := fmt.Sprintf("%d", now.Unix())
+	old := fmt.Sprintf("%d", now.Unix()-SLACK_MAX_REQUEST_AGE-1)
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		body      string
+		valid     bool
+	}{
+		{"valid", ts, slackTestSignature("secret", ts, body), body, true},
+		{"expired timestamp", old, slackTestSignature("secret", old, body), body, false},
+		{"wrong secret", ts, slackTestSignature("other", ts, body), body, false},
+		{"modified body", ts, slackTestSignature("secret", ts, body), body + "&x=1", false},
+		{"other timestamp", fmt.Sprintf("%d", now.Unix()-1), slackTestSignature("secret", ts, body), body, false},
+		{"missing signature", ts, "", body, false},
+		{"invalid timestamp", "now", slackTestSignature("secret", "now", body), body, false},
+		{"future timestamp", fmt.Sprintf("%d", now.Unix()+SLACK_MAX_REQUEST_AGE+1), slackTestSignature("secret", fmt.Sprintf("%d", now.Unix()+SLACK_MAX_REQUEST_AGE+1), body), body, false},
+	}
+	for _, test := range tests {
+		err := verifySlackSignature("secret", test.timestamp, test.signature, []byte(test.body), now)
+		if test.valid && err != nil {
+			t.Errorf("%s: expected a valid signature, got %s", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected an invalid signature", test.name)
+		}
+	}
+}
+
+func TestSlackRole(t *testing.T) {
+	conf = &Conf{data: map[string]string{
+		"slack_roles.user.U1":     ROLE_ADMIN,
+		"slack_roles.user.alice":  ROLE_ADMIN,
+		"slack_roles.channel.C1":  ROLE_WRITER,
+		"slack_roles.channel.ops": ROLE_WRITER,
+		"slack_roles.user.U2":     "superuser",
+		"slack_default_role":      ROLE_READER,
+	}}
+	tests := []struct {
+		name string
+		form url.Values
+		role string
+	}{
+		{"user id", url.Values{"user_id": {"U1"}, "channel_id": {"C1"}}, ROLE_ADMIN},
+		{"channel id", url.Values{"user_id": {"U3"}, "channel_id": {"C1"}}, ROLE_WRITER},
+		{"user name is not used", url.Values{"user_id": {"U3"}, "user_name": {"alice"}, "channel_id": {"C2"}}, ROLE_READER},
+		{"channel name is not used", url.Values{"user_id": {"U3"}, "channel_id": {"C2"}, "channel_name": {"ops"}}, ROLE_READER},
+		{"invalid role", url.Values{"user_id": {"U2"}}, SLACK_ROLE_NONE},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest("POST", "/slack", strings.NewReader(test.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if role := slackRole(r); role != test.role {
+			t.Errorf("%s: expected role %s, got %s", test.name, test.role, role)
+		}
+	}
+}
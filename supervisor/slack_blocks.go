@@ -0,0 +1,353 @@
+// Slack messages with Block Kit, see https://api.slack.com/block-kit
+// - Filter lists are blocks, stats are charts (see slack_chart.go), other output is code
+// - Long output is paginated, the next page is posted by the "show more" button (interactive endpoint /slack/interactive)
+// @author Robin Verlangen
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/RobinUS2/cloudpelican-lsd/cli/console"
+	"github.com/julienschmidt/httprouter"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const SLACK_PAGE_CHARS int = 2900 // Text of a section is limited to 3000 characters
+const SLACK_PAGE_LINES int = 50
+const SLACK_PAGES_TTL time.Duration = time.Hour
+const SLACK_MAX_FILTER_BLOCKS int = 40 // Messages are limited to 50 blocks
+const SLACK_ACTION_SHOW_MORE string = "show_more"
+
+var slackPages *expiringStore = newExpiringStore()
+
+type slackBlock map[string]interface{}
+
+type slackMessage struct {
+	ResponseType    string       `json:"response_type,omitempty"` // ephemeral or in_channel
+	ReplaceOriginal *bool        `json:"replace_original,omitempty"`
+	Channel         string       `json:"channel,omitempty"`
+	Username        string       `json:"username,omitempty"`
+	IconEmoji       string       `json:"icon_emoji,omitempty"`
+	Text            string       `json:"text"` // Fallback of notifications
+	Blocks          []slackBlock `json:"blocks,omitempty"`
+}
+
+// Payload of the interactive endpoint
+type slackInteraction struct {
+	Type        string `json:"type"`
+	Token       string `json:"token"`
+	ResponseUrl string `json:"response_url"`
+	User        struct {
+		Id       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Actions []struct {
+		ActionId string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+// Values that expire, referenced by a random ID (e.g. in URLs and buttons)
+type expiringStore struct {
+	items map[string]*expiringItem
+	mux   sync.Mutex
+}
+
+type expiringItem struct {
+	value   interface{}
+	expires time.Time
+}
+
+func (s *expiringStore) Put(value interface{}, ttl time.Duration) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	now := time.Now()
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for k, item := range s.items {
+		if now.After(item.expires) {
+			delete(s.items, k)
+		}
+	}
+	s.items[id] = &expiringItem{value: value, expires: now.Add(ttl)}
+	return id, nil
+}
+
+func (s *expiringStore) Get(id string) interface{} {
+	value, _ := s.GetWithExpiry(id)
+	return value
+}
+
+// Value and its expiry, expired values are removed
+func (s *expiringStore) GetWithExpiry(id string) (interface{}, time.Time) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	item := s.items[id]
+	if item == nil {
+		return nil, time.Time{}
+	}
+	if time.Now().After(item.expires) {
+		delete(s.items, id)
+		return nil, time.Time{}
+	}
+	return item.value, item.expires
+}
+
+func newExpiringStore() *expiringStore {
+	return &expiringStore{
+		items: make(map[string]*expiringItem),
+	}
+}
+
+// Execute the commands one by one, every command results in blocks
+func executeSlackCommands(ctx context.Context, cons *console.Console, out *slackOutput, input string) []slackBlock {
+	blocks := make([]slackBlock, 0)
+	for _, cmd := range console.SplitCommands(input) {
+		cmd = strings.TrimSpace(cmd)
+		if len(cmd) < 1 {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		cmdLower := strings.ToLower(cmd)
+		if cmdLower == "show filters" {
+			if filters := cons.Filters(); filters != nil {
+				blocks = append(blocks, slackFilterBlocks(filters)...)
+				continue
+			}
+		}
+		if strings.Index(cmdLower, "stats ") == 0 && len(conf.Get("slack_public_url")) > 0 {
+			blocks = append(blocks, slackStatsBlocks(cons, strings.TrimSpace(cmd[len("stats "):]))...)
+			continue
+		}
+
+		// Output of the console, the output limit only cancels this command
+		cmdCtx, cancel := context.WithCancel(ctx)
+		out.Reset(cancel)
+		cons.ExecuteCommand(cmdCtx, cmd)
+		cancel()
+		blocks = append(blocks, slackTextBlocks(out.String())...)
+		if out.truncated {
+			blocks = append(blocks, slackContextBlock(fmt.Sprintf(":warning: Truncated output after %d KB", out.limit/1024)))
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		blocks = append(blocks, slackContextBlock(":warning: Timed out"))
+	}
+	return blocks
+}
+
+func slackFilterBlocks(filters []*console.Filter) []slackBlock {
+	blocks := []slackBlock{
+		{"type": "header", "text": slackText("plain_text", "Filters")},
+	}
+	var more int
+	for _, filter := range filters {
+		if strings.HasPrefix(filter.Name, console.TMP_FILTER_PREFIX) {
+			continue
+		}
+		if len(blocks) > SLACK_MAX_FILTER_BLOCKS {
+			more++
+			continue
+		}
+		fields := []interface{}{
+			slackText("mrkdwn", fmt.Sprintf("*%s*", slackEscape(filter.Name))),
+			slackText("mrkdwn", fmt.Sprintf("`%s`", slackEscape(filter.Regex))),
+		}
+		if len(filter.Extractors) > 0 {
+			fields = append(fields, slackText("mrkdwn", fmt.Sprintf("Extract: %s", slackEscape(strings.Join(filter.Extractors, ", ")))))
+		}
+		if len(filter.SearchBackend) > 0 {
+			fields = append(fields, slackText("mrkdwn", fmt.Sprintf("Search backend: %s", slackEscape(filter.SearchBackend))))
+		}
+		blocks = append(blocks, slackBlock{"type": "section", "fields": fields})
+	}
+	if len(blocks) == 1 {
+		blocks = append(blocks, slackContextBlock("No filters"))
+	}
+	if more > 0 {
+		blocks = append(blocks, slackContextBlock(fmt.Sprintf("And %d more filters", more)))
+	}
+	return blocks
+}
+
+// Chart of the stats, errors are code
+func slackStatsBlocks(cons *console.Console, input string) []slackBlock {
+	stats, err := cons.Stats(input)
+	if err != nil {
+		return slackTextBlocks(fmt.Sprintf("%s\n", err))
+	}
+	b, err := renderStatsPng(stats)
+	if err != nil {
+		return slackTextBlocks(fmt.Sprintf("%s\n", err))
+	}
+	imageUrl, err := storeSlackChart(b)
+	if err != nil {
+		return slackTextBlocks(fmt.Sprintf("Failed to store chart: %s\n", err))
+	}
+	title := fmt.Sprintf("Stats of %s", stats.Filter.Name)
+	return []slackBlock{
+		{"type": "image", "image_url": imageUrl, "alt_text": title, "title": slackText("plain_text", title)},
+	}
+}
+
+// Output as code, the first page and a button to show the next page
+func slackTextBlocks(text string) []slackBlock {
+	pages := slackPaginate(slackEscape(text))
+	if len(pages) < 2 {
+		return slackPageBlocks(pages, 0, "")
+	}
+	id, err := slackPages.Put(pages, SLACK_PAGES_TTL)
+	if err != nil {
+		log.Printf("Failed to store Slack pages: %s", err)
+		return slackPageBlocks(pages[:1], 0, "")
+	}
+	return slackPageBlocks(pages, 0, id)
+}
+
+func slackPageBlocks(pages []string, page int, id string) []slackBlock {
+	blocks := []slackBlock{
+		{"type": "section", "text": slackText("mrkdwn", fmt.Sprintf("```%s```", pages[page]))},
+	}
+	if page+1 < len(pages) && len(id) > 0 {
+		button := slackBlock{
+			"type":      "button",
+			"text":      slackText("plain_text", fmt.Sprintf("Show more (page %d of %d)", page+2, len(pages))),
+			"action_id": SLACK_ACTION_SHOW_MORE,
+			"value":     fmt.Sprintf("%s:%d", id, page+1),
+		}
+		blocks = append(blocks, slackBlock{"type": "actions", "elements": []interface{}{button}})
+	}
+	return blocks
+}
+
+// Pages of whole lines, lines that do not fit a page are split (text is escaped already)
+func slackPaginate(text string) []string {
+	text = strings.TrimRight(text, "\n")
+	if len(text) < 1 {
+		return []string{" "}
+	}
+	pages := make([]string, 0)
+	var page bytes.Buffer
+	var lines int
+	for _, line := range strings.Split(text, "\n") {
+		for len(line)+1 > SLACK_PAGE_CHARS {
+			if page.Len() > 0 {
+				pages = append(pages, page.String())
+				page.Reset()
+				lines = 0
+			}
+			cut := slackSplitIndex(line, SLACK_PAGE_CHARS-1)
+			pages = append(pages, line[:cut])
+			line = line[cut:]
+		}
+		if page.Len() > 0 && (page.Len()+len(line)+1 > SLACK_PAGE_CHARS || lines >= SLACK_PAGE_LINES) {
+			pages = append(pages, page.String())
+			page.Reset()
+			lines = 0
+		}
+		page.WriteString(line)
+		page.WriteString("\n")
+		lines++
+	}
+	if page.Len() > 0 {
+		pages = append(pages, page.String())
+	}
+	return pages
+}
+
+// Index to split the (escaped) text at, at most max bytes, a rune or entity (e.g. &amp;) is not split
+func slackSplitIndex(text string, max int) int {
+	cut := max
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	if amp := strings.LastIndexByte(text[:cut], '&'); amp != -1 && cut-amp < len("&amp;") && !strings.Contains(text[amp:cut], ";") {
+		cut = amp
+	}
+	if cut < 1 {
+		return max
+	}
+	return cut
+}
+
+func slackText(textType string, text string) map[string]interface{} {
+	return map[string]interface{}{"type": textType, "text": text}
+}
+
+func slackContextBlock(text string) slackBlock {
+	return slackBlock{"type": "context", "elements": []interface{}{slackText("mrkdwn", text)}}
+}
+
+// Control characters of Slack markup, see https://api.slack.com/reference/surfaces/formatting#escaping
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// Fallback text of a message with blocks
+func slackFallbackText(blocks []slackBlock) string {
+	for _, block := range blocks {
+		if text, ok := block["text"].(map[string]interface{}); ok {
+			return strings.Trim(fmt.Sprintf("%s", text["text"]), "`")
+		}
+	}
+	return "CloudPelican"
+}
+
+// Interactive components (buttons) of messages
+func PostSlackInteractive(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if err := verifySlackRequest(w, r); err != nil {
+		log.Printf("Invalid Slack request: %s", err)
+		http.Error(w, "invalid request", http.StatusUnauthorized)
+		return
+	}
+	var interaction slackInteraction
+	if err := json.Unmarshal([]byte(r.PostFormValue("payload")), &interaction); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	// Next page, posted as a new message
+	for _, action := range interaction.Actions {
+		if action.ActionId != SLACK_ACTION_SHOW_MORE {
+			continue
+		}
+		split := strings.SplitN(action.Value, ":", 2)
+		page := -1
+		if len(split) == 2 {
+			page, _ = strconv.Atoi(split[1])
+		}
+		replaceOriginal := false
+		msg := &slackMessage{ReplaceOriginal: &replaceOriginal}
+		pages, ok := slackPages.Get(split[0]).([]string)
+		if !ok || page < 0 || page >= len(pages) {
+			msg.Text = "These results expired, please execute the command again"
+		} else {
+			msg.Blocks = slackPageBlocks(pages, page, split[0])
+			msg.Text = fmt.Sprintf("Page %d of %d", page+1, len(pages))
+		}
+		if verbose {
+			log.Printf("Slack user %s requested page %d of %s", interaction.User.Username, page, split[0])
+		}
+		go func(responseUrl string) {
+			if err := postSlackResponse(responseUrl, msg); err != nil {
+				log.Printf("Failed to post Slack page: %s", err)
+			}
+		}(interaction.ResponseUrl)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestSlackPaginate(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"runes", strings.Repeat("é", SLACK_PAGE_CHARS)},
+		{"entities", slackEscape(strings.Repeat("a&", SLACK_PAGE_CHARS))},
+		{"entities offset", slackEscape("a" + strings.Repeat("<&>", SLACK_PAGE_CHARS))},
+	}
+	for _, test := range tests {
+		pages := slackPaginate(test.line)
+		if len(pages) < 2 {
+			t.Errorf("%s: expected multiple pages, got %d", test.name, len(pages))
+		}
+		for i, page := range pages {
+			if len(page) > SLACK_PAGE_CHARS {
+				t.Errorf("%s: page %d exceeds %d bytes", test.name, i, SLACK_PAGE_CHARS)
+			}
+			if !utf8.ValidString(page) {
+				t.Errorf("%s: page %d splits a rune", test.name, i)
+			}
+			if amp := strings.LastIndexByte(page, '&'); amp != -1 && !strings.Contains(page[amp:], ";") {
+				t.Errorf("%s: page %d splits an entity: %s", test.name, i, page[amp:])
+			}
+		}
+		if strings.Join(pages, "") != test.line+"\n" {
+			t.Errorf("%s: pages do not add up to the text", test.name)
+		}
+	}
+}
+
+func TestExpiringStore(t *testing.T) {
+	s := newExpiringStore()
+	id, err := s.Put("value", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, expires := s.GetWithExpiry(id)
+	if value != "value" || expires.Sub(time.Now()) <= 59*time.Minute {
+		t.Errorf("Expected the value with an expiry of an hour, got %v expiring at %s", value, expires)
+	}
+	expiredId, err := s.Put("expired", -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Get(expiredId) != nil {
+		t.Errorf("Expired value was returned")
+	}
+	if _, found := s.items[expiredId]; found {
+		t.Errorf("Expired value was not removed")
+	}
+}
@@ -0,0 +1,177 @@
+// Charts of the stats command for Slack, rendered as PNG
+// - Slack loads the image from the Slack service, configure the public URL of the Slack service (slack_public_url)
+// - Charts are kept in memory for SLACK_CHART_TTL, the URL contains a random ID
+// @author Robin Verlangen
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/RobinUS2/cloudpelican-lsd/cli/console"
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const SLACK_CHART_WIDTH int = 800
+const SLACK_CHART_HEIGHT int = 320
+const SLACK_CHART_MARGIN int = 40
+const SLACK_CHART_TTL time.Duration = 24 * time.Hour
+
+var slackChartBackground color.Color = color.RGBA{255, 255, 255, 255}
+var slackChartAxis color.Color = color.RGBA{90, 90, 90, 255}
+var slackChartMatches color.Color = color.RGBA{46, 182, 125, 255}
+var slackChartErrors color.Color = color.RGBA{224, 30, 90, 255}
+var slackChartOutlier color.Color = color.RGBA{236, 178, 46, 255}
+
+var slackCharts *expiringStore = newExpiringStore()
+
+// Metric 1 (matches) and 2 (errors) as bars, buckets that contain an outlier are marked, same flags as the chart of the cli
+func renderStatsPng(stats *console.FilterStats) ([]byte, error) {
+	metricId := 1
+	secondaryMetricId := 2
+	primaryColor := slackChartMatches
+	if stats.Flags["hide_error"] {
+		secondaryMetricId = -1
+	}
+	if stats.Flags["hide_regular"] {
+		metricId = 2
+		primaryColor = slackChartErrors
+		secondaryMetricId = -1
+	}
+	if stats.Data[metricId] == nil || len(stats.Data[metricId]) < 1 {
+		return nil, errors.New("Metrics not available for this filter")
+	}
+
+	// Buckets in order
+	keys := make([]int64, 0)
+	for ts, _ := range stats.Data[metricId] {
+		keys = append(keys, ts)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	var maxVal int64 = 1
+	for _, k := range keys {
+		if stats.Data[metricId][k] > maxVal {
+			maxVal = stats.Data[metricId][k]
+		}
+	}
+	rollup := stats.Rollup
+
+	// Canvas
+	img := image.NewRGBA(image.Rect(0, 0, SLACK_CHART_WIDTH, SLACK_CHART_HEIGHT))
+	draw.Draw(img, img.Bounds(), &image.Uniform{slackChartBackground}, image.ZP, draw.Src)
+	left := SLACK_CHART_MARGIN + 20
+	right := SLACK_CHART_WIDTH - SLACK_CHART_MARGIN/2
+	top := SLACK_CHART_MARGIN
+	bottom := SLACK_CHART_HEIGHT - SLACK_CHART_MARGIN
+	plotWidth := right - left
+	plotHeight := bottom - top
+
+	// Bars
+	barWidth := float64(plotWidth) / float64(len(keys))
+	for i, k := range keys {
+		x0 := left + int(float64(i)*barWidth)
+		x1 := left + int(float64(i+1)*barWidth)
+		if x1-x0 > 2 {
+			x1-- // Gap between bars
+		}
+		if x1 <= x0 {
+			x1 = x0 + 1
+		}
+		val := stats.Data[metricId][k]
+		y := bottom - int(float64(plotHeight)*float64(val)/float64(maxVal))
+		draw.Draw(img, image.Rect(x0, y, x1, bottom), &image.Uniform{primaryColor}, image.ZP, draw.Src)
+		if secondaryMetricId > 0 && stats.Data[secondaryMetricId] != nil {
+			errVal := stats.Data[secondaryMetricId][k]
+			if errVal > val {
+				errVal = val
+			}
+			errY := bottom - int(float64(plotHeight)*float64(errVal)/float64(maxVal))
+			draw.Draw(img, image.Rect(x0, errY, x1, bottom), &image.Uniform{slackChartErrors}, image.ZP, draw.Src)
+		}
+		for _, outlier := range stats.Outliers {
+			if outlier.Timestamp >= k && outlier.Timestamp < k+rollup {
+				draw.Draw(img, image.Rect(x0, bottom+2, x1, bottom+6), &image.Uniform{slackChartOutlier}, image.ZP, draw.Src)
+				draw.Draw(img, image.Rect(x0, y-4, x1, y), &image.Uniform{slackChartOutlier}, image.ZP, draw.Src)
+				break
+			}
+		}
+	}
+
+	// Axes
+	draw.Draw(img, image.Rect(left-1, top, left, bottom+1), &image.Uniform{slackChartAxis}, image.ZP, draw.Src)
+	draw.Draw(img, image.Rect(left-1, bottom, right, bottom+1), &image.Uniform{slackChartAxis}, image.ZP, draw.Src)
+
+	// Labels
+	title := fmt.Sprintf("%s: matches", stats.Filter.Name)
+	if stats.Flags["hide_regular"] {
+		title = fmt.Sprintf("%s: errors", stats.Filter.Name)
+	} else if secondaryMetricId > 0 {
+		title = fmt.Sprintf("%s (errors in red)", title)
+	}
+	if len(stats.Outliers) > 0 {
+		title = fmt.Sprintf("%s, %d outlier(s) in yellow", title, len(stats.Outliers))
+	}
+	drawChartLabel(img, left, top-16, title)
+	drawChartLabel(img, 4, top+10, fmt.Sprintf("%d", maxVal))
+	drawChartLabel(img, 4, bottom, "0")
+	timeFormat := "2006-01-02 15:04"
+	drawChartLabel(img, left, bottom+22, time.Unix(keys[0], 0).Format(timeFormat))
+	lastLabel := time.Unix(keys[len(keys)-1]+rollup, 0).Format(timeFormat)
+	drawChartLabel(img, right-len(lastLabel)*7, bottom+22, lastLabel)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func drawChartLabel(img *image.RGBA, x int, y int, label string) {
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  &image.Uniform{slackChartAxis},
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(label)
+}
+
+// Public URL of the chart, empty in case the charts can not be linked
+func storeSlackChart(b []byte) (string, error) {
+	publicUrl := strings.TrimRight(conf.Get("slack_public_url"), "/")
+	if len(publicUrl) < 1 {
+		return "", nil
+	}
+	id, err := slackCharts.Put(b, SLACK_CHART_TTL)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/slack/chart/%s.png", publicUrl, id), nil
+}
+
+// Chart image, loaded by Slack, caches can keep it until it expires
+func GetSlackChart(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	value, expires := slackCharts.GetWithExpiry(strings.TrimSuffix(ps.ByName("id"), ".png"))
+	b, ok := value.([]byte)
+	if !ok {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Error(w, "chart not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int64(expires.Sub(time.Now()).Seconds())))
+	w.Header().Set("Expires", expires.UTC().Format(http.TimeFormat))
+	w.Write(b)
+}
@@ -0,0 +1,161 @@
+// Console of the Slack integration, executes the commands of the cli in-process
+// - Requests of the console are served by the router of the supervisor directly (no network, no cli installed)
+// - Commands stop once the context is done (timeout of the command, output limit)
+// @author Robin Verlangen
+
+package main
+
+import (
+	"context"
+	"github.com/RobinUS2/cloudpelican-lsd/cli/console"
+	"io"
+	"net/http"
+	"sync"
+)
+
+const SLACK_CONSOLE_URI string = "http://supervisor/"
+const SLACK_DEFAULT_COMMAND_TIMEOUT int64 = 30
+const SLACK_MAX_OUTPUT int = 256 * 1024 // Per command, long output is paginated
+
+// Transport that serves the requests of the console with a handler
+type handlerTransport struct {
+	handler http.Handler
+	role    string // Role of the Slack user, limits the role of the credentials
+}
+
+func (t *handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(withSlackRole(req.Context(), t.role))
+	r.RemoteAddr = "slack"
+	r.RequestURI = r.URL.RequestURI()
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+
+	// The body is streamed, this supports long polling and streams of results
+	pr, pw := io.Pipe()
+	rw := newPipeResponseWriter(pw)
+	go func() {
+		defer pw.Close()
+		t.handler.ServeHTTP(rw, r)
+		rw.WriteHeader(http.StatusOK)
+	}()
+
+	select {
+	case <-rw.headerWritten:
+	case <-req.Context().Done():
+		pr.Close()
+		return nil, req.Context().Err()
+	}
+	return &http.Response{
+		Status:        http.StatusText(rw.status),
+		StatusCode:    rw.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rw.sentHeader,
+		Body:          pr,
+		ContentLength: -1,
+		Request:       req,
+	}, nil
+}
+
+// Response writer of the handler transport, writes block until the console reads the body
+type pipeResponseWriter struct {
+	header        http.Header
+	sentHeader    http.Header
+	status        int
+	pw            *io.PipeWriter
+	headerOnce    sync.Once
+	headerWritten chan bool
+}
+
+func (w *pipeResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *pipeResponseWriter) WriteHeader(status int) {
+	w.headerOnce.Do(func() {
+		w.status = status
+		w.sentHeader = w.header.Clone()
+		close(w.headerWritten)
+	})
+}
+
+func (w *pipeResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.pw.Write(b)
+}
+
+// Writes are not buffered
+func (w *pipeResponseWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+}
+
+func newPipeResponseWriter(pw *io.PipeWriter) *pipeResponseWriter {
+	return &pipeResponseWriter{
+		header:        make(http.Header),
+		pw:            pw,
+		headerWritten: make(chan bool),
+	}
+}
+
+// Output of Slack commands, commands are cancelled once the limit is reached
+type slackOutput struct {
+	buf       []byte
+	limit     int
+	truncated bool
+	cancel    context.CancelFunc // Command of the current output, see Reset
+	mux       sync.Mutex
+}
+
+func (o *slackOutput) Write(b []byte) (int, error) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	if o.truncated {
+		return len(b), nil
+	}
+	if len(o.buf)+len(b) > o.limit {
+		o.buf = append(o.buf, b[:o.limit-len(o.buf)]...)
+		o.truncated = true
+		o.cancel()
+		return len(b), nil
+	}
+	o.buf = append(o.buf, b...)
+	return len(b), nil
+}
+
+// Start the output of the next command, the limit only cancels that command (e.g. its child context)
+func (o *slackOutput) Reset(cancel context.CancelFunc) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	o.buf = o.buf[:0]
+	o.truncated = false
+	o.cancel = cancel
+}
+
+func (o *slackOutput) String() string {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	return string(o.buf)
+}
+
+// Console connected to this supervisor with the credentials of the Slack integration, limited to the role of the Slack user
+func newSlackConsole(out io.Writer, role string) *console.Console {
+	console.Verbose = verbose
+	c := console.NewConsole(out)
+	c.FixedConnection = true
+	c.AllowFiles = false
+	c.HttpClient = &http.Client{Transport: &handlerTransport{handler: apiRouter, role: role}}
+
+	// Credentials of the Slack integration (optional), allows limiting the rights of Slack users
+	c.Session["supervisor_uri"] = SLACK_CONSOLE_URI
+	if len(conf.Get("slack_auth_user")) > 0 {
+		c.Session["supervisor_username"] = conf.Get("slack_auth_user")
+		c.Session["supervisor_password"] = conf.Get("slack_auth_password")
+	} else {
+		c.Session["supervisor_username"] = basicAuthUsr
+		c.Session["supervisor_password"] = basicAuthPwd
+	}
+	c.Connect()
+	return c
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/RobinUS2/cloudpelican-lsd/cli/console"
+	"strings"
+	"testing"
+)
+
+func TestSlackOutputLimit(t *testing.T) {
+	first, cancelFirst := context.WithCancel(context.Background())
+	defer cancelFirst()
+	out := &slackOutput{limit: 8}
+	out.Reset(cancelFirst)
+	out.Write([]byte("12345"))
+	out.Write([]byte("67890"))
+	if out.String() != "12345678" || !out.truncated || first.Err() == nil {
+		t.Errorf("Expected truncated output and a cancelled command, got %q", out.String())
+	}
+
+	// The next command has its own output and context
+	second, cancelSecond := context.WithCancel(context.Background())
+	defer cancelSecond()
+	out.Reset(cancelSecond)
+	out.Write([]byte("abc"))
+	if out.String() != "abc" || out.truncated || second.Err() != nil {
+		t.Errorf("Expected the output of the next command, got %q", out.String())
+	}
+}
+
+func TestExecuteSlackCommandsLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := &slackOutput{limit: 64, cancel: cancel}
+	cons := console.NewConsole(out)
+
+	// Every command is truncated, later commands still run
+	blocks := executeSlackCommands(ctx, cons, out, "help; help")
+	var warnings int
+	for _, block := range blocks {
+		if block["type"] == "context" && strings.Contains(fmt.Sprintf("%v", block["elements"]), "Truncated") {
+			warnings++
+		}
+	}
+	if warnings != 2 || ctx.Err() != nil {
+		t.Errorf("Expected 2 truncated commands without cancelling the request, got %d warnings in %v", warnings, blocks)
+	}
+}
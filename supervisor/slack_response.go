@@ -0,0 +1,106 @@
+// Delivery of Slack responses
+// - Slack expects an answer within 3 seconds, results of slower commands are posted to the response_url of the command
+// - Requests without response_url fall back to the incoming webhook (slack_incoming_webhook)
+// - Messages are limited to 50 blocks, longer results are posted in multiple parts (at most 5 per response_url)
+// - Posts are retried with exponential backoff (slack_retry_backoff_ms) on network errors, rate limits and server errors
+// @author Robin Verlangen
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const SLACK_RESPONSE_DEADLINE time.Duration = 2500 * time.Millisecond // Slack timeout is 3 seconds
+const SLACK_MAX_BLOCKS int = 50
+const SLACK_MAX_RESPONSES int = 5
+const SLACK_RETRY_ATTEMPTS int = 5
+const SLACK_DEFAULT_RETRY_BACKOFF_MS int64 = 1000
+
+// Messages of the blocks, in parts of at most SLACK_MAX_BLOCKS
+func slackMessages(blocks []slackBlock, responseType string, maxParts int) []*slackMessage {
+	msgs := make([]*slackMessage, 0)
+	for len(blocks) > 0 {
+		n := SLACK_MAX_BLOCKS
+		if len(msgs) == maxParts-1 && len(blocks) > n {
+			// Last part, the remaining blocks are dropped
+			blocks = append(blocks[:n-1:n-1], slackContextBlock(fmt.Sprintf(":warning: Output exceeds %d messages", maxParts)))
+		}
+		if n > len(blocks) {
+			n = len(blocks)
+		}
+		msgs = append(msgs, &slackMessage{
+			ResponseType: responseType,
+			Text:         slackFallbackText(blocks[:n]),
+			Blocks:       blocks[:n],
+		})
+		blocks = blocks[n:]
+	}
+	if len(msgs) == 0 {
+		msgs = append(msgs, &slackMessage{ResponseType: responseType, Text: "No output"})
+	}
+	return msgs
+}
+
+// Post the messages in order, stops at the first failure (messages of the incoming webhook are sent to the channel)
+func postSlackResponses(uri string, webhookChannel string, msgs []*slackMessage) (int, error) {
+	for i, msg := range msgs {
+		if len(webhookChannel) > 0 {
+			msg.Channel = webhookChannel
+			msg.Username = "CloudPelican"
+			msg.IconEmoji = ":cloud:"
+		}
+		if err := postSlackResponse(uri, msg); err != nil {
+			log.Printf("Failed to post Slack response %d of %d: %s", i+1, len(msgs), err)
+			return i, err
+		}
+	}
+	if verbose {
+		log.Printf("Posted %d Slack response(s)", len(msgs))
+	}
+	return len(msgs), nil
+}
+
+// Post a message to a response_url or incoming webhook, retried with exponential backoff
+func postSlackResponse(uri string, msg *slackMessage) error {
+	if len(uri) < 1 {
+		return errors.New("No response_url or slack_incoming_webhook")
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	backoff := time.Duration(conf.GetIntOrDefault("slack_retry_backoff_ms", SLACK_DEFAULT_RETRY_BACKOFF_MS)) * time.Millisecond
+	client := &http.Client{Timeout: 10 * time.Second}
+	for attempt := 1; ; attempt++ {
+		wait := backoff
+		resp, postErr := client.Post(uri, "application/json", bytes.NewReader(body))
+		if postErr == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return nil
+			}
+			postErr = errors.New(fmt.Sprintf("Status %d", resp.StatusCode))
+			if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+				// Not retried, e.g. an expired response_url
+				return postErr
+			}
+			if retryAfter, e := strconv.ParseInt(resp.Header.Get("Retry-After"), 10, 64); e == nil && time.Duration(retryAfter)*time.Second > wait {
+				wait = time.Duration(retryAfter) * time.Second
+			}
+		}
+		if attempt >= SLACK_RETRY_ATTEMPTS {
+			return errors.New(fmt.Sprintf("%s after %d attempts", postErr, attempt))
+		}
+		log.Printf("Failed to post Slack response (attempt %d of %d), retry in %s: %s", attempt, SLACK_RETRY_ATTEMPTS, wait, postErr)
+		time.Sleep(wait)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Records the posted messages, the first posts fail with the given status codes
+type slackTestServer struct {
+	failures []int
+	attempts int
+	msgs     []*slackMessage
+	mux      sync.Mutex
+}
+
+func (s *slackTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.attempts++
+	if s.attempts <= len(s.failures) {
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(s.failures[s.attempts-1])
+		return
+	}
+	msg := &slackMessage{}
+	if err := json.NewDecoder(r.Body).Decode(msg); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.msgs = append(s.msgs, msg)
+}
+
+func slackTestBlocks(n int) []slackBlock {
+	blocks := make([]slackBlock, 0)
+	for i := 0; i < n; i++ {
+		blocks = append(blocks, slackContextBlock(fmt.Sprintf("block %d", i)))
+	}
+	return blocks
+}
+
+func slackTestBlockText(block slackBlock) string {
+	return block["elements"].([]interface{})[0].(map[string]interface{})["text"].(string)
+}
+
+func TestSlackMessages(t *testing.T) {
+	tests := []struct {
+		blocks       int
+		responseType string
+		parts        []int // Blocks per part
+		truncated    bool
+	}{
+		{0, "ephemeral", []int{0}, false},
+		{10, "ephemeral", []int{10}, false},
+		{SLACK_MAX_BLOCKS, "in_channel", []int{SLACK_MAX_BLOCKS}, false},
+		{120, "in_channel", []int{50, 50, 20}, false},
+		{250, "ephemeral", []int{50, 50, 50, 50, 50}, false},
+		{300, "ephemeral", []int{50, 50, 50, 50, 50}, true},
+	}
+	for _, test := range tests {
+		msgs := slackMessages(slackTestBlocks(test.blocks), test.responseType, SLACK_MAX_RESPONSES)
+		if len(msgs) != len(test.parts) {
+			t.Errorf("%d blocks: expected %d parts, got %d", test.blocks, len(test.parts), len(msgs))
+			continue
+		}
+		next := 0
+		for i, msg := range msgs {
+			if msg.ResponseType != test.responseType {
+				t.Errorf("%d blocks: expected response type %s of part %d, got %s", test.blocks, test.responseType, i+1, msg.ResponseType)
+			}
+			if len(msg.Blocks) != test.parts[i] {
+				t.Errorf("%d blocks: expected %d blocks in part %d, got %d", test.blocks, test.parts[i], i+1, len(msg.Blocks))
+			}
+			if len(msg.Text) < 1 {
+				t.Errorf("%d blocks: missing fallback text of part %d", test.blocks, i+1)
+			}
+			for j, block := range msg.Blocks {
+				if test.truncated && i == len(msgs)-1 && j == len(msg.Blocks)-1 {
+					break
+				}
+				if text := slackTestBlockText(block); text != fmt.Sprintf("block %d", next) {
+					t.Errorf("%d blocks: expected block %d in order, got %s", test.blocks, next, text)
+				}
+				next++
+			}
+		}
+		if test.truncated {
+			last := msgs[len(msgs)-1].Blocks
+			if text := slackTestBlockText(last[len(last)-1]); text != fmt.Sprintf(":warning: Output exceeds %d messages", SLACK_MAX_RESPONSES) {
+				t.Errorf("%d blocks: expected a warning in the last block, got %s", test.blocks, text)
+			}
+		}
+	}
+}
+
+func TestPostSlackResponse(t *testing.T) {
+	conf = &Conf{data: map[string]string{"slack_retry_backoff_ms": "1"}}
+	tests := []struct {
+		name     string
+		failures []int
+		attempts int
+		ok       bool
+	}{
+		{"posted", nil, 1, true},
+		{"rate limited", []int{http.StatusTooManyRequests}, 2, true},
+		{"server errors", []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway}, 4, true},
+		{"expired response_url", []int{http.StatusNotFound}, 1, false},
+		{"too many failures", []int{500, 500, 500, 500, 500, 500}, SLACK_RETRY_ATTEMPTS, false},
+	}
+	for _, test := range tests {
+		s := &slackTestServer{failures: test.failures}
+		server := httptest.NewServer(s)
+		err := postSlackResponse(server.URL, &slackMessage{ResponseType: "ephemeral", Text: "test"})
+		server.Close()
+		if test.ok && err != nil {
+			t.Errorf("%s: expected the message to be posted, got %s", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+		if s.attempts != test.attempts {
+			t.Errorf("%s: expected %d attempts, got %d", test.name, test.attempts, s.attempts)
+		}
+		if test.ok && (len(s.msgs) != 1 || s.msgs[0].Text != "test") {
+			t.Errorf("%s: expected the message to be recorded once, got %d", test.name, len(s.msgs))
+		}
+	}
+}
+
+func TestPostSlackResponses(t *testing.T) {
+	conf = &Conf{data: map[string]string{"slack_retry_backoff_ms": "1"}}
+
+	// Incoming webhook, in order with the channel
+	s := &slackTestServer{failures: []int{http.StatusInternalServerError}}
+	server := httptest.NewServer(s)
+	n, err := postSlackResponses(server.URL, "#ops", slackMessages(slackTestBlocks(120), "in_channel", SLACK_MAX_RESPONSES))
+	server.Close()
+	if err != nil || n != 3 || len(s.msgs) != 3 {
+		t.Fatalf("Expected 3 posted messages, got %d (%d recorded): %v", n, len(s.msgs), err)
+	}
+	for i, msg := range s.msgs {
+		if msg.Channel != "#ops" || msg.Username != "CloudPelican" || msg.ResponseType != "in_channel" {
+			t.Errorf("Unexpected channel %s, username %s or response type %s of message %d", msg.Channel, msg.Username, msg.ResponseType, i+1)
+		}
+		if text := slackTestBlockText(msg.Blocks[0]); text != fmt.Sprintf("block %d", i*SLACK_MAX_BLOCKS) {
+			t.Errorf("Expected message %d to start with block %d, got %s", i+1, i*SLACK_MAX_BLOCKS, text)
+		}
+	}
+
+	// Stops at the first failure
+	s = &slackTestServer{failures: []int{http.StatusNotFound}}
+	server = httptest.NewServer(s)
+	n, err = postSlackResponses(server.URL, "", slackMessages(slackTestBlocks(120), "ephemeral", SLACK_MAX_RESPONSES))
+	server.Close()
+	if err == nil || n != 0 || s.attempts != 1 {
+		t.Errorf("Expected to stop after the failed first message, posted %d in %d attempts: %v", n, s.attempts, err)
+	}
+}
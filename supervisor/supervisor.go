@@ -9,22 +9,22 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"github.com/RobinUS2/cloudpelican-lsd/cli/console"
 	"github.com/RobinUS2/golang-jresp"
 	"github.com/julienschmidt/httprouter"
+	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
-	"net/url"
-	"os"
-	"os/exec"
 	"runtime"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -35,12 +35,28 @@ var basicAuthPwd string
 var adminPwd string
 var dbFile string
 var filterManager *FilterManager
+var alertManager *AlertManager
+var scheduleManager *ScheduleManager
+var userManager *UserManager
+var tokenManager *TokenManager
 var maxMsgMemory int
 var maxMsgBatch int
+var maxResultWait int
+var maxMsgDisk int
+var resultSegmentSize int
+var resultStoreType string
+var statsStoreType string
 var verbose bool
 var confPath string
 var conf *Conf
 var numCores int
+var tlsCertFile string
+var tlsKeyFile string
+var tlsClientCaFile string
+var slackTlsCertFile string
+var slackTlsKeyFile string
+var apiTls *TlsReloader
+var apiRouter http.Handler
 
 func init() {
 	flag.IntVar(&serverPort, "port", 1525, "Server port")
@@ -50,14 +66,25 @@ func init() {
 	flag.StringVar(&adminPwd, "admin-password", "", "Password for admin operations (optional)")
 	flag.StringVar(&dbFile, "db-file", "cloudpelican_lsd_supervisor.db", "Database file")
 	flag.IntVar(&maxMsgMemory, "max-msg-memory", 10000, "Maximum amount of messages kept in memory")
+	flag.IntVar(&maxMsgDisk, "max-msg-disk", 100000, "Maximum amount of messages kept on disk per filter (bolt result store)")
+	flag.IntVar(&resultSegmentSize, "result-segment-size", 1000, "Amount of messages per segment on disk (bolt result store)")
+	flag.StringVar(&resultStoreType, "result-store", "memory", "Storage of filter results (memory, bolt)")
+	flag.StringVar(&statsStoreType, "stats-store", "bolt", "Storage of filter statistics (bolt, memory)")
 	flag.IntVar(&maxMsgBatch, "max-msg-batch", 10000, "Maximum amount of messages sent in a single batch")
+	flag.IntVar(&maxResultWait, "max-result-wait", 30, "Maximum amount of seconds a long-polling result request is held open")
 	flag.IntVar(&numCores, "cpu-cores", -1, "Amount of cores we can use (-1 = all available)")
 	flag.StringVar(&confPath, "conf", "", "Path to additional configuration parameter file")
+	flag.StringVar(&tlsCertFile, "tls-cert", "", "TLS certificate file of the supervisor service (optional, conf key tls_cert_file)")
+	flag.StringVar(&tlsKeyFile, "tls-key", "", "TLS key file of the supervisor service (optional, conf key tls_key_file)")
+	flag.StringVar(&tlsClientCaFile, "tls-client-ca", "", "CA bundle of client certificates, required for storm ingest if set (optional, conf key tls_client_ca_file)")
+	flag.StringVar(&slackTlsCertFile, "slack-tls-cert", "", "TLS certificate file of the Slack service (optional, conf key slack_tls_cert_file)")
+	flag.StringVar(&slackTlsKeyFile, "slack-tls-key", "", "TLS key file of the Slack service (optional, conf key slack_tls_key_file)")
 	flag.BoolVar(&verbose, "v", false, "Verbose, debug mode")
-	flag.Parse()
 }
 
 func main() {
+	flag.Parse()
+
 	// Set max procs
 	if numCores == -1 {
 		numCores = runtime.NumCPU()
@@ -71,6 +98,16 @@ func main() {
 	// Filter manager
 	filterManager = NewFilterManager()
 
+	// User manager
+	userManager = NewUserManager(filterManager.db)
+	tokenManager = NewTokenManager(filterManager.db)
+
+	// Alert manager
+	alertManager = NewAlertManager(filterManager.db)
+
+	// Schedule manager
+	scheduleManager = NewScheduleManager(filterManager.db)
+
 	// Routing
 	router := httprouter.New()
 
@@ -80,43 +117,73 @@ func main() {
 	// Ping
 	router.GET("/ping", GetPing)
 
+	// Authentication
+	router.POST("/auth/login", PostAuthLogin)         // Issue a new token (basic auth only)
+	router.GET("/auth/token", GetAuthToken)           // Get tokens of the user (all tokens for admins)
+	router.DELETE("/auth/token/:id", DeleteAuthToken) // Revoke a token
+
 	// Filters
 	router.POST("/filter", PostFilter)                             // Create new filter
 	router.GET("/filter/:id/result", GetFilterResult)              // Get results of a single filter
+	router.GET("/filter/:id/stream", GetFilterStream)              // Stream results of a single filter (Server-Sent Events)
 	router.GET("/filter/:id/stats", GetFilterStats)                // Get stats of a single filter
 	router.PUT("/filter/:id/result", PutFilterResult)              // Store new results into a filter
 	router.POST("/filter/:id/outlier", PostFilterOutlier)          // Create new record of a detected outlier
+	router.GET("/filter/:id/outliers", GetFilterOutliers)          // Get detected outliers of a single filter
 	router.PUT("/stats/filters", PutStatsFilters)                  // Store new statistics around filters
 	router.GET("/filter", GetFilter)                               // Get all filters
 	router.DELETE("/filter/:id", DeleteFilter)                     // Delete a filter
+	router.POST("/alert", PostAlert)                               // Create new alert rule
+	router.GET("/alert", GetAlert)                                 // Get all alert rules
+	router.DELETE("/alert/:id", DeleteAlert)                       // Delete an alert rule
+	router.POST("/schedule", PostSchedule)                         // Create new scheduled command
+	router.GET("/schedule", GetSchedule)                           // Get all scheduled commands
+	router.PUT("/schedule/:id", PutSchedule)                       // Pause or resume a scheduled command (?paused=true|false)
+	router.DELETE("/schedule/:id", DeleteSchedule)                 // Delete a scheduled command and its history
+	router.GET("/schedule/:id/runs", GetScheduleRuns)              // Get the history of a scheduled command (?limit=<n>)
+	router.POST("/admin/user", PostAdminUser)                      // Create new user
+	router.GET("/admin/user", GetAdminUser)                        // Get all users
+	router.DELETE("/admin/user/:username", DeleteAdminUser)        // Delete an user
 	router.DELETE("/admin/truncate/outliers", DeleteAdminOutliers) // Delete outliers
 	router.DELETE("/admin/truncate/stats", DeleteAdminStats)       // Delete timeseries statistics
 	router.PUT("/admin/config", PutAdminConfig)                    // Set configuration value
-	router.POST("/bigquery/query", PostBigQueryExecute)            // Execute a query on bigquery, NOT JSON, response is TSV
+	router.POST("/bigquery/query", PostBigQueryExecute)            // Execute a query on a search backend (?backend=<id> or ?filter=<id>), NOT JSON, response is TSV
+	router.GET("/search/backends", GetSearchBackends)              // Configured search backends without credentials (?health=true checks them)
+	apiRouter = router
+
+	// TLS (optional)
+	var tlsErr error
+	apiTls, tlsErr = newTlsReloader("supervisor service", tlsFile(tlsCertFile, "tls_cert_file"), tlsFile(tlsKeyFile, "tls_key_file"), tlsFile(tlsClientCaFile, "tls_client_ca_file"))
+	if tlsErr != nil {
+		log.Fatal(tlsErr)
+	}
+	slackTls, tlsErr := newTlsReloader("Slack service", tlsFile(slackTlsCertFile, "slack_tls_cert_file"), tlsFile(slackTlsKeyFile, "slack_tls_key_file"), "")
+	if tlsErr != nil {
+		log.Fatal(tlsErr)
+	}
+	reloadTlsOnSighup(apiTls, slackTls)
 
 	// Slack handler: see https://api.slack.com/slash-commands
 	go func() {
 		slackRouter := httprouter.New()
 		slackRouter.POST("/slack", PostSlack)
-		log.Println(fmt.Sprintf("Starting Slack service at port %d", slackServerPort))
-		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", slackServerPort), slackRouter))
+		slackRouter.POST("/slack/interactive", PostSlackInteractive) // Interactive components (e.g. show more)
+		slackRouter.GET("/slack/chart/:id", GetSlackChart)           // Charts of stats, linked in messages
+		log.Println(fmt.Sprintf("Starting Slack service at port %d (TLS %t)", slackServerPort, slackTls != nil))
+		log.Fatal(listenAndServe(slackServerPort, slackRouter, slackTls))
 	}()
 
 	// Start webserver
-	log.Println(fmt.Sprintf("Starting supervisor service at port %d", serverPort))
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", serverPort), router))
+	log.Println(fmt.Sprintf("Starting supervisor service at port %d (TLS %t)", serverPort, apiTls != nil))
+	log.Fatal(listenAndServe(serverPort, router, apiTls))
 }
 
 // Slack handler
 func PostSlack(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	// Validate
-	token := r.PostFormValue("token")
-	expectedToken := conf.GetNotEmpty("slack_token")
-	if verbose {
-		log.Printf("Slack token received %s", token)
-	}
-	if token != expectedToken {
-		log.Println("Invalid Slack token")
+	if err := verifySlackRequest(w, r); err != nil {
+		log.Printf("Invalid Slack request: %s", err)
+		http.Error(w, "invalid request", http.StatusUnauthorized)
 		return
 	}
 
@@ -134,147 +201,85 @@ func PostSlack(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	// Slack user
 	slackUser := r.PostFormValue("user_name")
 	slackChannel := r.PostFormValue("channel_name")
-
-	// Args
-	args := make([]string, 0)
-	if verbose {
-		args = append(args, "-v")
-	} else {
-		args = append(args, "--silent=true")
+	role := slackRole(r)
+	if role == SLACK_ROLE_NONE {
+		log.Printf("Slack user %s in %s is not allowed to execute commands", slackUser, slackChannel)
+		fmt.Fprintf(w, "You are not allowed to use CloudPelican from Slack")
+		return
 	}
-	args = append(args, "-e")
-	args = append(args, input)
-
-	// Output method
-	var webhookResponse bool = false // False means we output directly
-
-	// Format in blocks
-	var buf bytes.Buffer
-	if webhookResponse == false {
-		buf.WriteString("```")
+	if verbose {
+		log.Printf("Slack user %s in %s has role %s", slackUser, slackChannel, role)
 	}
-
-	// Assemble JSON
-	cmd := exec.Command("cloudpelican", args...)
-	//cmd.Stderr = os.Stdout // Redirect std error
-	stdout, _ := cmd.StdoutPipe()
-	err := cmd.Start()
-	if err != nil {
-		log.Fatal(err)
+	if err := checkSlackCommands(input, role); err != nil {
+		log.Printf("Slack user %s in %s is not allowed to execute '%s': %s", slackUser, slackChannel, input, err)
+		fmt.Fprintf(w, "%s", err)
+		return
 	}
 
-	// Output channel
-	var responseWritten bool = false
-	var responseWrittenMux sync.RWMutex
-	responseChan := make(chan string, 1)
-
-	// Timeout
-	go func() {
-		// Timeout after 2.5 second (Slack timeout is 3 seconds)
-		time.Sleep(2500 * time.Millisecond)
-		responseChan <- "Hang tight while we compute your request!"
-
-		// Kill process after X seconds
-		go func() {
-			time.Sleep(30 * time.Second)
-			cmd.Process.Kill()
-		}()
-	}()
-
-	// Direct response in case of share
+	// Visible for the user, or the channel in case of share
+	responseType := "ephemeral"
 	if shareSlack {
-		responseChan <- ""
+		responseType = "in_channel"
 	}
 
-	// Read output and write over buffer
-	go func() {
-		log.Printf("Waiting for command Slack to finish...")
-		scanner := bufio.NewScanner(stdout)
-		var responseLines int64 = 0
-		var responseCharLimit int64 = 12 * 1024
-		var responseChars int64 = 0
-		for scanner.Scan() {
-			txt := scanner.Text()
-			if verbose {
-				log.Println(txt)
-			}
-			buf.WriteString(txt)
-			buf.WriteString("\n")
-			responseChars += int64(len(txt))
-			if responseChars >= responseCharLimit {
-				buf.WriteString("WARN! TRUNCATED OUTPUT")
-				break
-			}
-			responseLines++
+	// Delayed responses, requests without response_url use the incoming webhook
+	responseUrl := r.PostFormValue("response_url")
+	webhookChannel := ""
+	if len(responseUrl) < 1 {
+		responseUrl = conf.Get("slack_incoming_webhook")
+		if shareSlack && !strings.HasPrefix(slackChannel, "@") {
+			webhookChannel = fmt.Sprintf("#%s", slackChannel)
+		} else {
+			// Private message
+			webhookChannel = fmt.Sprintf("@%s", slackUser)
 		}
+	}
 
-		// Close output stream
-		stdout.Close()
+	// Console, the command is cancelled after the timeout or once the output exceeds the limit
+	timeout := time.Duration(conf.GetIntOrDefault("slack_command_timeout", SLACK_DEFAULT_COMMAND_TIMEOUT)) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	out := &slackOutput{limit: SLACK_MAX_OUTPUT, cancel: cancel}
+	cons := newSlackConsole(out, role)
 
-		// Wait for it
-		err = cmd.Wait()
-		if err != nil {
-			log.Printf("Command Slack finished with error: %v", err)
-		} else {
-			log.Printf("Command Slack finished, written %d lines", responseLines)
-		}
-
-		// End block
-		buf.WriteString("```")
-
-		// Write response
-		responseWrittenMux.RLock()
-		if responseWritten || shareSlack {
-			// Request
-			var reqBody *bytes.Buffer
-			// {\"channel\": \"#logging\", \"username\": \"CloudPelican\", \"text\": \"Test\", \"icon_emoji\": \":ghost:\"}
-			var jsonData map[string]string = make(map[string]string)
-			if shareSlack && !strings.HasPrefix(slackChannel, "@") {
-				jsonData["channel"] = fmt.Sprintf("#%s", slackChannel)
-			} else {
-				// Private message
-				jsonData["channel"] = fmt.Sprintf("@%s", slackUser)
-			}
-			jsonData["username"] = "CloudPelican"
-			jsonData["text"] = buf.String()
-			jsonData["icon_emoji"] = ":cloud:"
-			jsonBytes, jsonE := json.Marshal(jsonData)
-			if jsonE != nil {
-				log.Printf("Failed Slack async json: %s", jsonE)
-				return
-			}
-			reqBody = bytes.NewBuffer([]byte(fmt.Sprintf("payload=%s", url.QueryEscape(fmt.Sprintf("%s", jsonBytes)))))
-			if verbose {
-				log.Printf("%s", reqBody.Bytes())
-			}
-			resp, respErr := http.Post(conf.GetNotEmpty("slack_incoming_webhook"), "application/x-www-form-urlencoded", reqBody)
-			if respErr != nil {
-				log.Printf("Failed Slack async request: %s", respErr)
-				return
-			}
-			if verbose {
-				log.Printf("Slack response: %v", resp)
-			}
+	// Execute
+	done := make(chan []slackBlock, 1)
+	go func() {
+		defer cancel()
+		log.Printf("Waiting for command Slack to finish...")
+		blocks := executeSlackCommands(ctx, cons, out, input)
+		if ctx.Err() == context.DeadlineExceeded {
+			log.Printf("Command Slack timed out after %s", timeout)
 		} else {
-			// Write to ouptut
-			responseChan <- buf.String()
+			log.Printf("Command Slack finished, %d blocks", len(blocks))
 		}
-		responseWrittenMux.RUnlock()
+		done <- blocks
 	}()
 
-	// Output
-	resp := <-responseChan
-	responseWrittenMux.Lock()
-	if responseWritten == false {
-		responseWritten = true
-		fmt.Fprint(w, resp)
+	// Answer within the deadline, the results directly or an acknowledgement
+	var msgs []*slackMessage
+	select {
+	case blocks := <-done:
+		msgs = slackMessages(blocks, responseType, SLACK_MAX_RESPONSES+1)
+	case <-time.After(SLACK_RESPONSE_DEADLINE):
+		ack := &slackMessage{ResponseType: responseType, Text: fmt.Sprintf("Hang tight while we compute `%s`", slackEscape(input))}
+		msgs = []*slackMessage{ack}
+		go func() {
+			postSlackResponses(responseUrl, webhookChannel, slackMessages(<-done, responseType, SLACK_MAX_RESPONSES))
+		}()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(msgs[0])
+
+	// Other parts
+	if len(msgs) > 1 {
+		go postSlackResponses(responseUrl, webhookChannel, msgs[1:])
 	}
-	responseWrittenMux.Unlock()
 }
 
 // This is not a JSON response
 func PostBigQueryExecute(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	if !basicAuth(w, r) {
+	user := roleAuthUser(w, r, ROLE_READER)
+	if user == nil {
 		return
 	}
 
@@ -286,74 +291,86 @@ func PostBigQueryExecute(w http.ResponseWriter, r *http.Request, _ httprouter.Pa
 	}
 	log.Printf("BigQuery: %s", bodyStr)
 
-	// Find bigquery conf & put in map
-	jsonData := make(map[string]string)
-	backends := strings.Split(conf.GetNotEmpty("search_backends"), ",")
-	for _, backendId := range backends {
-		backendType := conf.Get(fmt.Sprintf("search_backends.%s.type", backendId))
-		if backendType != "bigquery" {
-			log.Println(fmt.Sprintf("Unsupported search backend %s", backendType))
-			continue
-		}
-		jsonData["project_id"] = conf.GetNotEmpty(fmt.Sprintf("search_backends.%s.project_id", backendId))
-		jsonData["dataset_id"] = conf.GetNotEmpty(fmt.Sprintf("search_backends.%s.dataset_id", backendId))
-		jsonData["service_account_id"] = conf.GetNotEmpty(fmt.Sprintf("search_backends.%s.service_account_id", backendId))
-		jsonData["pk12base64"] = conf.GetNotEmpty(fmt.Sprintf("search_backends.%s.pk12base64", backendId))
+	// Tokens restricted to filters can only query the tables of their filters
+	filterId := strings.TrimSpace(r.URL.Query().Get("filter"))
+	if !user.CanAccessFilter(filterId) || !canQueryFilters(user, bodyStr) {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return
+	}
 
-		if verbose {
-			log.Println(backendId, backendType, jsonData["project_id"], jsonData["dataset_id"], jsonData["service_account_id"], jsonData["pk12base64"])
+	// Backend of the request, the filter or the default, without backends the CLI falls back to the results in the result store
+	backendId := strings.TrimSpace(r.URL.Query().Get("backend"))
+	if len(backendId) < 1 && len(filterId) > 0 {
+		if filter := filterManager.GetFilter(filterId); filter != nil {
+			backendId = filter.SearchBackend
 		}
 	}
-
-	// Query
-	jsonData["query"] = bodyStr
-
-	// Marshal JSON
-	jsonBytes, _ := json.Marshal(jsonData)
-	if verbose {
-		log.Printf("%s", jsonBytes)
+	backend, backendErr := getSearchBackend(backendId)
+	if backendErr == ErrNoSearchBackend {
+		jresp := jresp.NewJsonResp()
+		jresp.Error(fmt.Sprintf("%s", backendErr))
+		jresp.Set("code", SEARCH_ERROR_NO_BACKEND)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, jresp.ToString(false))
+		return
 	}
-
-	// Build base64 args
-	base64Args := base64.StdEncoding.EncodeToString(jsonBytes)
-
-	// Args
-	args := make([]string, 0)
-	args = append(args, "-jar")
-	args = append(args, "bigquery-client/target/bigquery-client-0.1-jar-with-dependencies.jar")
-	args = append(args, base64Args)
-
-	// Assemble JSON
-	cmd := exec.Command("java", args...)
-	if verbose {
-		cmd.Stderr = os.Stdout // Map the stdErr of the process to stdout as this is debug data
+	if backendErr != nil {
+		log.Printf("Search backend failed: %s", backendErr)
+		http.Error(w, fmt.Sprintf("%s", backendErr), http.StatusBadRequest)
+		return
 	}
-	stdout, _ := cmd.StdoutPipe()
-	err := cmd.Start()
-	if err != nil {
-		log.Fatal(err)
+
+	// Execute, the query is cancelled once the client disconnects
+	// Errors after the first results are sent in the trailer, the results are incomplete in that case
+	start := time.Now()
+	w.Header().Set("Trailer", SEARCH_ERROR_TRAILER)
+	out := &countingWriter{w: w}
+	if err := backend.Query(r.Context(), bodyStr, out); err != nil {
+		log.Printf("BigQuery failed after %s: %s", time.Now().Sub(start), err)
+		if out.n == 0 {
+			http.Error(w, fmt.Sprintf("%s", err), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set(SEARCH_ERROR_TRAILER, fmt.Sprintf("%s", err))
+		return
 	}
-	log.Printf("Waiting for command BigQuery to finish...")
-	scanner := bufio.NewScanner(stdout)
-	var responseLines int64 = 0
-	for scanner.Scan() {
-		fmt.Fprintln(w, scanner.Text())
-		responseLines++
+	log.Printf("BigQuery finished in %s, written %d bytes", time.Now().Sub(start), out.n)
+}
+
+// Does the query only refer to tables of filters the user is allowed to access
+func canQueryFilters(user *User, query string) bool {
+	if len(user.Filters) == 0 {
+		return true
 	}
-	stdout.Close()
-	err = cmd.Wait()
-	if err != nil {
-		log.Printf("Command BigQuery finished with error: %v", err)
-	} else {
-		log.Printf("Command BigQuery finished, written %d lines", responseLines)
+	query = strings.ToLower(query)
+	for _, filter := range filterManager.GetFilters() {
+		if user.CanAccessFilter(filter.Id) {
+			continue
+		}
+		id := strings.ToLower(filter.Id)
+		if strings.Contains(query, id) || strings.Contains(query, strings.Replace(id, "-", "_", -1)) {
+			return false
+		}
 	}
-	fmt.Fprint(w, "") // Trailing white space to finish request
+	return true
 }
 
-func PutAdminConfig(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+func GetSearchBackends(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	if !basicAuth(w, r) {
 		return
 	}
+	jresp := jresp.NewJsonResp()
+	backends := getSearchBackends()
+	if r.URL.Query().Get("health") == "true" {
+		checkSearchBackends(backends)
+	}
+	jresp.Set("backends", backends)
+	jresp.OK()
+	fmt.Fprint(w, jresp.ToString(false))
+}
+
+func PutAdminConfig(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	if !adminAuth(w, r) {
 		return
 	}
@@ -366,9 +383,6 @@ func PutAdminConfig(w http.ResponseWriter, r *http.Request, _ httprouter.Params)
 }
 
 func DeleteAdminStats(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	if !basicAuth(w, r) {
-		return
-	}
 	if !adminAuth(w, r) {
 		return
 	}
@@ -380,9 +394,6 @@ func DeleteAdminStats(w http.ResponseWriter, r *http.Request, _ httprouter.Param
 }
 
 func DeleteAdminOutliers(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	if !basicAuth(w, r) {
-		return
-	}
 	if !adminAuth(w, r) {
 		return
 	}
@@ -414,7 +425,14 @@ func GetHome(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 }
 
 func PostFilter(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	if !basicAuth(w, r) {
+	user := roleAuthUser(w, r, ROLE_WRITER)
+	if user == nil {
+		return
+	}
+
+	// Tokens restricted to filters can not add filters outside of their restriction
+	if len(user.Filters) > 0 {
+		http.Error(w, "permission denied", http.StatusForbidden)
 		return
 	}
 	jresp := jresp.NewJsonResp()
@@ -433,8 +451,20 @@ func PostFilter(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		return
 	}
 
+	// Field extractors (optional), comma separated
+	extractors := make([]string, 0)
+	for _, extractor := range strings.Split(r.URL.Query().Get("extractors"), ",") {
+		extractor = strings.TrimSpace(extractor)
+		if len(extractor) > 0 {
+			extractors = append(extractors, extractor)
+		}
+	}
+
+	// Search backend (optional), empty uses the default backend
+	searchBackend := strings.TrimSpace(r.URL.Query().Get("search_backend"))
+
 	// Create filter
-	id, err := filterManager.CreateFilter(name, r.RemoteAddr, regex)
+	id, err := filterManager.CreateFilter(name, r.RemoteAddr, regex, extractors, searchBackend)
 	if err != nil {
 		jresp.Error(fmt.Sprintf("Failed to create filter: %s", err))
 		fmt.Fprint(w, jresp.ToString(false))
@@ -448,7 +478,7 @@ func PostFilter(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 }
 
 func GetFilterResult(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !basicAuth(w, r) {
+	if !filterAuth(w, r, ROLE_READER, ps.ByName("id")) {
 		return
 	}
 	jresp := jresp.NewJsonResp()
@@ -484,36 +514,189 @@ func GetFilterResult(w http.ResponseWriter, r *http.Request, ps httprouter.Param
 	}
 	offset := uint64(offsetS)
 
-	// Get results
-	results := filter.Results()
+	// Predicate (optional), evaluated on the results
+	var predicate console.SqlExpr
+	whereStr := strings.TrimSpace(r.URL.Query().Get("where"))
+	if len(whereStr) > 0 {
+		var predicateE error
+		predicate, predicateE = console.ParseSqlCondition(whereStr)
+		if predicateE != nil {
+			jresp.Error(fmt.Sprintf("Please provide a valid where: %s", predicateE))
+			fmt.Fprint(w, jresp.ToString(false))
+			return
+		}
+	}
 
-	// Build response lines
+	// Fields (optional) to return next to the raw lines, * returns all fields
+	var fields []string
+	for _, field := range strings.Split(r.URL.Query().Get("fields"), ",") {
+		field = strings.TrimSpace(field)
+		if len(field) > 0 {
+			fields = append(fields, field)
+		}
+	}
+
+	// Long-polling, hold the request until there are results or the wait time has passed
+	var wait int = 0
+	waitStr := r.URL.Query().Get("wait")
+	if len(waitStr) > 0 {
+		waitI, waitE := strconv.ParseInt(waitStr, 10, 0)
+		if waitE != nil || waitI < 0 {
+			jresp.Error(fmt.Sprintf("Please provide a valid wait: %s", waitStr))
+			fmt.Fprint(w, jresp.ToString(false))
+			return
+		}
+		wait = int(waitI)
+		if wait > maxResultWait {
+			wait = maxResultWait
+		}
+	}
+	deadline := time.Now().Add(time.Duration(wait) * time.Second)
+
+	// Get results, the offset moves past all scanned results (also the ones that do not match the predicate)
 	lines := make([]string, 0)
+	rows := make([]map[string]string, 0)
 	resultsMaxOffset := uint64(0)
-	for _, result := range results {
-		// Skip all below  or equal to offset
-		if result.id <= offset {
-			continue
+	for {
+		if wait > 0 {
+			filter.WaitResults(offset, deadline.Sub(time.Now()), r.Context().Done())
 		}
+		results := filter.ResultsAfter(offset, maxMsgBatch)
+		for _, result := range results {
+			// Keep track of maximum
+			if result.id > resultsMaxOffset {
+				resultsMaxOffset = result.id
+			}
 
-		// Add line
-		lines = append(lines, result.fields["_raw"])
+			// Add line
+			if predicate != nil && !predicate.Eval(result.fields) {
+				continue
+			}
+			lines = append(lines, result.fields["_raw"])
+			if fields != nil {
+				rows = append(rows, selectFields(result.fields, fields))
+			}
+		}
 
-		// Keep track of maximum
-		if result.id > resultsMaxOffset {
-			resultsMaxOffset = result.id
+		// Keep waiting in case nothing matched
+		if len(lines) > 0 || len(results) == 0 || wait == 0 || time.Now().After(deadline) || r.Context().Err() != nil {
+			break
 		}
+		offset = resultsMaxOffset
 	}
 
 	// Format
 	jresp.Set("result_offset", resultsMaxOffset)
 	jresp.Set("results", lines)
+	if len(waitStr) > 0 {
+		jresp.Set("wait", wait)
+	}
+	if predicate != nil {
+		jresp.Set("where", predicate.String())
+	}
+	if fields != nil {
+		jresp.Set("fields", fields)
+		jresp.Set("rows", rows)
+	}
 	jresp.OK()
 	fmt.Fprint(w, jresp.ToString(false))
 }
 
+// Server-Sent Events stream of results, every line is sent as soon as it is stored
+func GetFilterStream(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !filterAuth(w, r, ROLE_READER, ps.ByName("id")) {
+		return
+	}
+
+	// Streaming support
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Get filter
+	id := strings.TrimSpace(ps.ByName("id"))
+	filter := filterManager.GetFilter(id)
+	if filter == nil {
+		http.Error(w, fmt.Sprintf("Filter %s not found", id), http.StatusNotFound)
+		return
+	}
+
+	// Offset, a reconnecting client tells us the last event it received
+	offsetStr := r.URL.Query().Get("result_offset")
+	if len(r.Header.Get("Last-Event-ID")) > 0 {
+		offsetStr = r.Header.Get("Last-Event-ID")
+	}
+	offset := uint64(0)
+	if len(offsetStr) > 0 {
+		offsetS, offsetE := strconv.ParseUint(offsetStr, 10, 64)
+		if offsetE != nil {
+			http.Error(w, fmt.Sprintf("Please provide a valid result offset: %s", offsetE), http.StatusBadRequest)
+			return
+		}
+		offset = offsetS
+	}
+
+	// Predicate (optional)
+	var predicate console.SqlExpr
+	if whereStr := strings.TrimSpace(r.URL.Query().Get("where")); len(whereStr) > 0 {
+		var predicateE error
+		predicate, predicateE = console.ParseSqlCondition(whereStr)
+		if predicateE != nil {
+			http.Error(w, fmt.Sprintf("Please provide a valid where: %s", predicateE), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Headers
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Stream until the client goes away
+	done := r.Context().Done()
+	for {
+		for _, result := range filter.ResultsAfter(offset, maxMsgBatch) {
+			if result.id > offset {
+				offset = result.id
+			}
+			if predicate != nil && !predicate.Eval(result.fields) {
+				continue
+			}
+			writeEvent(w, result.id, result.fields["_raw"])
+		}
+		flusher.Flush()
+
+		// Wait for more, send a comment line as heartbeat to keep proxies happy
+		if !filter.WaitResults(offset, 15*time.Second, done) {
+			select {
+			case <-done:
+				if verbose {
+					log.Printf("Closed result stream of %s", filter.Id)
+				}
+				return
+			default:
+				fmt.Fprint(w, ": ping\n\n")
+			}
+		}
+	}
+}
+
+// Server-sent event, every line of the data gets its own data field (a newline would end the event otherwise)
+func writeEvent(w io.Writer, id uint64, data string) {
+	fmt.Fprintf(w, "id: %d\n", id)
+	data = strings.Replace(strings.Replace(data, "\r\n", "\n", -1), "\r", "\n", -1)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
 func GetFilterStats(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !basicAuth(w, r) {
+	if !filterAuth(w, r, ROLE_READER, ps.ByName("id")) {
 		return
 	}
 	jresp := jresp.NewJsonResp()
@@ -529,7 +712,52 @@ func GetFilterStats(w http.ResponseWriter, r *http.Request, ps httprouter.Params
 		fmt.Fprint(w, jresp.ToString(false))
 		return
 	}
-	stats := filter.GetStats()
+
+	// Optional time range
+	var from int64 = 0
+	var until int64 = math.MaxInt64
+	if len(r.URL.Query().Get("from")) > 0 {
+		i, e := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+		if e != nil {
+			jresp.Error(fmt.Sprintf("Please provide a valid from: %s", e))
+			fmt.Fprint(w, jresp.ToString(false))
+			return
+		}
+		from = i
+	}
+	if len(r.URL.Query().Get("until")) > 0 {
+		i, e := strconv.ParseInt(r.URL.Query().Get("until"), 10, 64)
+		if e != nil {
+			jresp.Error(fmt.Sprintf("Please provide a valid until: %s", e))
+			fmt.Fprint(w, jresp.ToString(false))
+			return
+		}
+		until = i
+	}
+
+	// Tier, by name or the finest one that covers the window (seconds), defaults to raw
+	tier := filterManager.TimeseriesTier("raw")
+	if len(r.URL.Query().Get("tier")) > 0 {
+		tier = filterManager.TimeseriesTier(r.URL.Query().Get("tier"))
+		if tier == nil {
+			jresp.Error(fmt.Sprintf("Tier %s not found", r.URL.Query().Get("tier")))
+			fmt.Fprint(w, jresp.ToString(false))
+			return
+		}
+	} else if len(r.URL.Query().Get("window")) > 0 {
+		window, e := strconv.ParseInt(r.URL.Query().Get("window"), 10, 64)
+		if e != nil {
+			jresp.Error(fmt.Sprintf("Please provide a valid window: %s", e))
+			fmt.Fprint(w, jresp.ToString(false))
+			return
+		}
+		tier = filterManager.TimeseriesTierForWindow(window)
+		if from == 0 {
+			from = time.Now().Unix() - window
+		}
+	}
+
+	stats := filter.GetStatsRange(tier, from, until)
 	m := make(map[string]map[string]int64) // metricid => timebucket => value
 	for metricId, metric := range stats.Metrics {
 		ms := fmt.Sprintf("%d", metricId)
@@ -539,12 +767,16 @@ func GetFilterStats(w http.ResponseWriter, r *http.Request, ps httprouter.Params
 		}
 	}
 	jresp.Set("stats", m)
+	if tier != nil {
+		jresp.Set("tier", tier.Name)
+		jresp.Set("resolution", tier.Resolution)
+	}
 	jresp.OK()
 	fmt.Fprint(w, jresp.ToString(false))
 }
 
 func PostFilterOutlier(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !basicAuth(w, r) {
+	if !ingestFilterAuth(w, r, ps.ByName("id")) {
 		return
 	}
 	jresp := jresp.NewJsonResp()
@@ -608,8 +840,84 @@ func PostFilterOutlier(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 	fmt.Fprint(w, jresp.ToString(false))
 }
 
+func GetFilterOutliers(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !filterAuth(w, r, ROLE_READER, ps.ByName("id")) {
+		return
+	}
+	jresp := jresp.NewJsonResp()
+
+	// Get filter
+	id := strings.TrimSpace(ps.ByName("id"))
+	if len(id) < 1 {
+		jresp.Error("Please provide an ID")
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+	filter := filterManager.GetFilter(id)
+	if filter == nil {
+		jresp.Error(fmt.Sprintf("Filter %s not found", id))
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+
+	// Time range
+	var from int64 = 0
+	var until int64 = math.MaxInt64
+	if len(r.URL.Query().Get("from")) > 0 {
+		i, e := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+		if e != nil {
+			jresp.Error(fmt.Sprintf("Please provide a valid from: %s", e))
+			fmt.Fprint(w, jresp.ToString(false))
+			return
+		}
+		from = i
+	}
+	if len(r.URL.Query().Get("until")) > 0 {
+		i, e := strconv.ParseInt(r.URL.Query().Get("until"), 10, 64)
+		if e != nil {
+			jresp.Error(fmt.Sprintf("Please provide a valid until: %s", e))
+			fmt.Fprint(w, jresp.ToString(false))
+			return
+		}
+		until = i
+	}
+
+	// Minimum score
+	var minScore float64 = 0
+	if len(r.URL.Query().Get("min_score")) > 0 {
+		f, e := strconv.ParseFloat(r.URL.Query().Get("min_score"), 64)
+		if e != nil {
+			jresp.Error(fmt.Sprintf("Please provide a valid min_score: %s", e))
+			fmt.Fprint(w, jresp.ToString(false))
+			return
+		}
+		minScore = f
+	}
+
+	// Limit
+	var limit int = 100
+	if len(r.URL.Query().Get("limit")) > 0 {
+		i, e := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 0)
+		if e != nil {
+			jresp.Error(fmt.Sprintf("Please provide a valid limit: %s", e))
+			fmt.Fprint(w, jresp.ToString(false))
+			return
+		}
+		if i < 0 {
+			jresp.Error("Please provide a limit of at least 0")
+			fmt.Fprint(w, jresp.ToString(false))
+			return
+		}
+		limit = int(i)
+	}
+
+	jresp.Set("outliers", filter.Outliers(from, until, minScore, limit))
+	jresp.OK()
+	fmt.Fprint(w, jresp.ToString(false))
+}
+
 func PutFilterResult(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !basicAuth(w, r) {
+	if !ingestFilterAuth(w, r, ps.ByName("id")) {
 		return
 	}
 	jresp := jresp.NewJsonResp()
@@ -671,18 +979,25 @@ func PutFilterResult(w http.ResponseWriter, r *http.Request, ps httprouter.Param
 }
 
 func GetFilter(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	if !basicAuth(w, r) {
+	user := roleAuthUser(w, r, ROLE_READER)
+	if user == nil {
 		return
 	}
 	jresp := jresp.NewJsonResp()
-	filters := filterManager.GetFilters()
+	filters := make([]*Filter, 0)
+	for _, filter := range filterManager.GetFilters() {
+		if user.CanAccessFilter(filter.Id) {
+			filters = append(filters, filter)
+		}
+	}
 	jresp.Set("filters", filters)
 	jresp.OK()
 	fmt.Fprint(w, jresp.ToString(false))
 }
 
 func PutStatsFilters(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	if !basicAuth(w, r) {
+	user := ingestAuthUser(w, r)
+	if user == nil {
 		return
 	}
 	jresp := jresp.NewJsonResp()
@@ -725,11 +1040,12 @@ func PutStatsFilters(w http.ResponseWriter, r *http.Request, _ httprouter.Params
 		return
 	}
 
-	// Store results
+	// Collect data points
 	var filterId string
 	var metric int
 	var timeBucket int64
 	var updates int // Amount of acknowledged updates
+	points := make([]*TimeseriesPoint, 0)
 	for k, count := range data {
 		// Reset vars
 		filterId = ""
@@ -778,12 +1094,18 @@ func PutStatsFilters(w http.ResponseWriter, r *http.Request, _ httprouter.Params
 			log.Println("Filter %s not found in PutStatsFilters", filterId)
 			continue
 		}
-
-		// Set results
-		res := filter.AddStats(metric, timeBucket, count)
-		if res {
-			updates++
+		if !user.CanAccessFilter(filter.Id) {
+			log.Printf("Filter %s not allowed for the token in PutStatsFilters", filterId)
+			continue
 		}
+
+		// Add to batch
+		points = append(points, &TimeseriesPoint{FilterId: filter.Id, Metric: metric, Bucket: timeBucket, Count: count})
+	}
+
+	// Store results in a single batch
+	if filterManager.AddStats(points) {
+		updates = len(points)
 	}
 
 	// OK
@@ -793,7 +1115,14 @@ func PutStatsFilters(w http.ResponseWriter, r *http.Request, _ httprouter.Params
 }
 
 func DeleteFilter(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if !basicAuth(w, r) {
+	user := roleAuthUser(w, r, ROLE_WRITER)
+	if user == nil {
+		return
+	}
+
+	// Tokens restricted to filters can use their filters, but not remove them
+	if len(user.Filters) > 0 {
+		http.Error(w, "permission denied", http.StatusForbidden)
 		return
 	}
 	jresp := jresp.NewJsonResp()
@@ -809,43 +1138,497 @@ func DeleteFilter(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 	fmt.Fprint(w, jresp.ToString(false))
 }
 
+func PostAlert(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	filterId := strings.TrimSpace(r.URL.Query().Get("filter_id"))
+	if !filterAuth(w, r, ROLE_WRITER, filterId) {
+		return
+	}
+	jresp := jresp.NewJsonResp()
+
+	// Filter
+	filter := filterManager.GetFilter(filterId)
+	if filter == nil {
+		jresp.Error(fmt.Sprintf("Filter %s not found", filterId))
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+
+	// Threshold
+	threshold, thresholdE := strconv.ParseFloat(strings.TrimSpace(r.URL.Query().Get("threshold")), 64)
+	if thresholdE != nil {
+		jresp.Error(fmt.Sprintf("Please provide a valid threshold: %s", thresholdE))
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+
+	// Window
+	window, windowE := strconv.ParseInt(strings.TrimSpace(r.URL.Query().Get("window")), 10, 64)
+	if windowE != nil {
+		jresp.Error(fmt.Sprintf("Please provide a valid window: %s", windowE))
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+
+	// Create alert
+	rule := &AlertRule{
+		Name:      strings.TrimSpace(r.URL.Query().Get("name")),
+		FilterId:  filter.Id,
+		Condition: strings.TrimSpace(r.URL.Query().Get("condition")),
+		Operator:  strings.TrimSpace(r.URL.Query().Get("operator")),
+		Threshold: threshold,
+		Window:    window,
+		Notifier:  strings.TrimSpace(r.URL.Query().Get("notifier")),
+		Target:    strings.TrimSpace(r.URL.Query().Get("target")),
+	}
+	id, err := alertManager.CreateAlert(rule)
+	if err != nil {
+		jresp.Error(fmt.Sprintf("Failed to create alert: %s", err))
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+
+	// OK :)
+	jresp.Set("alert_id", id)
+	jresp.OK()
+	fmt.Fprint(w, jresp.ToString(false))
+}
+
+func GetAlert(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user := roleAuthUser(w, r, ROLE_READER)
+	if user == nil {
+		return
+	}
+	jresp := jresp.NewJsonResp()
+	alerts := make([]*AlertRule, 0)
+	for _, alert := range alertManager.GetAlerts() {
+		if user.CanAccessFilter(alert.FilterId) {
+			alerts = append(alerts, alert)
+		}
+	}
+	jresp.Set("alerts", alerts)
+	jresp.OK()
+	fmt.Fprint(w, jresp.ToString(false))
+}
+
+func DeleteAlert(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user := roleAuthUser(w, r, ROLE_WRITER)
+	if user == nil {
+		return
+	}
+	jresp := jresp.NewJsonResp()
+	id := strings.TrimSpace(ps.ByName("id"))
+	if len(id) < 1 {
+		jresp.Error("Please provide an ID")
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+	if alert := alertManager.GetAlert(id); alert != nil && !user.CanAccessFilter(alert.FilterId) {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return
+	}
+	res := alertManager.DeleteAlert(id)
+	jresp.Set("deleted", res)
+	jresp.OK()
+	fmt.Fprint(w, jresp.ToString(false))
+}
+
+func PostSchedule(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user := roleAuthUser(w, r, ROLE_WRITER)
+	if user == nil {
+		return
+	}
+	jresp := jresp.NewJsonResp()
+
+	// Commands are executed with the role of the user, tokens restricted to filters can not be limited in the background
+	if len(user.Filters) > 0 {
+		jresp.Error("Tokens restricted to filters can not create schedules")
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+	if len(userManager.GetRole(user.Username)) < 1 {
+		jresp.Error("Schedules can only be created by users of the supervisor")
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+
+	// Create schedule
+	schedule := &Schedule{
+		Name:        strings.TrimSpace(r.URL.Query().Get("name")),
+		Cron:        strings.TrimSpace(r.URL.Query().Get("cron")),
+		Command:     strings.TrimSpace(r.URL.Query().Get("command")),
+		Destination: strings.TrimSpace(r.URL.Query().Get("destination")),
+		Role:        user.Role,
+		Creator:     user.Username,
+	}
+	id, err := scheduleManager.CreateSchedule(schedule)
+	if err != nil {
+		jresp.Error(fmt.Sprintf("Failed to create schedule: %s", err))
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+
+	// OK :)
+	jresp.Set("schedule_id", id)
+	jresp.OK()
+	fmt.Fprint(w, jresp.ToString(false))
+}
+
+func GetSchedule(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user := roleAuthUser(w, r, ROLE_READER)
+	if user == nil {
+		return
+	}
+	jresp := jresp.NewJsonResp()
+	if len(user.Filters) > 0 {
+		jresp.Error("Tokens restricted to filters can not access schedules")
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+	now := time.Now()
+	schedules := scheduleManager.GetSchedules()
+	for _, schedule := range schedules {
+		if cron, err := parseCron(schedule.Cron); err == nil && !schedule.Paused {
+			if next := cron.Next(now); !next.IsZero() {
+				schedule.NextRun = next.Unix()
+			}
+		}
+	}
+	jresp.Set("schedules", schedules)
+	jresp.OK()
+	fmt.Fprint(w, jresp.ToString(false))
+}
+
+func PutSchedule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user := roleAuthUser(w, r, ROLE_WRITER)
+	if user == nil {
+		return
+	}
+	jresp := jresp.NewJsonResp()
+	if len(user.Filters) > 0 {
+		jresp.Error("Tokens restricted to filters can not access schedules")
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+	paused, pausedE := strconv.ParseBool(strings.TrimSpace(r.URL.Query().Get("paused")))
+	if pausedE != nil {
+		jresp.Error("Please provide paused=true or paused=false")
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+	if err := scheduleManager.SetPaused(strings.TrimSpace(ps.ByName("id")), paused); err != nil {
+		jresp.Error(fmt.Sprintf("%s", err))
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+	jresp.Set("paused", paused)
+	jresp.OK()
+	fmt.Fprint(w, jresp.ToString(false))
+}
+
+func DeleteSchedule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user := roleAuthUser(w, r, ROLE_WRITER)
+	if user == nil {
+		return
+	}
+	jresp := jresp.NewJsonResp()
+	if len(user.Filters) > 0 {
+		jresp.Error("Tokens restricted to filters can not access schedules")
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+	id := strings.TrimSpace(ps.ByName("id"))
+	if len(id) < 1 {
+		jresp.Error("Please provide an ID")
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+	res := scheduleManager.DeleteSchedule(id)
+	jresp.Set("deleted", res)
+	jresp.OK()
+	fmt.Fprint(w, jresp.ToString(false))
+}
+
+func GetScheduleRuns(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user := roleAuthUser(w, r, ROLE_READER)
+	if user == nil {
+		return
+	}
+	jresp := jresp.NewJsonResp()
+	if len(user.Filters) > 0 {
+		jresp.Error("Tokens restricted to filters can not access schedules")
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+	limit, limitE := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limitE != nil {
+		limit = 10
+	}
+	jresp.Set("runs", scheduleManager.GetRuns(strings.TrimSpace(ps.ByName("id")), limit))
+	jresp.OK()
+	fmt.Fprint(w, jresp.ToString(false))
+}
+
+func PostAdminUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !adminAuth(w, r) {
+		return
+	}
+	jresp := jresp.NewJsonResp()
+	username := strings.TrimSpace(r.URL.Query().Get("username"))
+	role := strings.TrimSpace(r.URL.Query().Get("role"))
+	if len(role) < 1 {
+		role = ROLE_READER
+	}
+	err := userManager.CreateUser(username, r.URL.Query().Get("password"), role)
+	if err != nil {
+		jresp.Error(fmt.Sprintf("Failed to create user: %s", err))
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+	jresp.Set("username", username)
+	jresp.Set("role", role)
+	jresp.OK()
+	fmt.Fprint(w, jresp.ToString(false))
+}
+
+func GetAdminUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !adminAuth(w, r) {
+		return
+	}
+	jresp := jresp.NewJsonResp()
+	users := make([]map[string]interface{}, 0)
+	for _, user := range userManager.GetUsers() {
+		users = append(users, user.Public())
+	}
+	jresp.Set("users", users)
+	jresp.OK()
+	fmt.Fprint(w, jresp.ToString(false))
+}
+
+func DeleteAdminUser(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !adminAuth(w, r) {
+		return
+	}
+	jresp := jresp.NewJsonResp()
+	username := strings.TrimSpace(ps.ByName("username"))
+	if len(username) < 1 {
+		jresp.Error("Please provide an username")
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+	res := userManager.DeleteUser(username)
+	if res {
+		// Schedules run with the role of their creator
+		if n := scheduleManager.DeleteSchedulesOf(username); n > 0 {
+			log.Printf("Removed %d schedules of user %s", n, username)
+		}
+	}
+	jresp.Set("deleted", res)
+	jresp.OK()
+	fmt.Fprint(w, jresp.ToString(false))
+}
+
+func PostAuthLogin(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user := authUser(w, r)
+	if user == nil {
+		return
+	}
+	jresp := jresp.NewJsonResp()
+	if len(user.TokenId) > 0 {
+		jresp.Error("Please login with username and password")
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+
+	// Role, defaults to the role of the user
+	role := strings.TrimSpace(r.URL.Query().Get("role"))
+	if len(role) < 1 {
+		role = user.Role
+	}
+	if role == ROLE_ADMIN && user.Username == basicAuthUsr && len(adminPwd) > 0 && r.URL.Query().Get("admin_password") != adminPwd {
+		jresp.Error("Please provide the admin password for tokens with the admin role")
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+
+	// Filters (names or IDs, comma separated)
+	filterIds := make([]string, 0)
+	for _, name := range strings.Split(r.URL.Query().Get("filters"), ",") {
+		name = strings.TrimSpace(name)
+		if len(name) < 1 {
+			continue
+		}
+		filter := filterManager.GetFilter(name)
+		if filter == nil {
+			for _, f := range filterManager.GetFilters() {
+				if strings.ToLower(f.Name) == strings.ToLower(name) {
+					filter = f
+					break
+				}
+			}
+		}
+		if filter == nil {
+			jresp.Error(fmt.Sprintf("Filter %s not found", name))
+			fmt.Fprint(w, jresp.ToString(false))
+			return
+		}
+		filterIds = append(filterIds, filter.Id)
+	}
+
+	// Expiry in seconds, 0 never expires
+	ttl := conf.GetIntOrDefault("token_ttl_hours", 30*24) * 3600
+	if len(r.URL.Query().Get("expires")) > 0 {
+		var ttlE error
+		ttl, ttlE = strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+		if ttlE != nil {
+			jresp.Error(fmt.Sprintf("Please provide a valid expiry: %s", ttlE))
+			fmt.Fprint(w, jresp.ToString(false))
+			return
+		}
+	}
+
+	// Issue
+	token, secret, err := tokenManager.CreateToken(user, role, filterIds, ttl)
+	if err != nil {
+		jresp.Error(fmt.Sprintf("Failed to create token: %s", err))
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+	jresp.Set("token", secret)
+	jresp.Set("token_info", token.Public())
+	jresp.OK()
+	fmt.Fprint(w, jresp.ToString(false))
+}
+
+func GetAuthToken(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	user := authUser(w, r)
+	if user == nil {
+		return
+	}
+	jresp := jresp.NewJsonResp()
+	tokens := make([]map[string]interface{}, 0)
+	for _, token := range tokenManager.GetTokens() {
+		if token.Username != user.Username && !user.HasRole(ROLE_ADMIN) {
+			continue
+		}
+		info := token.Public()
+		info["current"] = token.Id == user.TokenId
+		tokens = append(tokens, info)
+	}
+	jresp.Set("tokens", tokens)
+	jresp.OK()
+	fmt.Fprint(w, jresp.ToString(false))
+}
+
+func DeleteAuthToken(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	user := authUser(w, r)
+	if user == nil {
+		return
+	}
+	jresp := jresp.NewJsonResp()
+	id := strings.TrimSpace(ps.ByName("id"))
+	if len(id) < 1 {
+		jresp.Error("Please provide an ID")
+		fmt.Fprint(w, jresp.ToString(false))
+		return
+	}
+	res := tokenManager.RevokeToken(id, user)
+	jresp.Set("revoked", res)
+	jresp.OK()
+	fmt.Fprint(w, jresp.ToString(false))
+}
+
+// Admin operations, the user from the flags also needs the admin password (if set, tokens are checked on login)
 func adminAuth(w http.ResponseWriter, r *http.Request) bool {
-	if len(adminPwd) < 1 {
-		return true // No password set
+	user := authUser(w, r)
+	if user == nil {
+		return false
 	}
-	if r.URL.Query().Get("admin_password") != adminPwd {
+	if !user.HasRole(ROLE_ADMIN) || (user.Username == basicAuthUsr && len(user.TokenId) == 0 && len(adminPwd) > 0 && r.URL.Query().Get("admin_password") != adminPwd) {
+		http.Error(w, "permission denied", http.StatusForbidden)
 		return false
 	}
 	return true
 }
 
+// Read operations
 func basicAuth(w http.ResponseWriter, r *http.Request) bool {
+	return roleAuth(w, r, ROLE_READER)
+}
+
+// Operations that require at least the given role
+func roleAuth(w http.ResponseWriter, r *http.Request, role string) bool {
+	return roleAuthUser(w, r, role) != nil
+}
+
+// Operations on a single filter, tokens can be restricted to certain filters
+func filterAuth(w http.ResponseWriter, r *http.Request, role string, filterId string) bool {
+	user := roleAuthUser(w, r, role)
+	if user == nil {
+		return false
+	}
+	if !user.CanAccessFilter(strings.TrimSpace(filterId)) {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// User with at least the given role, writes the error response in case of failure
+func roleAuthUser(w http.ResponseWriter, r *http.Request, role string) *User {
+	user := authUser(w, r)
+	if user == nil {
+		return nil
+	}
+	if !user.HasRole(role) {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return nil
+	}
+	return user
+}
+
+// User from the authorization header, writes the error response in case of failure
+func authUser(w http.ResponseWriter, r *http.Request) *User {
+	user := _authUser(w, r)
+	if user == nil {
+		return nil
+	}
+	return slackRestrictedUser(r, user)
+}
+
+func _authUser(w http.ResponseWriter, r *http.Request) *User {
 	if r.Header["Authorization"] == nil || len(r.Header["Authorization"]) < 1 {
 		log.Printf("%s", r.Header)
 		http.Error(w, "bad syntax a", http.StatusBadRequest)
-		return false
+		return nil
 	}
 	auth := strings.SplitN(r.Header["Authorization"][0], " ", 2)
 
+	// Bearer token
+	if len(auth) == 2 && auth[0] == "Bearer" {
+		user := tokenManager.Authenticate(strings.TrimSpace(auth[1]))
+		if user == nil {
+			http.Error(w, "authorization failed", http.StatusUnauthorized)
+			return nil
+		}
+		return user
+	}
+
 	if len(auth) != 2 || auth[0] != "Basic" {
 		log.Printf("%s", r.Header)
 		http.Error(w, "bad syntax b", http.StatusBadRequest)
-		return false
+		return nil
 	}
 
 	payload, _ := base64.StdEncoding.DecodeString(auth[1])
 	pair := strings.SplitN(string(payload), ":", 2)
-
-	if len(pair) != 2 || !validateAuth(pair[0], pair[1]) {
+	if len(pair) != 2 {
 		http.Error(w, "authorization failed", http.StatusUnauthorized)
-		return false
+		return nil
 	}
-	return true
-}
-
-func validateAuth(username, password string) bool {
-	if username == basicAuthUsr && password == basicAuthPwd {
-		return true
+	user := userManager.Authenticate(pair[0], pair[1])
+	if user == nil {
+		http.Error(w, "authorization failed", http.StatusUnauthorized)
+		return nil
 	}
-	return false
+	return user
 }
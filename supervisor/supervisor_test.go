@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteEvent(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected string
+	}{
+		{"line", "id: 1\ndata: line\n\n"},
+		{"first\nsecond", "id: 1\ndata: first\ndata: second\n\n"},
+		{"first\r\nsecond\rthird", "id: 1\ndata: first\ndata: second\ndata: third\n\n"},
+		{"", "id: 1\ndata: \n\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		writeEvent(&buf, 1, test.data)
+		if buf.String() != test.expected {
+			t.Errorf("Expected %q, got %q", test.expected, buf.String())
+		}
+	}
+}
@@ -0,0 +1,393 @@
+// Storage of filter timeseries (statistics)
+// - tiers: raw, minutely and hourly copies, each with their own retention
+// - bolt: one key per metric and time bucket, appends only touch a single key
+// - memory: maps, used for testing
+// @author Robin Verlangen
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"log"
+	"math"
+	"sync"
+)
+
+type TimeseriesStore interface {
+	// Increment the counters of the data points
+	Add(points []*TimeseriesPoint) error
+
+	// Data points of a filter with a time bucket in [from, until)
+	Get(filterId string, from int64, until int64) (*FilterStats, error)
+
+	// Remove all data points with a time bucket before the timestamp, returns the amount removed
+	RemoveBefore(ts int64) (int, error)
+
+	// Remove all data points of a filter
+	Remove(filterId string) error
+
+	// Remove all data points
+	Truncate() error
+}
+
+// Downsampled copy of the timeseries, every data point is written to all tiers
+type TimeseriesTier struct {
+	Name       string
+	Resolution int64 // Seconds per time bucket, 0 keeps the time buckets as received
+	Store      TimeseriesStore
+}
+
+// Retention in seconds as configured in the supervisor, zero keeps the data points forever, negative disables the tier
+func (t *TimeseriesTier) Retention() int64 {
+	switch t.Name {
+	case "raw":
+		return conf.GetIntOrDefault("stats_retention_raw_hours", 7*24) * 3600
+	case "minutely":
+		return conf.GetIntOrDefault("stats_retention_minutely_days", 30) * 86400
+	case "hourly":
+		return conf.GetIntOrDefault("stats_retention_hourly_months", 12) * 31 * 86400
+	}
+	return 0
+}
+
+// Disabled tiers (negative retention) do not receive data points
+func (t *TimeseriesTier) Enabled() bool {
+	return t.Retention() >= 0
+}
+
+// Does the tier hold data points of the full window (in seconds)
+func (t *TimeseriesTier) Covers(window int64) bool {
+	retention := t.Retention()
+	return retention == 0 || retention >= window
+}
+
+// Data points rounded to the resolution of this tier
+func (t *TimeseriesTier) Downsample(points []*TimeseriesPoint) []*TimeseriesPoint {
+	if t.Resolution < 1 {
+		return points
+	}
+	list := make([]*TimeseriesPoint, 0)
+	for _, point := range points {
+		list = append(list, &TimeseriesPoint{
+			FilterId: point.FilterId,
+			Metric:   point.Metric,
+			Bucket:   point.Bucket - (point.Bucket % t.Resolution),
+			Count:    point.Count,
+		})
+	}
+	return list
+}
+
+type TimeseriesPoint struct {
+	FilterId string
+	Metric   int
+	Bucket   int64
+	Count    int64
+}
+
+// In memory timeseries store
+type MemoryTimeseriesStore struct {
+	data map[string]*FilterStats
+	mux  sync.RWMutex
+}
+
+func (s *MemoryTimeseriesStore) Add(points []*TimeseriesPoint) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, point := range points {
+		if s.data[point.FilterId] == nil {
+			s.data[point.FilterId] = newFilterStats()
+		}
+		stats := s.data[point.FilterId]
+		if stats.Metrics[point.Metric] == nil {
+			stats.Metrics[point.Metric] = newFilterTimeseries()
+		}
+		stats.Metrics[point.Metric].Data[point.Bucket] += point.Count
+	}
+	return nil
+}
+
+func (s *MemoryTimeseriesStore) Get(filterId string, from int64, until int64) (*FilterStats, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	res := newFilterStats()
+	if s.data[filterId] == nil {
+		return res, nil
+	}
+	for metric, timeseries := range s.data[filterId].Metrics {
+		res.Metrics[metric] = newFilterTimeseries()
+		for ts, val := range timeseries.Data {
+			if ts >= from && ts < until {
+				res.Metrics[metric].Data[ts] = val
+			}
+		}
+	}
+	return res, nil
+}
+
+func (s *MemoryTimeseriesStore) RemoveBefore(ts int64) (int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	removed := 0
+	for _, stats := range s.data {
+		for _, timeseries := range stats.Metrics {
+			for bucket, _ := range timeseries.Data {
+				if bucket < ts {
+					delete(timeseries.Data, bucket)
+					removed++
+				}
+			}
+		}
+	}
+	return removed, nil
+}
+
+func (s *MemoryTimeseriesStore) Remove(filterId string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.data, filterId)
+	return nil
+}
+
+func (s *MemoryTimeseriesStore) Truncate() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.data = make(map[string]*FilterStats)
+	return nil
+}
+
+// BoltDB timeseries store, layout: <table> => <filter id> => <metric><time bucket> => <count>
+type BoltTimeseriesStore struct {
+	db    *bolt.DB
+	table string
+}
+
+func (s *BoltTimeseriesStore) Add(points []*TimeseriesPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+	// Batch, concurrent updates are combined into a single transaction
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(s.table))
+		for _, point := range points {
+			fb, err := b.CreateBucketIfNotExists([]byte(point.FilterId))
+			if err != nil {
+				return err
+			}
+			k := timeseriesKey(point.Metric, point.Bucket)
+			var count int64 = point.Count
+			if v := fb.Get(k); v != nil {
+				count += int64(binary.BigEndian.Uint64(v))
+			}
+			if err := fb.Put(k, uint64Key(uint64(count))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltTimeseriesStore) Get(filterId string, from int64, until int64) (*FilterStats, error) {
+	res := newFilterStats()
+	err := s.db.View(func(tx *bolt.Tx) error {
+		fb := tx.Bucket([]byte(s.table)).Bucket([]byte(filterId))
+		if fb == nil {
+			return nil
+		}
+
+		// Keys are ordered by metric and time bucket, seek to the start of the window of every metric
+		c := fb.Cursor()
+		k, v := c.Seek(timeseriesKey(0, from))
+		for k != nil {
+			metric, ts := parseTimeseriesKey(k)
+			if ts < from {
+				k, v = c.Seek(timeseriesKey(metric, from))
+				continue
+			}
+			if ts >= until {
+				// Metrics are stored as uint32, the next metric of the last one wraps around to the first
+				if uint32(metric) == math.MaxUint32 {
+					break
+				}
+				k, v = c.Seek(timeseriesKey(metric+1, from))
+				continue
+			}
+			if res.Metrics[metric] == nil {
+				res.Metrics[metric] = newFilterTimeseries()
+			}
+			res.Metrics[metric].Data[ts] = int64(binary.BigEndian.Uint64(v))
+			k, v = c.Next()
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (s *BoltTimeseriesStore) RemoveBefore(ts int64) (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(s.table))
+		return b.ForEach(func(filterId []byte, v []byte) error {
+			fb := b.Bucket(filterId)
+			if fb == nil {
+				return nil
+			}
+
+			// Collect first, deleting while iterating moves the cursor
+			keys := make([][]byte, 0)
+			c := fb.Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				if _, bucket := parseTimeseriesKey(k); bucket < ts {
+					keys = append(keys, k)
+				}
+			}
+			for _, k := range keys {
+				if err := fb.Delete(k); err != nil {
+					return err
+				}
+				removed++
+			}
+			return nil
+		})
+	})
+	return removed, err
+}
+
+func (s *BoltTimeseriesStore) Remove(filterId string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket([]byte(s.table)).DeleteBucket([]byte(filterId))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+func (s *BoltTimeseriesStore) Truncate() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(s.table)); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket([]byte(s.table))
+		return err
+	})
+}
+
+// Convert the gob encoded blobs (one per filter) of older versions into the new layout, downsampled into every tier
+func migrateTimeseries(db *bolt.DB, legacyTable string, tiers []*TimeseriesTier) error {
+	points := make([]*TimeseriesPoint, 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(legacyTable))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k []byte, v []byte) error {
+			var stats *FilterStats
+			dec := gob.NewDecoder(bytes.NewReader(v))
+			if de := dec.Decode(&stats); de != nil || stats == nil {
+				log.Printf("Failed to load timeseries %s: %s", k, de)
+				return nil
+			}
+			for metric, timeseries := range stats.Metrics {
+				for ts, val := range timeseries.Data {
+					points = append(points, &TimeseriesPoint{FilterId: string(k), Metric: metric, Bucket: ts, Count: val})
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if len(points) > 0 {
+		log.Printf("Migrating %d timeseries data points", len(points))
+		for _, tier := range tiers {
+			if err := tier.Store.Add(tier.Downsample(points)); err != nil {
+				return errors.New(fmt.Sprintf("%s tier: %s", tier.Name, err))
+			}
+		}
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(legacyTable))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+}
+
+// Key of a single data point, 4 bytes metric followed by 8 bytes time bucket (big endian)
+func timeseriesKey(metric int, ts int64) []byte {
+	b := make([]byte, 12)
+	binary.BigEndian.PutUint32(b[0:4], uint32(metric))
+	binary.BigEndian.PutUint64(b[4:12], uint64(ts))
+	return b
+}
+
+func parseTimeseriesKey(k []byte) (int, int64) {
+	return int(binary.BigEndian.Uint32(k[0:4])), int64(binary.BigEndian.Uint64(k[4:12]))
+}
+
+func newMemoryTimeseriesStore() *MemoryTimeseriesStore {
+	return &MemoryTimeseriesStore{
+		data: make(map[string]*FilterStats),
+	}
+}
+
+func newBoltTimeseriesStore(db *bolt.DB, table string) (*BoltTimeseriesStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(table))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltTimeseriesStore{
+		db:    db,
+		table: table,
+	}, nil
+}
+
+// Timeseries store based on the type passed in the flags
+func newTimeseriesStore(storeType string, db *bolt.DB, table string) (TimeseriesStore, error) {
+	switch storeType {
+	case "memory":
+		return newMemoryTimeseriesStore(), nil
+	case "bolt":
+		return newBoltTimeseriesStore(db, table)
+	}
+	return nil, errors.New(fmt.Sprintf("Unsupported timeseries store %s", storeType))
+}
+
+// Tiers from fine to coarse: raw, minutely and hourly, data of older versions is migrated from the legacy table (optional)
+func newTimeseriesTiers(storeType string, db *bolt.DB, legacyTable string) ([]*TimeseriesTier, error) {
+	tiers := make([]*TimeseriesTier, 0)
+	for _, tier := range []*TimeseriesTier{
+		&TimeseriesTier{Name: "raw", Resolution: 0},
+		&TimeseriesTier{Name: "minutely", Resolution: 60},
+		&TimeseriesTier{Name: "hourly", Resolution: 3600},
+	} {
+		// Raw data is stored in the table of the single tier versions
+		table := "filter_timeseries"
+		if tier.Resolution > 0 {
+			table = fmt.Sprintf("filter_timeseries_%s", tier.Name)
+		}
+		store, err := newTimeseriesStore(storeType, db, table)
+		if err != nil {
+			return nil, err
+		}
+		tier.Store = store
+		tiers = append(tiers, tier)
+	}
+	if storeType == "bolt" && len(legacyTable) > 0 {
+		if err := migrateTimeseries(db, legacyTable, tiers); err != nil {
+			return nil, errors.New(fmt.Sprintf("Failed to migrate timeseries: %s", err))
+		}
+	}
+	return tiers, nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/boltdb/bolt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Memory and bolt stores, the bolt database is removed on cleanup
+func timeseriesTestStores(t *testing.T) (map[string]TimeseriesStore, func()) {
+	dir, err := ioutil.TempDir("", "timeseries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := bolt.Open(filepath.Join(dir, "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	boltStore, err := newBoltTimeseriesStore(db, "filter_timeseries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stores := map[string]TimeseriesStore{
+		"memory": newMemoryTimeseriesStore(),
+		"bolt":   boltStore,
+	}
+	return stores, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func timeseriesTestCount(stats *FilterStats, metric int, bucket int64) int64 {
+	if stats.Metrics[metric] == nil {
+		return 0
+	}
+	return stats.Metrics[metric].Data[bucket]
+}
+
+func timeseriesTestSize(stats *FilterStats) int {
+	n := 0
+	for _, timeseries := range stats.Metrics {
+		n += len(timeseries.Data)
+	}
+	return n
+}
+
+func TestTimeseriesStoreGet(t *testing.T) {
+	stores, cleanup := timeseriesTestStores(t)
+	defer cleanup()
+	for name, store := range stores {
+		err := store.Add([]*TimeseriesPoint{
+			&TimeseriesPoint{FilterId: "a", Metric: 1, Bucket: 60, Count: 1},
+			&TimeseriesPoint{FilterId: "a", Metric: 1, Bucket: 60, Count: 2},
+			&TimeseriesPoint{FilterId: "a", Metric: 1, Bucket: 120, Count: 5},
+			&TimeseriesPoint{FilterId: "a", Metric: 1, Bucket: 180, Count: 7},
+			&TimeseriesPoint{FilterId: "a", Metric: 2, Bucket: 0, Count: 4},
+			&TimeseriesPoint{FilterId: "a", Metric: 2, Bucket: 120, Count: 3},
+			&TimeseriesPoint{FilterId: "a", Metric: math.MaxUint32, Bucket: 120, Count: 9},
+			&TimeseriesPoint{FilterId: "a", Metric: math.MaxUint32, Bucket: 240, Count: 9},
+			&TimeseriesPoint{FilterId: "b", Metric: 1, Bucket: 120, Count: 8},
+		})
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		// Window [60, 180) of every metric, the last possible metric ends the scan
+		stats, err := store.Get("a", 60, 180)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		tests := []struct {
+			metric int
+			bucket int64
+			count  int64
+		}{
+			{1, 60, 3},
+			{1, 120, 5},
+			{1, 180, 0},
+			{2, 0, 0},
+			{2, 120, 3},
+			{math.MaxUint32, 120, 9},
+			{math.MaxUint32, 240, 0},
+		}
+		for _, test := range tests {
+			if count := timeseriesTestCount(stats, test.metric, test.bucket); count != test.count {
+				t.Errorf("%s: expected %d of metric %d at %d, got %d", name, test.count, test.metric, test.bucket, count)
+			}
+		}
+		if size := timeseriesTestSize(stats); size != 4 {
+			t.Errorf("%s: expected 4 data points in the window, got %d", name, size)
+		}
+
+		// Unknown filter
+		stats, err = store.Get("c", 0, math.MaxInt64)
+		if err != nil || timeseriesTestSize(stats) != 0 {
+			t.Errorf("%s: expected no data points of an unknown filter, got %d (%v)", name, timeseriesTestSize(stats), err)
+		}
+	}
+}
+
+func TestTimeseriesStoreRemove(t *testing.T) {
+	stores, cleanup := timeseriesTestStores(t)
+	defer cleanup()
+	for name, store := range stores {
+		store.Add([]*TimeseriesPoint{
+			&TimeseriesPoint{FilterId: "a", Metric: 1, Bucket: 60, Count: 1},
+			&TimeseriesPoint{FilterId: "a", Metric: 1, Bucket: 120, Count: 1},
+			&TimeseriesPoint{FilterId: "a", Metric: 2, Bucket: 60, Count: 1},
+			&TimeseriesPoint{FilterId: "b", Metric: 1, Bucket: 60, Count: 1},
+			&TimeseriesPoint{FilterId: "b", Metric: 1, Bucket: 180, Count: 1},
+		})
+
+		// Retention
+		removed, err := store.RemoveBefore(120)
+		if err != nil || removed != 3 {
+			t.Errorf("%s: expected 3 removed data points, got %d (%v)", name, removed, err)
+		}
+		stats, _ := store.Get("a", 0, math.MaxInt64)
+		if size := timeseriesTestSize(stats); size != 1 || timeseriesTestCount(stats, 1, 120) != 1 {
+			t.Errorf("%s: expected the data point at 120 to be kept, got %d data points", name, size)
+		}
+
+		// Filter
+		if err := store.Remove("a"); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if err := store.Remove("unknown"); err != nil {
+			t.Errorf("%s: removing an unknown filter failed: %s", name, err)
+		}
+		stats, _ = store.Get("a", 0, math.MaxInt64)
+		if size := timeseriesTestSize(stats); size != 0 {
+			t.Errorf("%s: expected no data points of the removed filter, got %d", name, size)
+		}
+		stats, _ = store.Get("b", 0, math.MaxInt64)
+		if size := timeseriesTestSize(stats); size != 1 {
+			t.Errorf("%s: expected the data point of the other filter to be kept, got %d", name, size)
+		}
+
+		// Everything
+		if err := store.Truncate(); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		stats, _ = store.Get("b", 0, math.MaxInt64)
+		if size := timeseriesTestSize(stats); size != 0 {
+			t.Errorf("%s: expected no data points after truncate, got %d", name, size)
+		}
+	}
+}
+
+func TestTimeseriesTierDownsample(t *testing.T) {
+	points := []*TimeseriesPoint{
+		&TimeseriesPoint{FilterId: "a", Metric: 1, Bucket: 59, Count: 1},
+		&TimeseriesPoint{FilterId: "a", Metric: 1, Bucket: 3661, Count: 2},
+	}
+	tests := []struct {
+		resolution int64
+		buckets    []int64
+	}{
+		{0, []int64{59, 3661}},
+		{60, []int64{0, 3660}},
+		{3600, []int64{0, 3600}},
+	}
+	for _, test := range tests {
+		tier := &TimeseriesTier{Name: "test", Resolution: test.resolution, Store: newMemoryTimeseriesStore()}
+		for i, point := range tier.Downsample(points) {
+			if point.Bucket != test.buckets[i] || point.Count != points[i].Count {
+				t.Errorf("Resolution %d: expected bucket %d with count %d, got %d with %d", test.resolution, test.buckets[i], points[i].Count, point.Bucket, point.Count)
+			}
+		}
+	}
+}
+
+func TestTimeseriesTierRetention(t *testing.T) {
+	conf = &Conf{data: map[string]string{
+		"stats_retention_raw_hours":     "-1",
+		"stats_retention_minutely_days": "0",
+	}}
+	raw := &TimeseriesTier{Name: "raw"}
+	minutely := &TimeseriesTier{Name: "minutely", Resolution: 60}
+	if raw.Enabled() {
+		t.Errorf("Raw tier with negative retention is enabled")
+	}
+	if !minutely.Enabled() || !minutely.Covers(math.MaxInt32) {
+		t.Errorf("Minutely tier with retention 0 does not keep data points forever")
+	}
+}
+
+func TestTimeseriesMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "timeseries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := bolt.Open(filepath.Join(dir, "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Legacy layout: one gob encoded blob per filter
+	stats := &FilterStats{Metrics: map[int]*FilterTimeseries{
+		1: &FilterTimeseries{Data: map[int64]int64{3601: 1, 3659: 2, 7300: 4}},
+	}}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(stats); err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("filter_stats"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("a"), buf.Bytes())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tiers, err := newTimeseriesTiers("bolt", db, "filter_stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]map[int64]int64{
+		"raw":      {3601: 1, 3659: 2, 7300: 4},
+		"minutely": {3600: 3, 7260: 4},
+		"hourly":   {3600: 3, 7200: 4},
+	}
+	for _, tier := range tiers {
+		migrated, err := tier.Store.Get("a", 0, 10000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if timeseriesTestSize(migrated) != len(expected[tier.Name]) {
+			t.Errorf("%s: expected %d data points, got %d", tier.Name, len(expected[tier.Name]), timeseriesTestSize(migrated))
+		}
+		for bucket, count := range expected[tier.Name] {
+			if n := timeseriesTestCount(migrated, 1, bucket); n != count {
+				t.Errorf("%s: expected %d at %d, got %d", tier.Name, count, bucket, n)
+			}
+		}
+	}
+	db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("filter_stats")) != nil {
+			t.Errorf("Legacy table was not removed")
+		}
+		return nil
+	})
+}
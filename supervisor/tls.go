@@ -0,0 +1,198 @@
+// TLS for the supervisor and Slack listeners
+// - Certificates are configured with flags or conf keys, without a certificate the listener uses plain HTTP
+// - Client certificates (optional) are verified against a CA bundle, the storm ingest routes then require one
+// - A client certificate identifies a user with the writer role on the ingest routes only, other routes use the authorization header
+// - Certificates are reloaded on SIGHUP
+// @author Robin Verlangen
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+type TlsReloader struct {
+	name         string
+	certFile     string
+	keyFile      string
+	clientCaFile string
+
+	cert      *tls.Certificate
+	clientCas *x509.CertPool
+	mux       sync.RWMutex
+}
+
+// Read the certificate, key and client CA bundle from disk, the current ones are kept in case of errors
+func (t *TlsReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Failed to load certificate of %s: %s", t.name, err))
+	}
+	var clientCas *x509.CertPool
+	if len(t.clientCaFile) > 0 {
+		pem, err := ioutil.ReadFile(t.clientCaFile)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Failed to load client CA of %s: %s", t.name, err))
+		}
+		clientCas = x509.NewCertPool()
+		if !clientCas.AppendCertsFromPEM(pem) {
+			return errors.New(fmt.Sprintf("No certificates found in client CA %s", t.clientCaFile))
+		}
+	}
+	t.mux.Lock()
+	t.cert = &cert
+	t.clientCas = clientCas
+	t.mux.Unlock()
+	log.Printf("Loaded certificate %s for %s", t.certFile, t.name)
+	return nil
+}
+
+func (t *TlsReloader) RequiresClientCert() bool {
+	return len(t.clientCaFile) > 0
+}
+
+// Config that always uses the most recently loaded certificates
+func (t *TlsReloader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			t.mux.RLock()
+			defer t.mux.RUnlock()
+			return t.cert, nil
+		},
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			t.mux.RLock()
+			defer t.mux.RUnlock()
+			c := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*t.cert},
+			}
+			if t.clientCas != nil {
+				// Clients without certificate (e.g. the CLI) authenticate with basic auth or tokens
+				c.ClientCAs = t.clientCas
+				c.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return c, nil
+		},
+	}
+}
+
+// Certificate file from the flag, falls back to the conf key
+func tlsFile(flagValue string, confKey string) string {
+	if len(flagValue) > 0 {
+		return flagValue
+	}
+	return conf.Get(confKey)
+}
+
+// TLS reloader, nil in case no certificate is configured
+func newTlsReloader(name string, certFile string, keyFile string, clientCaFile string) (*TlsReloader, error) {
+	if len(certFile) < 1 && len(keyFile) < 1 {
+		if len(clientCaFile) > 0 {
+			return nil, errors.New(fmt.Sprintf("Client certificates of %s require a server certificate", name))
+		}
+		return nil, nil
+	}
+	t := &TlsReloader{
+		name:         name,
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCaFile: clientCaFile,
+	}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Reload all certificates on SIGHUP
+func reloadTlsOnSighup(reloaders ...*TlsReloader) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			log.Println("Received SIGHUP, reloading certificates")
+			for _, t := range reloaders {
+				if t == nil {
+					continue
+				}
+				if err := t.Reload(); err != nil {
+					log.Println(err)
+				}
+			}
+		}
+	}()
+}
+
+// Serve HTTP, or HTTPS in case the reloader is set
+func listenAndServe(port int, handler http.Handler, t *TlsReloader) error {
+	if t == nil {
+		return http.ListenAndServe(fmt.Sprintf(":%d", port), handler)
+	}
+	server := &http.Server{
+		Addr:      fmt.Sprintf(":%d", port),
+		Handler:   handler,
+		TLSConfig: t.Config(),
+	}
+	return server.ListenAndServeTLS("", "")
+}
+
+// User of a verified client certificate (storm ingest), nil if none was presented
+func clientCertUser(r *http.Request) *User {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return &User{
+		Username: r.TLS.VerifiedChains[0][0].Subject.CommonName,
+		Role:     ROLE_WRITER,
+	}
+}
+
+// Ingest routes require a client certificate in case client certificates are configured
+func clientCertAuth(w http.ResponseWriter, r *http.Request) bool {
+	if apiTls == nil || !apiTls.RequiresClientCert() {
+		return true
+	}
+	if clientCertUser(r) == nil {
+		http.Error(w, "client certificate required", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// User of the storm ingest routes: the client certificate in case there is no authorization header, otherwise the writer of the header
+func ingestAuthUser(w http.ResponseWriter, r *http.Request) *User {
+	if !clientCertAuth(w, r) {
+		return nil
+	}
+	if len(r.Header.Get("Authorization")) < 1 {
+		if user := clientCertUser(r); user != nil {
+			return user
+		}
+	}
+	return roleAuthUser(w, r, ROLE_WRITER)
+}
+
+// Ingest of a single filter, tokens can be restricted to certain filters
+func ingestFilterAuth(w http.ResponseWriter, r *http.Request, filterId string) bool {
+	user := ingestAuthUser(w, r)
+	if user == nil {
+		return false
+	}
+	if !user.CanAccessFilter(strings.TrimSpace(filterId)) {
+		http.Error(w, "permission denied", http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,228 @@
+// Token manager
+// - Bearer tokens are issued on login, with a role (scope), optional filter restrictions and an expiry
+// - Only a hash of the token is stored, the token itself is returned once on login
+// - Tokens can be revoked by the owner or an admin
+// @author Robin Verlangen
+
+package main
+
+import (
+	"code.google.com/p/go-uuid/uuid"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"log"
+	"time"
+)
+
+type TokenManager struct {
+	db         *bolt.DB
+	tokenTable string
+}
+
+type Token struct {
+	Id       string   `json:"id"`
+	Username string   `json:"username"`
+	Role     string   `json:"role"`
+	Filters  []string `json:"filters"` // Filter IDs, empty allows all filters
+	Created  int64    `json:"created"`
+	Expires  int64    `json:"expires"` // Unix timestamp, 0 never expires
+	Hash     string   `json:"hash"`
+}
+
+func (t *Token) Expired() bool {
+	return t.Expires > 0 && t.Expires < time.Now().Unix()
+}
+
+// Public representation of the token, without the hash
+func (t *Token) Public() map[string]interface{} {
+	return map[string]interface{}{
+		"id":       t.Id,
+		"username": t.Username,
+		"role":     t.Role,
+		"filters":  t.Filters,
+		"created":  t.Created,
+		"expires":  t.Expires,
+	}
+}
+
+func hashToken(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// Issue a new token for the user, the role can not exceed the role of the user, returns the secret
+func (tm *TokenManager) CreateToken(user *User, role string, filters []string, ttl int64) (*Token, string, error) {
+	// Validate
+	if roleLevel(role) == 0 {
+		return nil, "", errors.New(fmt.Sprintf("Unsupported role %s, use %s, %s or %s", role, ROLE_READER, ROLE_WRITER, ROLE_ADMIN))
+	}
+	if !user.HasRole(role) {
+		return nil, "", errors.New(fmt.Sprintf("User %s is not allowed to use role %s", user.Username, role))
+	}
+	if ttl < 0 {
+		return nil, "", errors.New("Expiry can not be negative")
+	}
+
+	// Secret
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	secret := hex.EncodeToString(b)
+
+	// Token
+	now := time.Now().Unix()
+	token := &Token{
+		Id:       uuid.New(),
+		Username: user.Username,
+		Role:     role,
+		Filters:  filters,
+		Created:  now,
+		Hash:     hashToken(secret),
+	}
+	if ttl > 0 {
+		token.Expires = now + ttl
+	}
+
+	// Store
+	tb, err := json.Marshal(token)
+	if err != nil {
+		return nil, "", err
+	}
+	err = tm.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(tm.tokenTable)).Put([]byte(token.Hash), tb)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	log.Printf("Issued token %s for user %s with role %s", token.Id, token.Username, token.Role)
+	return token, secret, nil
+}
+
+func (tm *TokenManager) GetTokens() []*Token {
+	list := make([]*Token, 0)
+	tm.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(tm.tokenTable)).ForEach(func(k []byte, v []byte) error {
+			token := &Token{}
+			if err := json.Unmarshal(v, token); err != nil {
+				log.Printf("Failed json umarshal %s", err)
+				return nil
+			}
+			list = append(list, token)
+			return nil
+		})
+	})
+	return list
+}
+
+func (tm *TokenManager) GetToken(secret string) *Token {
+	var token *Token
+	tm.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(tm.tokenTable)).Get([]byte(hashToken(secret)))
+		if v == nil {
+			return nil
+		}
+		token = &Token{}
+		if err := json.Unmarshal(v, token); err != nil {
+			log.Printf("Failed json umarshal %s", err)
+			token = nil
+		}
+		return nil
+	})
+	return token
+}
+
+// Revoke a token by ID, only the owner of the token or an admin is allowed to do this
+func (tm *TokenManager) RevokeToken(id string, user *User) bool {
+	for _, token := range tm.GetTokens() {
+		if token.Id != id {
+			continue
+		}
+		if token.Username != user.Username && !user.HasRole(ROLE_ADMIN) {
+			return false
+		}
+		err := tm.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte(tm.tokenTable)).Delete([]byte(token.Hash))
+		})
+		if err != nil {
+			log.Printf("Failed to revoke token %s: %s", id, err)
+			return false
+		}
+		log.Printf("Revoked token %s", id)
+		return true
+	}
+	return false
+}
+
+// Validate a token, returns the user with the role and filters of the token (nil if invalid)
+func (tm *TokenManager) Authenticate(secret string) *User {
+	token := tm.GetToken(secret)
+	if token == nil || token.Expired() {
+		return nil
+	}
+
+	// The user must still exist, the role of the token is capped at the current role of the user
+	var owner *User
+	if token.Username == basicAuthUsr {
+		owner = &User{Username: basicAuthUsr, Role: ROLE_ADMIN}
+	} else {
+		owner = userManager.GetUser(token.Username)
+	}
+	if owner == nil {
+		return nil
+	}
+	role := token.Role
+	if !owner.HasRole(role) {
+		role = owner.Role
+	}
+	return &User{
+		Username: owner.Username,
+		Role:     role,
+		Filters:  token.Filters,
+		TokenId:  token.Id,
+	}
+}
+
+// Remove expired tokens every once in a while
+func (tm *TokenManager) Cleaner() {
+	go func() {
+		for {
+			time.Sleep(1 * time.Hour)
+			for _, token := range tm.GetTokens() {
+				if !token.Expired() {
+					continue
+				}
+				tm.db.Update(func(tx *bolt.Tx) error {
+					return tx.Bucket([]byte(tm.tokenTable)).Delete([]byte(token.Hash))
+				})
+				if verbose {
+					log.Printf("Removed expired token %s", token.Id)
+				}
+			}
+		}
+	}()
+}
+
+// Init the token manager
+func NewTokenManager(db *bolt.DB) *TokenManager {
+	tm := &TokenManager{
+		db:         db,
+		tokenTable: "tokens",
+	}
+
+	// Create bucket
+	err := tm.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(tm.tokenTable))
+		return err
+	})
+	if err != nil {
+		log.Fatal(fmt.Errorf("create bucket: %s", err))
+	}
+	tm.Cleaner()
+	return tm
+}
@@ -0,0 +1,283 @@
+// User manager
+// - Users are stored in BoltDB next to the filters, passwords are hashed with bcrypt
+// - Roles: reader (query), writer (reader + filters, alerts and ingest of results and statistics), admin (everything)
+// - The user from the -auth-user/-auth-password flags is always accepted, admin operations still require the admin password for this user
+// @author Robin Verlangen
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"golang.org/x/crypto/bcrypt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const ROLE_READER string = "reader"
+const ROLE_WRITER string = "writer"
+const ROLE_ADMIN string = "admin"
+
+const PASSWORD_BCRYPT_COST int = bcrypt.DefaultCost
+
+type UserManager struct {
+	db        *bolt.DB
+	userTable string
+
+	// Caches
+	usersCache    map[string]*User
+	usersCacheMux sync.RWMutex
+
+	// Verified passwords, bcrypt is too slow for every request (username => HMAC of the password with a random key)
+	verified    map[string]string
+	verifiedKey []byte
+	verifiedMux sync.RWMutex
+}
+
+type User struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Hash     string `json:"hash"` // bcrypt
+	Created  int64  `json:"created"`
+
+	// Restrictions of the bearer token used (not stored)
+	Filters []string `json:"-"`
+	TokenId string   `json:"-"`
+}
+
+// Public representation of the user, without the password hash
+func (u *User) Public() map[string]interface{} {
+	return map[string]interface{}{
+		"username": u.Username,
+		"role":     u.Role,
+		"created":  u.Created,
+	}
+}
+
+// Does the role of the user allow actions that require the given role
+func (u *User) HasRole(role string) bool {
+	return roleLevel(u.Role) >= roleLevel(role)
+}
+
+// Is the user allowed to access the filter, tokens can be restricted to certain filters
+func (u *User) CanAccessFilter(filterId string) bool {
+	if len(u.Filters) == 0 {
+		return true
+	}
+	for _, id := range u.Filters {
+		if id == filterId {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *User) ValidatePassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.Hash), []byte(password)) == nil
+}
+
+// Roles are ordered, every role includes the rights of the roles below
+func roleLevel(role string) int {
+	switch role {
+	case ROLE_READER:
+		return 1
+	case ROLE_WRITER:
+		return 2
+	case ROLE_ADMIN:
+		return 3
+	}
+	return 0
+}
+
+func hashPassword(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), PASSWORD_BCRYPT_COST)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (um *UserManager) CreateUser(username string, password string, role string) error {
+	// Validate
+	if ok, _ := regexp.MatchString("^[a-zA-Z0-9_.@-]+$", username); !ok {
+		return errors.New("Please provide a valid username (letters, digits, _ . @ -)")
+	}
+	if username == basicAuthUsr {
+		return errors.New(fmt.Sprintf("User %s is configured through the supervisor flags", username))
+	}
+	if len(password) < 8 {
+		return errors.New("Password must be at least 8 characters")
+	}
+	if roleLevel(role) == 0 {
+		return errors.New(fmt.Sprintf("Unsupported role %s, use %s, %s or %s", role, ROLE_READER, ROLE_WRITER, ROLE_ADMIN))
+	}
+	if um.GetUser(username) != nil {
+		return errors.New(fmt.Sprintf("There already exist an user with the name %s", username))
+	}
+
+	// Hash
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	user := &User{
+		Username: username,
+		Role:     role,
+		Hash:     hash,
+		Created:  time.Now().Unix(),
+	}
+	if err := um.saveUser(user); err != nil {
+		return err
+	}
+	log.Printf("Created user %s with role %s", user.Username, user.Role)
+	return nil
+}
+
+func (um *UserManager) saveUser(user *User) error {
+	b, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	err = um.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(um.userTable)).Put([]byte(user.Username), b)
+	})
+	um.clearCache()
+	return err
+}
+
+func (um *UserManager) GetUsers() map[string]*User {
+	// Cache
+	um.usersCacheMux.RLock()
+	if um.usersCache != nil {
+		defer um.usersCacheMux.RUnlock()
+		return um.usersCache
+	}
+	um.usersCacheMux.RUnlock()
+
+	// Load
+	users := make(map[string]*User)
+	um.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(um.userTable)).ForEach(func(k []byte, v []byte) error {
+			user := &User{}
+			if err := json.Unmarshal(v, user); err != nil {
+				log.Printf("Failed json umarshal %s", err)
+				return nil
+			}
+			users[user.Username] = user
+			return nil
+		})
+	})
+
+	// Update cache
+	um.usersCacheMux.Lock()
+	um.usersCache = users
+	um.usersCacheMux.Unlock()
+	return users
+}
+
+func (um *UserManager) GetUser(username string) *User {
+	return um.GetUsers()[username]
+}
+
+// Current role of the user, empty in case the user does not exist (anymore)
+func (um *UserManager) GetRole(username string) string {
+	if username == basicAuthUsr {
+		return ROLE_ADMIN
+	}
+	if user := um.GetUser(username); user != nil {
+		return user.Role
+	}
+	return ""
+}
+
+func (um *UserManager) DeleteUser(username string) bool {
+	if um.GetUser(username) == nil {
+		return false
+	}
+	err := um.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(um.userTable)).Delete([]byte(username))
+	})
+	um.clearCache()
+	if err != nil {
+		log.Printf("Failed to remove user %s: %s", username, err)
+		return false
+	}
+	log.Printf("Removed user %s", username)
+	return true
+}
+
+// Validate credentials, returns the user (nil if invalid)
+func (um *UserManager) Authenticate(username string, password string) *User {
+	// User from the flags
+	if username == basicAuthUsr {
+		if subtle.ConstantTimeCompare([]byte(password), []byte(basicAuthPwd)) == 1 {
+			return &User{Username: basicAuthUsr, Role: ROLE_ADMIN}
+		}
+		return nil
+	}
+
+	// Stored users
+	user := um.GetUser(username)
+	if user == nil {
+		return nil
+	}
+	mac := hmac.New(sha256.New, um.verifiedKey)
+	mac.Write([]byte(password))
+	verified := hex.EncodeToString(mac.Sum(nil))
+	um.verifiedMux.RLock()
+	cached := um.verified[username]
+	um.verifiedMux.RUnlock()
+	if len(cached) > 0 && hmac.Equal([]byte(cached), []byte(verified)) {
+		return user
+	}
+	if !user.ValidatePassword(password) {
+		return nil
+	}
+	um.verifiedMux.Lock()
+	um.verified[username] = verified
+	um.verifiedMux.Unlock()
+	return user
+}
+
+func (um *UserManager) clearCache() {
+	um.usersCacheMux.Lock()
+	um.usersCache = nil
+	um.usersCacheMux.Unlock()
+	um.verifiedMux.Lock()
+	um.verified = make(map[string]string)
+	um.verifiedMux.Unlock()
+}
+
+// Init the user manager
+func NewUserManager(db *bolt.DB) *UserManager {
+	um := &UserManager{
+		db:        db,
+		userTable: "users",
+		verified:  make(map[string]string),
+	}
+
+	// Key of the verified passwords, only valid within this process
+	um.verifiedKey = make([]byte, 32)
+	if _, err := rand.Read(um.verifiedKey); err != nil {
+		log.Fatal(fmt.Errorf("verified password key: %s", err))
+	}
+
+	// Create bucket
+	err := um.db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(um.userTable))
+		return err
+	})
+	if err != nil {
+		log.Fatal(fmt.Errorf("create bucket: %s", err))
+	}
+	return um
+}
@@ -0,0 +1,214 @@
+// Fake BigQuery API used for testing the search backend of the supervisor
+// - POST /token issues an access token for any JWT assertion
+// - POST /projects/<project>/queries starts a query, the results are returned in pages by GET /projects/<project>/queries/<job>
+// - Queries containing "fail" return an error, queries containing "slow" never finish (until cancelled),
+//   queries containing "broken" fail after the first page
+// - POST /projects/<project>/jobs/<job>/cancel cancels a job, GET /cancelled returns the amount of cancelled jobs
+// - GET /projects/<project>/datasets/<dataset> finds every dataset except "missing" (health checks)
+// Configure the supervisor with search_backends.<id>.api_url=http://localhost:1527 and token_url=http://localhost:1527/token
+// @author Robin Verlangen
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ACCESS_TOKEN string = "fake-access-token"
+
+var port int
+var rows int
+var pageSize int
+
+type job struct {
+	id        string
+	query     string
+	polls     int
+	cancelled bool
+}
+
+var jobs map[string]*job = make(map[string]*job)
+var jobsMux sync.Mutex
+var jobCounter int
+
+func init() {
+	flag.IntVar(&port, "port", 1527, "Port")
+	flag.IntVar(&rows, "rows", 5, "Rows of every query")
+	flag.IntVar(&pageSize, "page-size", 2, "Rows per page")
+	flag.Parse()
+}
+
+func main() {
+	http.HandleFunc("/token", token)
+	http.HandleFunc("/projects/", projects)
+	http.HandleFunc("/cancelled", cancelled)
+	log.Printf("Starting fake BigQuery at port %d", port)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+}
+
+func token(w http.ResponseWriter, r *http.Request) {
+	if r.PostFormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || len(r.PostFormValue("assertion")) < 1 {
+		writeError(w, http.StatusBadRequest, "invalid grant")
+		return
+	}
+	log.Printf("Issued token")
+	writeJson(w, map[string]interface{}{
+		"access_token": ACCESS_TOKEN,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+	})
+}
+
+// /projects/<project>/queries[/<job>], /projects/<project>/jobs/<job>/cancel and /projects/<project>/datasets/<dataset>
+func projects(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != fmt.Sprintf("Bearer %s", ACCESS_TOKEN) {
+		writeError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 3 && parts[2] == "queries" && r.Method == "POST":
+		startQuery(w, r)
+	case len(parts) == 4 && parts[2] == "queries" && r.Method == "GET":
+		queryResults(w, r, parts[3])
+	case len(parts) == 5 && parts[2] == "jobs" && parts[4] == "cancel" && r.Method == "POST":
+		cancelJob(w, parts[3])
+	case len(parts) == 4 && parts[2] == "datasets" && r.Method == "GET":
+		if parts[3] == "missing" {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("Not found: Dataset %s:%s", parts[1], parts[3]))
+			return
+		}
+		writeJson(w, map[string]interface{}{"id": fmt.Sprintf("%s:%s", parts[1], parts[3])})
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("not found: %s %s", r.Method, r.URL.Path))
+	}
+}
+
+func startQuery(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Query        string `json:"query"`
+		UseLegacySql bool   `json:"useLegacySql"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid body: %s", err))
+		return
+	}
+	log.Printf("Query: %s", body.Query)
+	if strings.Contains(body.Query, "fail") {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Invalid query: %s", body.Query))
+		return
+	}
+
+	// The results are never part of the first response, this tests polling
+	jobsMux.Lock()
+	jobCounter++
+	j := &job{id: fmt.Sprintf("job_%d", jobCounter), query: body.Query}
+	jobs[j.id] = j
+	jobsMux.Unlock()
+	writeJson(w, map[string]interface{}{
+		"jobReference": map[string]string{"jobId": j.id, "location": "EU"},
+		"jobComplete":  false,
+	})
+}
+
+func queryResults(w http.ResponseWriter, r *http.Request, jobId string) {
+	jobsMux.Lock()
+	j := jobs[jobId]
+	if j != nil {
+		j.polls++
+	}
+	jobsMux.Unlock()
+	if j == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Job %s not found", jobId))
+		return
+	}
+	if j.cancelled {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Job %s was cancelled", jobId))
+		return
+	}
+	if strings.Contains(j.query, "slow") {
+		time.Sleep(time.Second)
+		writeJson(w, map[string]interface{}{"jobReference": map[string]string{"jobId": j.id}, "jobComplete": false})
+		return
+	}
+
+	// Page
+	offset, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+	if offset > 0 && strings.Contains(j.query, "broken") {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Backend error reading page %d of job %s", offset/pageSize+1, jobId))
+		return
+	}
+	list := make([]interface{}, 0)
+	for i := offset; i < rows && i < offset+pageSize; i++ {
+		list = append(list, map[string]interface{}{
+			"f": []interface{}{
+				map[string]interface{}{"v": fmt.Sprintf("line %d of %s", i+1, j.id)},
+				map[string]interface{}{"v": nil},
+			},
+		})
+	}
+	res := map[string]interface{}{
+		"jobReference": map[string]string{"jobId": j.id},
+		"jobComplete":  true,
+		"totalRows":    fmt.Sprintf("%d", rows),
+		"schema": map[string]interface{}{
+			"fields": []interface{}{
+				map[string]string{"name": "_raw", "type": "STRING"},
+				map[string]string{"name": "host", "type": "STRING"},
+			},
+		},
+		"rows": list,
+	}
+	if offset+pageSize < rows {
+		res["pageToken"] = fmt.Sprintf("%d", offset+pageSize)
+	}
+	writeJson(w, res)
+}
+
+func cancelJob(w http.ResponseWriter, jobId string) {
+	jobsMux.Lock()
+	j := jobs[jobId]
+	if j != nil {
+		j.cancelled = true
+	}
+	jobsMux.Unlock()
+	if j == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("Job %s not found", jobId))
+		return
+	}
+	log.Printf("Cancelled job %s", jobId)
+	writeJson(w, map[string]interface{}{"job": map[string]string{"id": jobId}})
+}
+
+func cancelled(w http.ResponseWriter, r *http.Request) {
+	jobsMux.Lock()
+	defer jobsMux.Unlock()
+	n := 0
+	for _, j := range jobs {
+		if j.cancelled {
+			n++
+		}
+	}
+	writeJson(w, map[string]interface{}{"cancelled": n})
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": msg},
+	})
+}
@@ -0,0 +1,184 @@
+// Fake Slack used for testing the Slack integration of the supervisor
+// - POST /command signs a slash command (form values text, user_name and channel_name) and sends it to the supervisor,
+//   the response_url of the command is /response/<id> of this server, the direct response of the supervisor is returned
+// - POST /response/<id> records a message, the first posts fail in case of -fail (429 with Retry-After, then 500)
+// - GET /responses/<id> returns the recorded messages of a command
+// - POST /webhook records a message of the incoming webhook, GET /responses/webhook returns them
+// - GET /attempts/<id> returns the amount of posts to the response_url of a command, including the failed posts
+// Configure the supervisor with slack_signing_secret=<secret> and slack_incoming_webhook=http://localhost:1528/webhook
+// @author Robin Verlangen
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var port int
+var supervisorUri string
+var signingSecret string
+var failures int
+
+var responses map[string][]interface{} = make(map[string][]interface{})
+var attempts map[string]int = make(map[string]int)
+var responsesMux sync.Mutex
+var commandCounter int
+
+func init() {
+	flag.IntVar(&port, "port", 1528, "Port")
+	flag.StringVar(&supervisorUri, "supervisor", "http://localhost:1526", "Slack service of the supervisor")
+	flag.StringVar(&signingSecret, "signing-secret", "secret", "Signing secret")
+	flag.IntVar(&failures, "fail", 0, "Failed posts of every response_url before messages are accepted")
+	flag.Parse()
+}
+
+func main() {
+	http.HandleFunc("/command", command)
+	http.HandleFunc("/response/", response)
+	http.HandleFunc("/webhook", webhook)
+	http.HandleFunc("/responses/", listResponses)
+	http.HandleFunc("/attempts/", listAttempts)
+	log.Printf("Starting fake Slack at port %d", port)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+}
+
+// Slash command, sent to the supervisor the way Slack does
+func command(w http.ResponseWriter, r *http.Request) {
+	responsesMux.Lock()
+	commandCounter++
+	id := fmt.Sprintf("%d", commandCounter)
+	responses[id] = make([]interface{}, 0)
+	responsesMux.Unlock()
+
+	form := url.Values{}
+	form.Set("text", r.FormValue("text"))
+	form.Set("user_id", fmt.Sprintf("U%s", strings.ToUpper(r.FormValue("user_name"))))
+	form.Set("user_name", r.FormValue("user_name"))
+	form.Set("channel_id", fmt.Sprintf("C%s", strings.ToUpper(r.FormValue("channel_name"))))
+	form.Set("channel_name", r.FormValue("channel_name"))
+	form.Set("command", "/cloudpelican")
+	form.Set("response_url", fmt.Sprintf("http://localhost:%d/response/%s", port, id))
+	body := []byte(form.Encode())
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/slack", strings.TrimRight(supervisorUri, "/")), bytes.NewReader(body))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte(fmt.Sprintf("v0:%s:", timestamp)))
+	mac.Write(body)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", fmt.Sprintf("v0=%s", hex.EncodeToString(mac.Sum(nil))))
+
+	// Slack gives up after 3 seconds
+	start := time.Now()
+	resp, err := (&http.Client{Timeout: 3 * time.Second}).Do(req)
+	if err != nil {
+		writeError(w, http.StatusGatewayTimeout, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	var msg interface{}
+	if json.Unmarshal(respBody, &msg) != nil {
+		msg = string(respBody)
+	}
+	log.Printf("Command %s '%s' answered with status %d in %s", id, r.FormValue("text"), resp.StatusCode, time.Since(start))
+	writeJson(w, map[string]interface{}{
+		"id":       id,
+		"status":   resp.StatusCode,
+		"response": msg,
+	})
+}
+
+// Delayed response of a command
+func response(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/response/")
+	responsesMux.Lock()
+	_, ok := responses[id]
+	attempts[id]++
+	attempt := attempts[id]
+	responsesMux.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "expired_url")
+		return
+	}
+	if attempt <= failures {
+		log.Printf("Failing post %d of response %s", attempt, id)
+		if attempt == 1 {
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusTooManyRequests, "rate_limited")
+		} else {
+			writeError(w, http.StatusInternalServerError, "internal_error")
+		}
+		return
+	}
+	record(w, r, id)
+}
+
+func webhook(w http.ResponseWriter, r *http.Request) {
+	record(w, r, "webhook")
+}
+
+func record(w http.ResponseWriter, r *http.Request, id string) {
+	var msg map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_payload")
+		return
+	}
+	responsesMux.Lock()
+	responses[id] = append(responses[id], msg)
+	log.Printf("Recorded message %d of %s (%s, %d blocks)", len(responses[id]), id, msg["response_type"], blockCount(msg))
+	responsesMux.Unlock()
+	w.Write([]byte("ok"))
+}
+
+func listResponses(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/responses/")
+	responsesMux.Lock()
+	defer responsesMux.Unlock()
+	msgs, ok := responses[id]
+	if !ok {
+		msgs = make([]interface{}, 0)
+	}
+	writeJson(w, msgs)
+}
+
+func listAttempts(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/attempts/")
+	responsesMux.Lock()
+	defer responsesMux.Unlock()
+	writeJson(w, map[string]interface{}{"attempts": attempts[id]})
+}
+
+func blockCount(msg map[string]interface{}) int {
+	blocks, _ := msg["blocks"].([]interface{})
+	return len(blocks)
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": msg})
+}